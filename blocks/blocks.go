@@ -21,19 +21,39 @@ func NewBlock(data []byte) *Block {
 	return &Block{Data: data, Multihash: u.Hash(data)}
 }
 
+// ErrWrongHash is returned when data does not match the hash it is
+// supposed to have.
+var ErrWrongHash = errors.New("Data did not match given hash!")
+
 // NewBlockWithHash creates a new block when the hash of the data
 // is already known, this is used to save time in situations where
 // we are able to be confident that the data is correct
 func NewBlockWithHash(data []byte, h mh.Multihash) (*Block, error) {
 	if u.Debug {
-		chk := u.Hash(data)
-		if string(chk) != string(h) {
-			return nil, errors.New("Data did not match given hash!")
+		if err := VerifyHash(data, h); err != nil {
+			return nil, err
 		}
 	}
 	return &Block{Data: data, Multihash: h}, nil
 }
 
+// VerifyHash checks that h is the hash of data, computing it with the
+// function and length that h declares.
+func VerifyHash(data []byte, h mh.Multihash) error {
+	dh, err := mh.Decode(h)
+	if err != nil {
+		return err
+	}
+	chk, err := u.Sum(data, dh.Code, dh.Length)
+	if err != nil {
+		return err
+	}
+	if string(chk) != string(h) {
+		return ErrWrongHash
+	}
+	return nil
+}
+
 // Key returns the block's Multihash as a Key value.
 func (b *Block) Key() u.Key {
 	return u.Key(b.Multihash)
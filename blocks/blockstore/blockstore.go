@@ -4,6 +4,7 @@ package blockstore
 
 import (
 	"errors"
+	"sync"
 
 	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
 	dsns "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/namespace"
@@ -13,6 +14,7 @@ import (
 	blocks "github.com/ipfs/go-ipfs/blocks"
 	eventlog "github.com/ipfs/go-ipfs/thirdparty/eventlog"
 	u "github.com/ipfs/go-ipfs/util"
+	ds2 "github.com/ipfs/go-ipfs/util/datastore2"
 )
 
 var log = eventlog.Logger("blockstore")
@@ -30,14 +32,36 @@ type Blockstore interface {
 	Has(u.Key) (bool, error)
 	Get(u.Key) (*blocks.Block, error)
 	Put(*blocks.Block) error
+	PutMany([]*blocks.Block) error
 
 	AllKeysChan(ctx context.Context) (<-chan u.Key, error)
 }
 
-func NewBlockstore(d ds.ThreadSafeDatastore) Blockstore {
+// GCBlockstore is a Blockstore that coordinates garbage collection with
+// operations that write blocks and then pin them.
+type GCBlockstore interface {
+	Blockstore
+
+	// GCLock locks the blockstore for garbage collection. No operation
+	// that writes blocks expecting to pin them afterwards may run while
+	// it is held. It returns the function that releases the lock.
+	GCLock() func()
+
+	// PinLock locks the blockstore for a sequence of writes that ends with
+	// a pin. Any number of such sequences may hold the lock at the same
+	// time, but garbage collection waits for all of them to finish. It
+	// returns the function that releases the lock.
+	//
+	// PinLock must not be taken again by a holder of the lock: a pending
+	// GCLock would cause the second call to block forever.
+	PinLock() func()
+}
+
+func NewBlockstore(d ds.ThreadSafeDatastore) GCBlockstore {
 	dd := dsns.Wrap(d, BlockPrefix)
 	return &blockstore{
 		datastore: dd,
+		child:     d,
 	}
 }
 
@@ -45,6 +69,11 @@ type blockstore struct {
 	datastore ds.Datastore
 	// cant be ThreadSafeDatastore cause namespace.Datastore doesnt support it.
 	// we do check it on `NewBlockstore` though.
+
+	// child is the datastore below the namespace, batched by PutMany
+	child ds.Datastore
+
+	lk sync.RWMutex
 }
 
 func (bs *blockstore) Get(k u.Key) (*blocks.Block, error) {
@@ -60,7 +89,11 @@ func (bs *blockstore) Get(k u.Key) (*blocks.Block, error) {
 		return nil, ValueTypeMismatch
 	}
 
-	return blocks.NewBlockWithHash(bdata, mh.Multihash(k))
+	// the key says which hash function to check the data with
+	if err := blocks.VerifyHash(bdata, mh.Multihash(k)); err != nil {
+		return nil, err
+	}
+	return &blocks.Block{Data: bdata, Multihash: mh.Multihash(k)}, nil
 }
 
 func (bs *blockstore) Put(block *blocks.Block) error {
@@ -74,6 +107,28 @@ func (bs *blockstore) Put(block *blocks.Block) error {
 	return bs.datastore.Put(k, block.Data)
 }
 
+// PutMany stores all blocks not already in the datastore with a single
+// datastore batch.
+func (bs *blockstore) PutMany(blocks []*blocks.Block) error {
+	t, err := ds2.BatchFor(bs.child)
+	if err != nil {
+		return err
+	}
+	for _, b := range blocks {
+		k := b.Key().DsKey()
+		exists, err := bs.datastore.Has(k)
+		if err == nil && exists {
+			continue
+		}
+
+		err = t.Put(BlockPrefix.Child(k), b.Data)
+		if err != nil {
+			return err
+		}
+	}
+	return t.Commit()
+}
+
 func (bs *blockstore) Has(k u.Key) (bool, error) {
 	return bs.datastore.Has(k.DsKey())
 }
@@ -82,6 +137,16 @@ func (s *blockstore) DeleteBlock(k u.Key) error {
 	return s.datastore.Delete(k.DsKey())
 }
 
+func (bs *blockstore) GCLock() func() {
+	bs.lk.Lock()
+	return bs.lk.Unlock
+}
+
+func (bs *blockstore) PinLock() func() {
+	bs.lk.RLock()
+	return bs.lk.RUnlock
+}
+
 // AllKeysChan runs a query for keys from the blockstore.
 // this is very simplistic, in the future, take dsq.Query as a param?
 //
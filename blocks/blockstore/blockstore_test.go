@@ -45,6 +45,33 @@ func TestPutThenGetBlock(t *testing.T) {
 	}
 }
 
+func TestPutManyThenGetBlocks(t *testing.T) {
+	bs := NewBlockstore(ds_sync.MutexWrap(ds.NewMapDatastore()))
+
+	var bks []*blocks.Block
+	for i := 0; i < 10; i++ {
+		bks = append(bks, blocks.NewBlock([]byte(fmt.Sprintf("some data %d", i))))
+	}
+	// a block that is already stored is skipped
+	if err := bs.Put(bks[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := bs.PutMany(bks); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, b := range bks {
+		got, err := bs.Get(b.Key())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b.Data, got.Data) {
+			t.Fatal("got wrong data for", b.Key())
+		}
+	}
+}
+
 func newBlockStoreWithKeys(t *testing.T, d ds.Datastore, N int) (Blockstore, []u.Key) {
 	if d == nil {
 		d = ds.NewMapDatastore()
@@ -204,6 +231,35 @@ func TestAllKeysRespectsContext(t *testing.T) {
 
 }
 
+func TestGetVerifiesWithKeyHashFunc(t *testing.T) {
+	data := []byte("some data")
+	d := ds.NewMapDatastore()
+	bs := NewBlockstore(ds_sync.MutexWrap(d))
+
+	for _, code := range u.HashFuncs {
+		h, err := u.Sum(data, code, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block, err := blocks.NewBlockWithHash(data, h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := bs.Put(block); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := bs.Get(block.Key()); err != nil {
+			t.Fatal(err)
+		}
+
+		// corrupt the stored data
+		d.Put(BlockPrefix.Child(block.Key().DsKey()), []byte("other data"))
+		if _, err := bs.Get(block.Key()); err != blocks.ErrWrongHash {
+			t.Fatal("expected the corrupt block to fail verification, got", err)
+		}
+	}
+}
+
 func TestValueTypeMismatch(t *testing.T) {
 	block := blocks.NewBlock([]byte("some data"))
 
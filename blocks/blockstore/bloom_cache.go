@@ -0,0 +1,138 @@
+package blockstore
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+	"github.com/ipfs/go-ipfs/blocks"
+	"github.com/ipfs/go-ipfs/blocks/bloom"
+	u "github.com/ipfs/go-ipfs/util"
+)
+
+// BloomCached returns a blockstore that keeps a bloom filter of |bloomSize|
+// bytes over the keys in bs, and uses it to answer Has and Get for blocks
+// that are definitely not stored without touching bs. The filter is built
+// from bs.AllKeysChan in the background; until that is done, every call
+// goes to bs.
+func BloomCached(bs GCBlockstore, ctx context.Context, bloomSize int) (*BloomCache, error) {
+	if bloomSize <= 0 {
+		return nil, errors.New("bloom filter size must be positive")
+	}
+	bc := &BloomCache{
+		blockstore: bs,
+		bloom:      bloom.NewFilter(bloomSize),
+	}
+	go bc.build(ctx)
+	return bc, nil
+}
+
+// BloomCache is the blockstore returned by BloomCached.
+type BloomCache struct {
+	blockstore GCBlockstore
+
+	lk    sync.Mutex // bloom.Filter is not thread safe
+	bloom bloom.Filter
+
+	// accessed atomically
+	active int32  // set once the filter covers every stored key
+	hits   uint64 // calls answered by the filter alone
+	misses uint64 // calls passed on to the blockstore
+}
+
+func (b *BloomCache) build(ctx context.Context) {
+	ch, err := b.blockstore.AllKeysChan(ctx)
+	if err != nil {
+		log.Errorf("failed to build bloom filter: %s", err)
+		return
+	}
+	for k := range ch {
+		b.add(k)
+	}
+	if ctx.Err() != nil {
+		log.Debug("bloom filter build cancelled")
+		return
+	}
+	atomic.StoreInt32(&b.active, 1)
+}
+
+func (b *BloomCache) add(k u.Key) {
+	b.lk.Lock()
+	b.bloom.Add([]byte(k))
+	b.lk.Unlock()
+}
+
+// definitelyMissing reports whether the filter rules out k being stored.
+func (b *BloomCache) definitelyMissing(k u.Key) bool {
+	if atomic.LoadInt32(&b.active) == 0 {
+		atomic.AddUint64(&b.misses, 1)
+		return false
+	}
+	b.lk.Lock()
+	found := b.bloom.Find([]byte(k))
+	b.lk.Unlock()
+	if found {
+		atomic.AddUint64(&b.misses, 1)
+		return false
+	}
+	atomic.AddUint64(&b.hits, 1)
+	return true
+}
+
+// Hits returns the number of Has and Get calls answered by the filter.
+func (b *BloomCache) Hits() uint64 {
+	return atomic.LoadUint64(&b.hits)
+}
+
+// Misses returns the number of Has and Get calls the filter passed on to the
+// underlying blockstore.
+func (b *BloomCache) Misses() uint64 {
+	return atomic.LoadUint64(&b.misses)
+}
+
+// DeleteBlock can not remove k from the filter, so later lookups of k will
+// simply go to the blockstore.
+func (b *BloomCache) DeleteBlock(k u.Key) error {
+	return b.blockstore.DeleteBlock(k)
+}
+
+func (b *BloomCache) Has(k u.Key) (bool, error) {
+	if b.definitelyMissing(k) {
+		return false, nil
+	}
+	return b.blockstore.Has(k)
+}
+
+func (b *BloomCache) Get(k u.Key) (*blocks.Block, error) {
+	if b.definitelyMissing(k) {
+		return nil, ErrNotFound
+	}
+	return b.blockstore.Get(k)
+}
+
+func (b *BloomCache) Put(bl *blocks.Block) error {
+	// add to the filter first, so that a concurrent Has can never miss a
+	// block that is already in the blockstore.
+	b.add(bl.Key())
+	return b.blockstore.Put(bl)
+}
+
+func (b *BloomCache) PutMany(bs []*blocks.Block) error {
+	for _, bl := range bs {
+		b.add(bl.Key())
+	}
+	return b.blockstore.PutMany(bs)
+}
+
+func (b *BloomCache) AllKeysChan(ctx context.Context) (<-chan u.Key, error) {
+	return b.blockstore.AllKeysChan(ctx)
+}
+
+func (b *BloomCache) GCLock() func() {
+	return b.blockstore.GCLock()
+}
+
+func (b *BloomCache) PinLock() func() {
+	return b.blockstore.PinLock()
+}
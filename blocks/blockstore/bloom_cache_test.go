@@ -0,0 +1,83 @@
+package blockstore
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
+	syncds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/sync"
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+	"github.com/ipfs/go-ipfs/blocks"
+)
+
+func waitBloomActive(t *testing.T, bc *BloomCache) {
+	deadline := time.Now().Add(time.Second * 5)
+	for atomic.LoadInt32(&bc.active) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("bloom filter was not built in time")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func TestReturnsErrorWhenBloomSizeNotPositive(t *testing.T) {
+	bs := NewBlockstore(syncds.MutexWrap(ds.NewMapDatastore()))
+	_, err := BloomCached(bs, context.Background(), 0)
+	if err == nil {
+		t.Fail()
+	}
+}
+
+func TestBloomHasMissDoesNotHitDatastore(t *testing.T) {
+	cd := &callbackDatastore{f: func() {}, ds: ds.NewMapDatastore()}
+	bs := NewBlockstore(syncds.MutexWrap(cd))
+
+	stored := blocks.NewBlock([]byte("stored before the filter was built"))
+	if err := bs.Put(stored); err != nil {
+		t.Fatal(err)
+	}
+
+	bc, err := BloomCached(bs, context.Background(), 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitBloomActive(t, bc)
+
+	added := blocks.NewBlock([]byte("added through the cache"))
+	if err := bc.Put(added); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, b := range []*blocks.Block{stored, added} {
+		has, err := bc.Has(b.Key())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !has {
+			t.Fatalf("%s should be in the blockstore", b.Key())
+		}
+	}
+
+	cd.SetFunc(func() {
+		t.Fatal("definite miss hit the datastore")
+	})
+	missing := blocks.NewBlock([]byte("never stored"))
+	has, err := bc.Has(missing.Key())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if has {
+		t.Fatal("block should not be in the blockstore")
+	}
+	if _, err := bc.Get(missing.Key()); err != ErrNotFound {
+		t.Fatal("expected ErrNotFound, got", err)
+	}
+
+	if bc.Hits() != 2 {
+		t.Fatal("expected 2 hits, got", bc.Hits())
+	}
+	if bc.Misses() != 2 {
+		t.Fatal("expected 2 misses, got", bc.Misses())
+	}
+}
@@ -0,0 +1,53 @@
+package blockstore
+
+import (
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+	"github.com/ipfs/go-ipfs/blocks"
+	u "github.com/ipfs/go-ipfs/util"
+)
+
+// NotifyDeletes returns a blockstore that calls deleted with the key of
+// every block deleted through it, once the block is gone. Caches of data
+// derived from the blocks use it to drop what gc removed.
+func NotifyDeletes(bs GCBlockstore, deleted func(u.Key)) GCBlockstore {
+	return &notifyDeletes{blockstore: bs, deleted: deleted}
+}
+
+type notifyDeletes struct {
+	blockstore GCBlockstore
+	deleted    func(u.Key)
+}
+
+func (n *notifyDeletes) DeleteBlock(k u.Key) error {
+	err := n.blockstore.DeleteBlock(k)
+	n.deleted(k)
+	return err
+}
+
+func (n *notifyDeletes) Has(k u.Key) (bool, error) {
+	return n.blockstore.Has(k)
+}
+
+func (n *notifyDeletes) Get(k u.Key) (*blocks.Block, error) {
+	return n.blockstore.Get(k)
+}
+
+func (n *notifyDeletes) Put(b *blocks.Block) error {
+	return n.blockstore.Put(b)
+}
+
+func (n *notifyDeletes) PutMany(bs []*blocks.Block) error {
+	return n.blockstore.PutMany(bs)
+}
+
+func (n *notifyDeletes) AllKeysChan(ctx context.Context) (<-chan u.Key, error) {
+	return n.blockstore.AllKeysChan(ctx)
+}
+
+func (n *notifyDeletes) GCLock() func() {
+	return n.blockstore.GCLock()
+}
+
+func (n *notifyDeletes) PinLock() func() {
+	return n.blockstore.PinLock()
+}
@@ -0,0 +1,231 @@
+package blockstore
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+	"github.com/ipfs/go-ipfs/blocks"
+	u "github.com/ipfs/go-ipfs/util"
+)
+
+// ReadCached returns a blockstore that keeps up to |maxBytes| of block data
+// read through bs.Get in memory.
+//
+// Eviction follows the 2Q policy: blocks read once enter a small FIFO queue,
+// and only blocks read again after falling out of it are admitted to the
+// main LRU queue. This keeps a single large read, such as a full `ipfs cat`,
+// from flushing out the blocks the gateway or bitswap keep asking for.
+//
+// Get returns the cached blocks themselves, not copies: callers must not
+// modify the blocks they get.
+func ReadCached(bs GCBlockstore, maxBytes int) (*ReadCache, error) {
+	if maxBytes <= 0 {
+		return nil, errors.New("read cache size must be positive")
+	}
+	return &ReadCache{
+		blockstore: bs,
+		maxBytes:   maxBytes,
+		inBytes:    maxBytes / 4,
+		ghostBytes: maxBytes / 2,
+		entries:    make(map[u.Key]*list.Element),
+		ghosts:     make(map[u.Key]*list.Element),
+		in:         list.New(),
+		main:       list.New(),
+		ghost:      list.New(),
+	}, nil
+}
+
+// ReadCache is the blockstore returned by ReadCached.
+type ReadCache struct {
+	blockstore GCBlockstore
+
+	maxBytes   int // bound on the data held by in and main
+	inBytes    int // share of maxBytes reserved for in
+	ghostBytes int // bound on the sizes of the blocks remembered in ghost
+
+	lk       sync.Mutex
+	entries  map[u.Key]*list.Element // elements of in and main
+	ghosts   map[u.Key]*list.Element // elements of ghost
+	in       *list.List              // FIFO of blocks read once
+	main     *list.List              // LRU of blocks read again
+	ghost    *list.List              // FIFO of keys recently evicted from in
+	size     int                     // bytes held by in and main
+	curIn    int                     // bytes held by in
+	curGhost int                     // sizes of the blocks in ghost
+	hits     uint64
+	misses   uint64
+	// deletes counts the calls to DeleteBlock, so that a Get does not
+	// cache a block deleted while it was reading it.
+	deletes uint64
+}
+
+type readCacheEntry struct {
+	block  *blocks.Block
+	inMain bool
+}
+
+type ghostEntry struct {
+	key  u.Key
+	size int
+}
+
+// Hits returns the number of Get calls served from memory.
+func (c *ReadCache) Hits() uint64 {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	return c.hits
+}
+
+// Misses returns the number of Get calls passed on to the underlying
+// blockstore.
+func (c *ReadCache) Misses() uint64 {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	return c.misses
+}
+
+// Size returns the number of bytes of block data held in memory.
+func (c *ReadCache) Size() int {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	return c.size
+}
+
+func (c *ReadCache) Get(k u.Key) (*blocks.Block, error) {
+	c.lk.Lock()
+	if e, ok := c.entries[k]; ok {
+		ent := e.Value.(*readCacheEntry)
+		if ent.inMain {
+			c.main.MoveToFront(e)
+		}
+		c.hits++
+		c.lk.Unlock()
+		return ent.block, nil
+	}
+	c.misses++
+	deletes := c.deletes
+	c.lk.Unlock()
+
+	b, err := c.blockstore.Get(k)
+	if err != nil {
+		return nil, err
+	}
+
+	c.lk.Lock()
+	if c.deletes == deletes {
+		c.insert(b)
+	}
+	c.lk.Unlock()
+	return b, nil
+}
+
+// insert adds b to the cache. Caller must hold lk.
+func (c *ReadCache) insert(b *blocks.Block) {
+	k := b.Key()
+	size := len(b.Data)
+	if _, ok := c.entries[k]; ok || size > c.maxBytes {
+		return
+	}
+
+	ent := &readCacheEntry{block: b}
+	if g, ok := c.ghosts[k]; ok {
+		// read again shortly after it was evicted: it is hot.
+		c.removeGhost(g)
+		ent.inMain = true
+		c.entries[k] = c.main.PushFront(ent)
+	} else {
+		c.entries[k] = c.in.PushFront(ent)
+		c.curIn += size
+	}
+	c.size += size
+	c.evict()
+}
+
+// evict drops blocks until the cache fits in maxBytes. Caller must hold lk.
+func (c *ReadCache) evict() {
+	for c.size > c.maxBytes {
+		if c.curIn > c.inBytes || c.main.Len() == 0 {
+			e := c.in.Back()
+			ent := e.Value.(*readCacheEntry)
+			c.removeEntry(e)
+
+			// remember the key, so a second read promotes it to main.
+			g := &ghostEntry{key: ent.block.Key(), size: len(ent.block.Data)}
+			c.ghosts[g.key] = c.ghost.PushFront(g)
+			c.curGhost += g.size
+			for c.curGhost > c.ghostBytes {
+				c.removeGhost(c.ghost.Back())
+			}
+		} else {
+			c.removeEntry(c.main.Back())
+		}
+	}
+}
+
+// removeEntry removes e from in or main. Caller must hold lk.
+func (c *ReadCache) removeEntry(e *list.Element) {
+	ent := e.Value.(*readCacheEntry)
+	size := len(ent.block.Data)
+	if ent.inMain {
+		c.main.Remove(e)
+	} else {
+		c.in.Remove(e)
+		c.curIn -= size
+	}
+	c.size -= size
+	delete(c.entries, ent.block.Key())
+}
+
+// removeGhost removes e from ghost. Caller must hold lk.
+func (c *ReadCache) removeGhost(e *list.Element) {
+	g := e.Value.(*ghostEntry)
+	c.ghost.Remove(e)
+	c.curGhost -= g.size
+	delete(c.ghosts, g.key)
+}
+
+// DeleteBlock deletes the block from the underlying blockstore first, and
+// then from the cache, so that no Get can cache it again in between.
+func (c *ReadCache) DeleteBlock(k u.Key) error {
+	err := c.blockstore.DeleteBlock(k)
+
+	c.lk.Lock()
+	if e, ok := c.entries[k]; ok {
+		c.removeEntry(e)
+	}
+	c.deletes++
+	c.lk.Unlock()
+	return err
+}
+
+func (c *ReadCache) Has(k u.Key) (bool, error) {
+	c.lk.Lock()
+	_, ok := c.entries[k]
+	c.lk.Unlock()
+	if ok {
+		return true, nil
+	}
+	return c.blockstore.Has(k)
+}
+
+func (c *ReadCache) Put(b *blocks.Block) error {
+	return c.blockstore.Put(b)
+}
+
+func (c *ReadCache) PutMany(bs []*blocks.Block) error {
+	return c.blockstore.PutMany(bs)
+}
+
+func (c *ReadCache) AllKeysChan(ctx context.Context) (<-chan u.Key, error) {
+	return c.blockstore.AllKeysChan(ctx)
+}
+
+func (c *ReadCache) GCLock() func() {
+	return c.blockstore.GCLock()
+}
+
+func (c *ReadCache) PinLock() func() {
+	return c.blockstore.PinLock()
+}
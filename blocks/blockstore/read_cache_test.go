@@ -0,0 +1,152 @@
+package blockstore
+
+import (
+	"fmt"
+	"testing"
+
+	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
+	syncds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/sync"
+	"github.com/ipfs/go-ipfs/blocks"
+	u "github.com/ipfs/go-ipfs/util"
+)
+
+func TestReturnsErrorWhenReadCacheSizeNotPositive(t *testing.T) {
+	bs := NewBlockstore(syncds.MutexWrap(ds.NewMapDatastore()))
+	_, err := ReadCached(bs, 0)
+	if err == nil {
+		t.Fail()
+	}
+}
+
+func TestReadCacheServesRepeatedReads(t *testing.T) {
+	cd := &callbackDatastore{f: func() {}, ds: ds.NewMapDatastore()}
+	bs := NewBlockstore(syncds.MutexWrap(cd))
+	cachedbs, err := ReadCached(bs, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := blocks.NewBlock([]byte("foo"))
+	if err := cachedbs.Put(b); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cachedbs.Get(b.Key()); err != nil {
+		t.Fatal(err)
+	}
+
+	cd.SetFunc(func() {
+		t.Fatal("cached read hit the datastore")
+	})
+	if _, err := cachedbs.Get(b.Key()); err != nil {
+		t.Fatal(err)
+	}
+	if cachedbs.Hits() != 1 || cachedbs.Misses() != 1 {
+		t.Fatalf("expected 1 hit and 1 miss, got %d and %d", cachedbs.Hits(), cachedbs.Misses())
+	}
+}
+
+func TestReadCacheRemovesEntryOnDelete(t *testing.T) {
+	bs := NewBlockstore(syncds.MutexWrap(ds.NewMapDatastore()))
+	cachedbs, err := ReadCached(bs, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := blocks.NewBlock([]byte("foo"))
+	cachedbs.Put(b)
+	cachedbs.Get(b.Key())
+	if err := cachedbs.DeleteBlock(b.Key()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cachedbs.Get(b.Key()); err != ErrNotFound {
+		t.Fatal("expected ErrNotFound, got", err)
+	}
+	if cachedbs.Size() != 0 {
+		t.Fatal("deleted block still counts towards the cache size")
+	}
+}
+
+// getHookBlockstore calls afterGet once a Get has read from the blockstore.
+type getHookBlockstore struct {
+	GCBlockstore
+	afterGet func()
+}
+
+func (bs *getHookBlockstore) Get(k u.Key) (*blocks.Block, error) {
+	b, err := bs.GCBlockstore.Get(k)
+	bs.afterGet()
+	return b, err
+}
+
+func TestReadCacheSkipsBlocksDeletedDuringGet(t *testing.T) {
+	hooked := &getHookBlockstore{
+		GCBlockstore: NewBlockstore(syncds.MutexWrap(ds.NewMapDatastore())),
+		afterGet:     func() {},
+	}
+	cachedbs, err := ReadCached(hooked, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := blocks.NewBlock([]byte("foo"))
+	if err := cachedbs.Put(b); err != nil {
+		t.Fatal(err)
+	}
+	hooked.afterGet = func() {
+		hooked.afterGet = func() {}
+		if err := cachedbs.DeleteBlock(b.Key()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := cachedbs.Get(b.Key()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cachedbs.Get(b.Key()); err != ErrNotFound {
+		t.Fatal("expected the block deleted during the first Get not to be cached, got", err)
+	}
+}
+
+func TestReadCacheStaysWithinSize(t *testing.T) {
+	bs := NewBlockstore(syncds.MutexWrap(ds.NewMapDatastore()))
+	const max = 100
+	cachedbs, err := ReadCached(bs, max)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var bks []*blocks.Block
+	for i := 0; i < 50; i++ {
+		b := blocks.NewBlock([]byte(fmt.Sprintf("block number %d", i)))
+		bs.Put(b)
+		bks = append(bks, b)
+	}
+
+	// read everything twice, so some blocks make it to the main queue.
+	for j := 0; j < 2; j++ {
+		for _, b := range bks {
+			if _, err := cachedbs.Get(b.Key()); err != nil {
+				t.Fatal(err)
+			}
+			if cachedbs.Size() > max {
+				t.Fatalf("cache holds %d bytes, more than %d", cachedbs.Size(), max)
+			}
+		}
+	}
+}
+
+func TestReadCacheSkipsBlocksLargerThanCache(t *testing.T) {
+	bs := NewBlockstore(syncds.MutexWrap(ds.NewMapDatastore()))
+	cachedbs, err := ReadCached(bs, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := blocks.NewBlock([]byte("this block is too big"))
+	bs.Put(b)
+	if _, err := cachedbs.Get(b.Key()); err != nil {
+		t.Fatal(err)
+	}
+	if cachedbs.Size() != 0 {
+		t.Fatal("oversized block was cached")
+	}
+}
@@ -8,7 +8,7 @@ import (
 )
 
 // WriteCached returns a blockstore that caches up to |size| unique writes (bs.Put).
-func WriteCached(bs Blockstore, size int) (Blockstore, error) {
+func WriteCached(bs GCBlockstore, size int) (GCBlockstore, error) {
 	c, err := lru.New(size)
 	if err != nil {
 		return nil, err
@@ -18,7 +18,7 @@ func WriteCached(bs Blockstore, size int) (Blockstore, error) {
 
 type writecache struct {
 	cache      *lru.Cache // pointer b/c Cache contains a Mutex as value (complicates copying)
-	blockstore Blockstore
+	blockstore GCBlockstore
 }
 
 func (w *writecache) DeleteBlock(k u.Key) error {
@@ -45,6 +45,33 @@ func (w *writecache) Put(b *blocks.Block) error {
 	return w.blockstore.Put(b)
 }
 
+func (w *writecache) PutMany(bs []*blocks.Block) error {
+	var good []*blocks.Block
+	for _, b := range bs {
+		if _, ok := w.cache.Get(b.Key()); !ok {
+			good = append(good, b)
+		}
+	}
+	if len(good) == 0 {
+		return nil
+	}
+	if err := w.blockstore.PutMany(good); err != nil {
+		return err
+	}
+	for _, b := range good {
+		w.cache.Add(b.Key(), struct{}{})
+	}
+	return nil
+}
+
 func (w *writecache) AllKeysChan(ctx context.Context) (<-chan u.Key, error) {
 	return w.blockstore.AllKeysChan(ctx)
 }
+
+func (w *writecache) GCLock() func() {
+	return w.blockstore.GCLock()
+}
+
+func (w *writecache) PinLock() func() {
+	return w.blockstore.PinLock()
+}
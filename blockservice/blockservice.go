@@ -76,6 +76,24 @@ func (s *BlockService) AddBlock(b *blocks.Block) (u.Key, error) {
 	return k, nil
 }
 
+// AddBlocks adds a list of blocks to the service, Putting them into the
+// datastore with a single batch.
+func (s *BlockService) AddBlocks(bs []*blocks.Block) ([]u.Key, error) {
+	err := s.Blockstore.PutMany(bs)
+	if err != nil {
+		return nil, err
+	}
+
+	var ks []u.Key
+	for _, b := range bs {
+		if err := s.worker.HasBlock(b); err != nil {
+			return nil, errors.New("blockservice is closed")
+		}
+		ks = append(ks, b.Key())
+	}
+	return ks, nil
+}
+
 // GetBlock retrieves a particular block from the service,
 // Getting it from the datastore using the key (hash).
 func (s *BlockService) GetBlock(ctx context.Context, k u.Key) (*blocks.Block, error) {
@@ -14,6 +14,7 @@ import (
 	"github.com/ipfs/go-ipfs/core"
 	commands "github.com/ipfs/go-ipfs/core/commands"
 	corehttp "github.com/ipfs/go-ipfs/core/corehttp"
+	corerepo "github.com/ipfs/go-ipfs/core/corerepo"
 	"github.com/ipfs/go-ipfs/core/corerouting"
 	peer "github.com/ipfs/go-ipfs/p2p/peer"
 	fsrepo "github.com/ipfs/go-ipfs/repo/fsrepo"
@@ -29,6 +30,7 @@ const (
 	ipfsMountKwd              = "mount-ipfs"
 	ipnsMountKwd              = "mount-ipns"
 	unrestrictedApiAccess     = "unrestricted-api"
+	migrateKwd                = "migrate"
 	// apiAddrKwd    = "address-api"
 	// swarmAddrKwd  = "address-swarm"
 )
@@ -43,6 +45,10 @@ communicating with a daemon over the HTTP API. While the daemon is
 running, calls to 'ipfs' commands will be sent over the network to
 the daemon.
 
+If the repo was created by an older version of ipfs, the daemon only
+starts with --migrate, which migrates it first and keeps a backup of
+the old repo. 'ipfs repo migrate' migrates it without starting.
+
 The daemon will start listening on ports on the network, which are
 documented in (and can be modified through) 'ipfs config Addresses'.
 For example, to change the 'Gateway' port:
@@ -73,6 +79,7 @@ the port as you would other services or database (firewall, authenticated proxy,
 		cmds.StringOption(ipfsMountKwd, "Path to the mountpoint for IPFS (if using --mount)"),
 		cmds.StringOption(ipnsMountKwd, "Path to the mountpoint for IPNS (if using --mount)"),
 		cmds.BoolOption(unrestrictedApiAccess, "Allow API access to unlisted hashes"),
+		cmds.BoolOption(migrateKwd, "Run pending repo migrations before starting"),
 
 		// TODO: add way to override addresses. tricky part: updating the config if also --init.
 		// cmds.StringOption(apiAddrKwd, "Address for the daemon rpc API (overrides config)"),
@@ -82,6 +89,35 @@ the port as you would other services or database (firewall, authenticated proxy,
 	Run:         daemonFunc,
 }
 
+// runMigrations runs the migrations the repo needs before it can be
+// opened if the user passed --migrate, and returns an error explaining how
+// to run them otherwise. It never asks, so the daemon does not wait for an
+// answer on a stdin nobody writes to.
+func runMigrations(req cmds.Request) error {
+	repoPath := req.Context().ConfigRoot
+	steps, err := fsrepo.PendingMigrations(repoPath)
+	if err != nil || len(steps) == 0 {
+		return err
+	}
+
+	migrate, _, err := req.Option(migrateKwd).Bool()
+	if err != nil {
+		return err
+	}
+	if !migrate {
+		return fmt.Errorf("the repo at %s is at version %d and needs to be migrated to version %d. "+
+			"Run 'ipfs daemon --%s' to migrate it, keeping a backup in %s, or 'ipfs repo migrate'",
+			repoPath, steps[0].From, steps[len(steps)-1].To, migrateKwd, fsrepo.BackupPath(repoPath, steps[0].From))
+	}
+
+	steps, err = fsrepo.Migrate(repoPath, fsrepo.RepoVersion, fsrepo.MigrateOptions{Backup: true})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Migrated repo to version %d\n", steps[len(steps)-1].To)
+	return nil
+}
+
 // defaultMux tells mux to serve path using the default muxer. This is
 // mostly useful to hook up things that register in the default muxer,
 // and don't provide a convenient http.Handler entry point, such as
@@ -129,6 +165,11 @@ func daemonFunc(req cmds.Request, res cmds.Response) {
 		}
 	}
 
+	if err := runMigrations(req); err != nil {
+		res.SetError(err, cmds.ErrNormal)
+		return
+	}
+
 	// acquire the repo lock _before_ constructing a node. we need to make
 	// sure we are permitted to access the resources (datastore, etc.)
 	repo, err := fsrepo.Open(req.Context().ConfigRoot)
@@ -192,6 +233,21 @@ func daemonFunc(req cmds.Request, res cmds.Response) {
 		return node, nil
 	}
 
+	// collect garbage whenever the repo grows above its configured limit
+	go func() {
+		if err := corerepo.PeriodicGC(node, node.Context()); err != nil {
+			log.Error("automatic garbage collection: ", err)
+		}
+	}()
+
+	// remove pins added with a ttl once they expire
+	go corerepo.PeriodicUnpinExpired(node, node.Context())
+
+	// finish the background pins a previous daemon was fetching
+	if err := corerepo.ResumeBackgroundPins(node); err != nil {
+		log.Error("resuming background pins: ", err)
+	}
+
 	// verify api address is valid multiaddr
 	apiMaddr, err := ma.NewMultiaddr(cfg.Addresses.API)
 	if err != nil {
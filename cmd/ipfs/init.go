@@ -7,6 +7,8 @@ import (
 	"io"
 	"os"
 	"path"
+	"path/filepath"
+	"strings"
 
 	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
 	assets "github.com/ipfs/go-ipfs/assets"
@@ -31,6 +33,8 @@ var initCmd = &cmds.Command{
 	Options: []cmds.Option{
 		cmds.IntOption("bits", "b", fmt.Sprintf("Number of bits to use in the generated RSA private key (defaults to %d)", nBitsForKeypairDefault)),
 		cmds.BoolOption("force", "f", "Overwrite existing config (if it exists)"),
+		cmds.BoolOption("encrypt", "Encrypt the datastore at rest (passphrase from $"+fsrepo.PassphraseEnv+")"),
+		cmds.StringOption("key-file", "Derive the datastore key from this file instead of the passphrase (implies --encrypt)"),
 
 		// TODO need to decide whether to expose the override as a file or a
 		// directory. That is: should we allow the user to also specify the
@@ -73,7 +77,19 @@ var initCmd = &cmds.Command{
 			nBitsForKeypair = nBitsForKeypairDefault
 		}
 
-		if err := doInit(os.Stdout, req.Context().ConfigRoot, force, nBitsForKeypair); err != nil {
+		var enc encryptOptions
+		enc.Encrypt, _, err = req.Option("encrypt").Bool()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+		enc.KeyFile, _, err = req.Option("key-file").String()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		if err := doInit(os.Stdout, req.Context().ConfigRoot, force, nBitsForKeypair, enc); err != nil {
 			res.SetError(err, cmds.ErrNormal)
 			return
 		}
@@ -85,11 +101,37 @@ Reinitializing would overwrite your keys.
 (use -f to force overwrite)
 `)
 
+// encryptOptions sets up the encryption of a new repo's datastore.
+type encryptOptions struct {
+	Encrypt bool
+	KeyFile string
+}
+
+// keyFileConfig returns the Datastore.KeyFile naming the key file at p,
+// relative to the working directory. Key files inside the repo are named
+// relative to the repo root, so that they move with it; others by their
+// absolute path.
+func keyFileConfig(repoRoot, p string) (string, error) {
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return "", err
+	}
+	root, err := filepath.Abs(repoRoot)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(root, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return abs, nil
+	}
+	return rel, nil
+}
+
 func initWithDefaults(out io.Writer, repoRoot string) error {
-	return doInit(out, repoRoot, false, nBitsForKeypairDefault)
+	return doInit(out, repoRoot, false, nBitsForKeypairDefault, encryptOptions{})
 }
 
-func doInit(out io.Writer, repoRoot string, force bool, nBitsForKeypair int) error {
+func doInit(out io.Writer, repoRoot string, force bool, nBitsForKeypair int, enc encryptOptions) error {
 	if _, err := fmt.Fprintf(out, "initializing ipfs node at %s\n", repoRoot); err != nil {
 		return err
 	}
@@ -107,6 +149,17 @@ func doInit(out io.Writer, repoRoot string, force bool, nBitsForKeypair int) err
 		return err
 	}
 
+	if enc.Encrypt || enc.KeyFile != "" {
+		if enc.KeyFile != "" {
+			if conf.Datastore.KeyFile, err = keyFileConfig(repoRoot, enc.KeyFile); err != nil {
+				return err
+			}
+		}
+		for i := range conf.Datastore.Mounts {
+			conf.Datastore.Mounts[i].Encrypted = true
+		}
+	}
+
 	if fsrepo.IsInitialized(repoRoot) {
 		if err := fsrepo.Remove(repoRoot); err != nil {
 			return err
@@ -183,7 +236,10 @@ func addDefaultAssets(out io.Writer, repoRoot string) error {
 		}
 	}
 
-	dir := dirb.GetNode()
+	dir, err := dirb.GetNode()
+	if err != nil {
+		return err
+	}
 	dkey, err := nd.DAG.Add(dir)
 	if err != nil {
 		return err
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestKeyFileConfig(t *testing.T) {
+	root, err := filepath.Abs("repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		path, expected string
+	}{
+		{filepath.Join(root, "key"), "key"},
+		{filepath.Join(root, "keys", "key"), filepath.Join("keys", "key")},
+		{"key", filepath.Join(filepath.Dir(root), "key")},
+		{filepath.Join(root+"-keys", "key"), filepath.Join(root+"-keys", "key")},
+	} {
+		actual, err := keyFileConfig("repo", c.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual != c.expected {
+			t.Errorf("key file %s: expected %s, got %s", c.path, c.expected, actual)
+		}
+	}
+}
@@ -110,4 +110,9 @@ var cmdDetailsMap = map[*cmds.Command]cmdDetails{
 	commands.UpdateCheckCmd:    {preemptsAutoUpdate: true},
 	commands.UpdateLogCmd:      {preemptsAutoUpdate: true},
 	commands.LogCmd:            {cannotRunOnClient: true},
+	commands.RepoImportCmd:     {cannotRunOnDaemon: true},
+
+	// the repo can not be opened until it is migrated; migrating takes
+	// the repo lock by itself.
+	commands.RepoMigrateCmd: {doesNotUseConfigAsInput: true, cannotRunOnDaemon: true, doesNotUseRepo: true},
 }
@@ -1,22 +1,28 @@
 package commands
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"os"
 	"path"
+	"path/filepath"
 	"strings"
 
 	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/cheggaaa/pb"
+	mh "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-multihash"
 	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
 
 	cmds "github.com/ipfs/go-ipfs/commands"
 	files "github.com/ipfs/go-ipfs/commands/files"
 	core "github.com/ipfs/go-ipfs/core"
-	coreunix "github.com/ipfs/go-ipfs/core/coreunix"
+	corerepo "github.com/ipfs/go-ipfs/core/corerepo"
+	"github.com/ipfs/go-ipfs/core/coreunix"
 	importer "github.com/ipfs/go-ipfs/importer"
 	"github.com/ipfs/go-ipfs/importer/chunk"
+	h "github.com/ipfs/go-ipfs/importer/helpers"
 	dag "github.com/ipfs/go-ipfs/merkledag"
-	ft "github.com/ipfs/go-ipfs/unixfs"
+	uio "github.com/ipfs/go-ipfs/unixfs/io"
 	u "github.com/ipfs/go-ipfs/util"
 )
 
@@ -29,8 +35,37 @@ const progressReaderIncrement = 1024 * 256
 const (
 	progressOptionName = "progress"
 	wrapOptionName     = "wrap-with-directory"
+	nocopyOptionName   = "nocopy"
+	hashOptionName     = "hash"
 )
 
+// hashOption picks the multihash function of the objects a command adds.
+var hashOption = cmds.StringOption(hashOptionName, "Hash function to use: sha2-256 (default), sha2-512, sha3 or blake2b")
+
+// getHashFunc returns the multihash function chosen with hashOption.
+func getHashFunc(req cmds.Request) (int, error) {
+	name, found, err := req.Option(hashOptionName).String()
+	if err != nil {
+		return 0, err
+	}
+	if !found {
+		return mh.SHA2_256, nil
+	}
+	code, ok := u.HashFuncs[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown hash function: %s", name)
+	}
+	return code, nil
+}
+
+// addOptions are the options of a single 'ipfs add'.
+type addOptions struct {
+	progress bool
+	wrap     bool
+	nocopy   bool
+	hash     int // multihash function
+}
+
 type AddedObject struct {
 	Name  string
 	Hash  string `json:",omitempty"`
@@ -45,6 +80,15 @@ Adds contents of <path> to ipfs. Use -r to add directories.
 Note that directories are added recursively, to form the ipfs
 MerkleDAG. A smarter partial add with a staging area (like git)
 remains to be implemented.
+
+With --nocopy, the data of the files is not copied into the repo: the
+repo records where each block of data is in the files, and reads it
+back from there. The files must not be changed or moved afterwards;
+'ipfs filestore verify' reports the blocks that no longer match. While
+the daemon is running, the paths must be absolute.
+
+With --hash, the objects are hashed with another function than the
+default sha2-256: sha2-512, sha3 or blake2b.
 `,
 	},
 
@@ -57,6 +101,8 @@ remains to be implemented.
 		cmds.BoolOption(progressOptionName, "p", "Stream progress data"),
 		cmds.BoolOption(wrapOptionName, "w", "Wrap files with a directory object"),
 		cmds.BoolOption("t", "trickle", "Use trickle-dag format for dag generation"),
+		cmds.BoolOption(nocopyOptionName, "Keep the data in the added files instead of copying it into the repo"),
+		hashOption,
 	},
 	PreRun: func(req cmds.Request) error {
 		if quiet, _, _ := req.Option("quiet").Bool(); quiet {
@@ -88,8 +134,32 @@ remains to be implemented.
 			return
 		}
 
-		progress, _, _ := req.Option(progressOptionName).Bool()
-		wrap, _, _ := req.Option(wrapOptionName).Bool()
+		var opts addOptions
+		opts.progress, _, _ = req.Option(progressOptionName).Bool()
+		opts.wrap, _, _ = req.Option(wrapOptionName).Bool()
+		opts.nocopy, _, _ = req.Option(nocopyOptionName).Bool()
+		if opts.nocopy && n.Filestore == nil {
+			res.SetError(errors.New("this node has no filestore, --nocopy is not available"), cmds.ErrNormal)
+			return
+		}
+		opts.hash, err = getHashFunc(req)
+		if err != nil {
+			res.SetError(err, cmds.ErrClient)
+			return
+		}
+
+		// make room for the new data if needed, or refuse to add it if
+		// it would take the repo above Datastore.StorageMax
+		var size uint64
+		if sizeFile, ok := req.Files().(files.SizeFile); ok {
+			if s, err := sizeFile.Size(); err == nil {
+				size = uint64(s)
+			}
+		}
+		if err := corerepo.ConditionalGC(n, req.Context().Context, size); err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
 
 		outChan := make(chan interface{}, 8)
 		res.SetOutput((<-chan interface{})(outChan))
@@ -107,20 +177,7 @@ remains to be implemented.
 					return
 				}
 
-				rootnd, err := addFile(n, file, outChan, progress, wrap)
-				if err != nil {
-					res.SetError(err, cmds.ErrNormal)
-					return
-				}
-
-				err = n.Pinning.Pin(context.Background(), rootnd, true)
-				if err != nil {
-					res.SetError(err, cmds.ErrNormal)
-					return
-				}
-
-				err = n.Pinning.Flush()
-				if err != nil {
+				if err := addAndPin(n, file, outChan, opts); err != nil {
 					res.SetError(err, cmds.ErrNormal)
 					return
 				}
@@ -213,8 +270,31 @@ remains to be implemented.
 	Type: AddedObject{},
 }
 
-func add(n *core.IpfsNode, reader io.Reader) (*dag.Node, error) {
-	node, err := importer.BuildDagFromReader(reader, n.DAG, nil, chunk.DefaultSplitter)
+// addAndPin adds file and recursively pins the resulting root. The pin lock
+// is held throughout, so that the new blocks can not be garbage collected
+// before the pin is in place.
+func addAndPin(n *core.IpfsNode, file files.File, out chan interface{}, opts addOptions) error {
+	unlock := n.Blockstore.PinLock()
+	defer unlock()
+
+	rootnd, err := addFile(n, file, out, opts)
+	if err != nil {
+		return err
+	}
+
+	err = n.Pinning.Pin(context.Background(), rootnd, true)
+	if err != nil {
+		return err
+	}
+
+	return n.Pinning.Flush()
+}
+
+func add(n *core.IpfsNode, reader io.Reader, hash int) (*dag.Node, error) {
+	node, err := importer.BuildDagFromReaderWithParams(reader, chunk.DefaultSplitter, h.DagBuilderParams{
+		Dagserv:  n.DAG,
+		HashFunc: hash,
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -222,33 +302,76 @@ func add(n *core.IpfsNode, reader io.Reader) (*dag.Node, error) {
 	return node, nil
 }
 
-func addFile(n *core.IpfsNode, file files.File, out chan interface{}, progress bool, wrap bool) (*dag.Node, error) {
+// addNoCopy adds the file at fpath, read from reader, to the filestore.
+func addNoCopy(n *core.IpfsNode, reader io.Reader, fpath string, hash int) (*dag.Node, error) {
+	if fpath == "" {
+		return nil, errors.New("--nocopy needs a file, not standard input")
+	}
+	if !filepath.IsAbs(fpath) {
+		if n.OnlineMode() {
+			return nil, fmt.Errorf("--nocopy needs an absolute path while the daemon is running: %s", fpath)
+		}
+		abs, err := filepath.Abs(fpath)
+		if err != nil {
+			return nil, err
+		}
+		fpath = abs
+	}
+	fi, err := os.Stat(fpath)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.Mode().IsRegular() {
+		return nil, fmt.Errorf("--nocopy can only add regular files: %s", fpath)
+	}
+
+	return importer.BuildDagFromReaderWithParams(reader, chunk.DefaultSplitter, h.DagBuilderParams{
+		Dagserv:   n.DAG,
+		HashFunc:  hash,
+		Filestore: n.Filestore,
+		FilePath:  fpath,
+	})
+}
+
+func addFile(n *core.IpfsNode, file files.File, out chan interface{}, opts addOptions) (*dag.Node, error) {
 	if file.IsDirectory() {
-		return addDir(n, file, out, progress)
+		return addDir(n, file, out, opts)
 	}
 
 	// if the progress flag was specified, wrap the file so that we can send
 	// progress updates to the client (over the output channel)
 	var reader io.Reader = file
-	if progress {
+	if opts.progress {
 		reader = &progressReader{file: file, out: out}
 	}
 
-	if wrap {
-		p, dagnode, err := coreunix.AddWrapped(n, reader, path.Base(file.FileName()))
+	var dagnode *dag.Node
+	var err error
+	if opts.nocopy {
+		dagnode, err = addNoCopy(n, reader, file.FileName(), opts.hash)
+	} else {
+		dagnode, err = add(n, reader, opts.hash)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.wrap {
+		// not coreunix.AddWrapped: addAndPin already holds the pin lock, which
+		// is not reentrant
+		tree, err := coreunix.WrapNode(n, file.FileName(), dagnode)
+		if err != nil {
+			return nil, err
+		}
+		k, err := tree.Key()
 		if err != nil {
 			return nil, err
 		}
 		out <- &AddedObject{
-			Hash: p,
+			Hash: path.Join(k.String(), path.Base(file.FileName())),
 			Name: file.FileName(),
 		}
-		return dagnode, nil
-	}
-
-	dagnode, err := add(n, reader)
-	if err != nil {
-		return nil, err
+		return tree, nil
 	}
 
 	log.Infof("adding file: %s", file.FileName())
@@ -258,10 +381,13 @@ func addFile(n *core.IpfsNode, file files.File, out chan interface{}, progress b
 	return dagnode, nil
 }
 
-func addDir(n *core.IpfsNode, dir files.File, out chan interface{}, progress bool) (*dag.Node, error) {
+func addDir(n *core.IpfsNode, dir files.File, out chan interface{}, opts addOptions) (*dag.Node, error) {
 	log.Infof("adding directory: %s", dir.FileName())
 
-	tree := &dag.Node{Data: ft.FolderPBData()}
+	tree := uio.NewDirectory(n.DAG)
+	tree.SetHashFunc(opts.hash)
+	tree.SetShardThreshold(n.Repo.Config().Unixfs.ShardThreshold())
+	opts.wrap = false
 
 	for {
 		file, err := dir.NextFile()
@@ -272,30 +398,35 @@ func addDir(n *core.IpfsNode, dir files.File, out chan interface{}, progress boo
 			break
 		}
 
-		node, err := addFile(n, file, out, progress, false)
+		node, err := addFile(n, file, out, opts)
 		if err != nil {
 			return nil, err
 		}
 
 		_, name := path.Split(file.FileName())
 
-		err = tree.AddNodeLink(name, node)
+		err = tree.AddNode(n.Context(), name, node)
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	err := outputDagnode(out, dir.FileName(), tree)
+	nd, err := tree.GetNode()
+	if err != nil {
+		return nil, err
+	}
+
+	err = outputDagnode(out, dir.FileName(), nd)
 	if err != nil {
 		return nil, err
 	}
 
-	_, err = n.DAG.Add(tree)
+	_, err = n.DAG.Add(nd)
 	if err != nil {
 		return nil, err
 	}
 
-	return tree, nil
+	return nd, nil
 }
 
 // outputDagnode sends dagnode info over the output channel
@@ -0,0 +1,157 @@
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	cmds "github.com/ipfs/go-ipfs/commands"
+	core "github.com/ipfs/go-ipfs/core"
+	corerepo "github.com/ipfs/go-ipfs/core/corerepo"
+	dag "github.com/ipfs/go-ipfs/merkledag"
+	"github.com/ipfs/go-ipfs/merkledag/archive"
+	path "github.com/ipfs/go-ipfs/path"
+	u "github.com/ipfs/go-ipfs/util"
+)
+
+var DagCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Move DAGs between nodes as archives",
+		ShortDescription: `
+'ipfs dag' is a plumbing command to copy DAGs between nodes that are not
+connected. Unlike 'ipfs get', it keeps every node of the DAGs as it is,
+so the objects have the same hashes on both nodes.
+`,
+		Synopsis: `
+ipfs dag export <root>... - Write an archive of the DAGs below <root>
+ipfs dag import <file>    - Add the DAGs in an archive
+`,
+	},
+
+	Subcommands: map[string]*cmds.Command{
+		"export": dagExportCmd,
+		"import": dagImportCmd,
+	},
+}
+
+var dagExportCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Write an archive of DAGs to stdout",
+		ShortDescription: `
+'ipfs dag export' writes an archive of the DAGs below each <root> to
+stdout, with every node once. Nodes missing from the local repo are
+fetched from the network. Use 'ipfs dag import' to add the archive to
+another node:
+
+  ipfs dag export $ROOT >data.dag
+  ipfs dag import --pin data.dag
+`,
+	},
+
+	Arguments: []cmds.Argument{
+		cmds.StringArg("root", true, true, "Path of the root of a DAG to export").EnableStdin(),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, err := req.Context().GetNode()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		var roots []*dag.Node
+		for _, fpath := range req.Arguments() {
+			root, err := core.Resolve(req.Context().Context, n, path.Path(fpath))
+			if err != nil {
+				res.SetError(err, cmds.ErrNormal)
+				return
+			}
+			roots = append(roots, root)
+		}
+
+		pr, pw := io.Pipe()
+		go func() {
+			_, err := archive.Export(n.DAG, pw, roots)
+			pw.CloseWithError(err)
+		}()
+		res.SetOutput((io.Reader)(pr))
+	},
+}
+
+var dagImportCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Add the DAGs in an archive",
+		ShortDescription: `
+'ipfs dag import' adds the nodes in an archive written by 'ipfs dag
+export', and outputs the roots of the archived DAGs. The archive is read
+completely and every node checked against its hash before any is stored,
+so nothing is imported from an archive with a node that does not match.
+
+With --pin, the roots are pinned recursively. Nodes below them that the
+archive did not hold are fetched from the network.
+`,
+	},
+
+	Arguments: []cmds.Argument{
+		cmds.FileArg("file", true, false, "Archive to import").EnableStdin(),
+	},
+	Options: []cmds.Option{
+		cmds.BoolOption("pin", "Pin the roots of the archived DAGs recursively"),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, err := req.Context().GetNode()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		pin, _, err := req.Option("pin").Bool()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		file, err := req.Files().NextFile()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+		if file.IsDirectory() {
+			res.SetError(fmt.Errorf("%s is a directory", file.FileName()), cmds.ErrNormal)
+			return
+		}
+		defer file.Close()
+
+		roots, count, err := corerepo.ImportDAG(n, file, pin)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+		res.SetOutput(&DagImportOutput{Roots: roots, Blocks: count, Pinned: pin})
+	},
+	Type: DagImportOutput{},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: func(res cmds.Response) (io.Reader, error) {
+			out, ok := res.Output().(*DagImportOutput)
+			if !ok {
+				return nil, u.ErrCast()
+			}
+
+			buf := new(bytes.Buffer)
+			fmt.Fprintf(buf, "imported %d blocks\n", out.Blocks)
+			for _, k := range out.Roots {
+				if out.Pinned {
+					fmt.Fprintf(buf, "pinned root %s\n", k)
+				} else {
+					fmt.Fprintf(buf, "root %s\n", k)
+				}
+			}
+			return buf, nil
+		},
+	},
+}
+
+type DagImportOutput struct {
+	Roots  []u.Key
+	Blocks int
+	Pinned bool
+}
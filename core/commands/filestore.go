@@ -0,0 +1,160 @@
+package commands
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	cmds "github.com/ipfs/go-ipfs/commands"
+	"github.com/ipfs/go-ipfs/filestore"
+	u "github.com/ipfs/go-ipfs/util"
+)
+
+var FilestoreCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Interact with the filestore",
+		ShortDescription: `
+The filestore holds the blocks of files added with 'ipfs add --nocopy'.
+It only records where the data of each block is in the files, and reads
+the data back from them.
+`,
+	},
+
+	Subcommands: map[string]*cmds.Command{
+		"ls":     filestoreLsCmd,
+		"verify": filestoreVerifyCmd,
+	},
+}
+
+var filestoreLsCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "List the blocks in the filestore",
+		ShortDescription: `
+'ipfs filestore ls' lists the blocks in the filestore, each with the
+file, offset and size of its data.
+`,
+	},
+
+	Run: func(req cmds.Request, res cmds.Response) {
+		fs, err := getFilestore(req)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		entries, err := fs.ListAsync(req.Context().Context)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		outChan := make(chan interface{})
+		res.SetOutput((<-chan interface{})(outChan))
+
+		go func() {
+			defer close(outChan)
+			for e := range entries {
+				outChan <- e
+			}
+		}()
+	},
+	Type: filestore.Entry{},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: func(res cmds.Response) (io.Reader, error) {
+			return filestoreMarshaler(res, func(buf *bytes.Buffer, e *filestore.Entry) {
+				fmt.Fprintf(buf, "%s %s %d %d\n", e.Key, e.Path, e.Offset, e.Size)
+			})
+		},
+	},
+}
+
+var filestoreVerifyCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Verify the blocks in the filestore",
+		ShortDescription: `
+'ipfs filestore verify' reads the data of every block in the filestore
+back from its file, and checks it against the block hash. Blocks whose
+file was changed, moved or removed are reported as 'changed'.
+`,
+	},
+
+	Options: []cmds.Option{
+		cmds.BoolOption("quiet", "q", "Only report blocks that failed verification"),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		fs, err := getFilestore(req)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		entries, err := fs.VerifyAsync(req.Context().Context)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		outChan := make(chan interface{})
+		res.SetOutput((<-chan interface{})(outChan))
+
+		go func() {
+			defer close(outChan)
+			for e := range entries {
+				outChan <- e
+			}
+		}()
+	},
+	Type: filestore.Entry{},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: func(res cmds.Response) (io.Reader, error) {
+			quiet, _, err := res.Request().Option("quiet").Bool()
+			if err != nil {
+				return nil, err
+			}
+
+			return filestoreMarshaler(res, func(buf *bytes.Buffer, e *filestore.Entry) {
+				switch {
+				case e.Status == filestore.StatusOk && quiet:
+				case e.Error != "":
+					fmt.Fprintf(buf, "%s %s %s: %s\n", e.Status, e.Key, e.Path, e.Error)
+				default:
+					fmt.Fprintf(buf, "%s %s %s\n", e.Status, e.Key, e.Path)
+				}
+			})
+		},
+	},
+}
+
+func getFilestore(req cmds.Request) (*filestore.Filestore, error) {
+	n, err := req.Context().GetNode()
+	if err != nil {
+		return nil, err
+	}
+	if n.Filestore == nil {
+		return nil, errors.New("this node has no filestore")
+	}
+	return n.Filestore, nil
+}
+
+func filestoreMarshaler(res cmds.Response, format func(*bytes.Buffer, *filestore.Entry)) (io.Reader, error) {
+	outChan, ok := res.Output().(<-chan interface{})
+	if !ok {
+		return nil, u.ErrCast()
+	}
+
+	marshal := func(v interface{}) (io.Reader, error) {
+		e, ok := v.(*filestore.Entry)
+		if !ok {
+			return nil, u.ErrCast()
+		}
+		buf := new(bytes.Buffer)
+		format(buf, e)
+		return buf, nil
+	}
+
+	return &cmds.ChannelMarshaler{
+		Channel:   outChan,
+		Marshaler: marshal,
+	}, nil
+}
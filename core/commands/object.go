@@ -15,7 +15,10 @@ import (
 	cmds "github.com/ipfs/go-ipfs/commands"
 	core "github.com/ipfs/go-ipfs/core"
 	dag "github.com/ipfs/go-ipfs/merkledag"
+	dagutils "github.com/ipfs/go-ipfs/merkledag/utils"
 	path "github.com/ipfs/go-ipfs/path"
+	uio "github.com/ipfs/go-ipfs/unixfs/io"
+	u "github.com/ipfs/go-ipfs/util"
 )
 
 // ErrObjectTooLarge is returned when too much data was read from stdin. current limit 512k
@@ -45,11 +48,14 @@ var ObjectCmd = &cmds.Command{
 'ipfs object' is a plumbing command used to manipulate DAG objects
 directly.`,
 		Synopsis: `
-ipfs object get <key>   - Get the DAG node named by <key>
-ipfs object put <data>  - Stores input, outputs its key
-ipfs object data <key>  - Outputs raw bytes in an object
-ipfs object links <key> - Outputs links pointed to by object
-ipfs object stat <key>  - Outputs statistics of object
+ipfs object get <key>      - Get the DAG node named by <key>
+ipfs object put <data>     - Stores input, outputs its key
+ipfs object data <key>     - Outputs raw bytes in an object
+ipfs object links <key>    - Outputs links pointed to by object
+ipfs object stat <key>     - Outputs statistics of object
+ipfs object diff <a> <b>   - Outputs the paths that differ between objects
+ipfs object patch          - Create a new object from an existing one
+ipfs object new [template] - Create a new object from a template
 `,
 	},
 
@@ -59,6 +65,9 @@ ipfs object stat <key>  - Outputs statistics of object
 		"get":   objectGetCmd,
 		"put":   objectPutCmd,
 		"stat":  objectStatCmd,
+		"diff":  objectDiffCmd,
+		"patch": objectPatchCmd,
+		"new":   objectNewCmd,
 	},
 }
 
@@ -135,7 +144,10 @@ multihash.
 	},
 	Marshalers: cmds.MarshalerMap{
 		cmds.Text: func(res cmds.Response) (io.Reader, error) {
-			object := res.Output().(*Object)
+			object, ok := res.Output().(*Object)
+			if !ok {
+				return nil, u.ErrCast()
+			}
 			var buf bytes.Buffer
 			w := tabwriter.NewWriter(&buf, 1, 2, 1, ' ', 0)
 			fmt.Fprintln(w, "Hash\tSize\tName\t")
@@ -281,6 +293,398 @@ var objectStatCmd = &cmds.Command{
 	},
 }
 
+var objectDiffCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Outputs the paths that differ between two objects",
+		ShortDescription: `
+'ipfs object diff' is a plumbing command to compare the trees below two
+DAG nodes. It matches links by name, and outputs each path that was
+added, removed or modified from <a> to <b>. Subtrees with the same hash
+on both sides are skipped. The paths are relative to <a> and <b>, and
+"." is the objects themselves.
+`,
+		LongDescription: `
+'ipfs object diff' is a plumbing command to compare the trees below two
+DAG nodes. It matches links by name, and outputs each path that was
+added, removed or modified from <a> to <b>. Subtrees with the same hash
+on both sides are skipped. The paths are relative to <a> and <b>, and
+"." is the objects themselves.
+
+A node whose links are not all uniquely named, like a chunked unixfs
+file, is compared as a whole. Any other node is reported modified when
+its data differs, and the paths that differ below it are reported as
+well. A sharded unixfs directory is compared by its entries, like a
+plain one.
+`,
+	},
+
+	Arguments: []cmds.Argument{
+		cmds.StringArg("a", true, false, "Path of the object to diff from"),
+		cmds.StringArg("b", true, false, "Path of the object to diff to"),
+	},
+	Options: []cmds.Option{
+		cmds.BoolOption("verbose", "v", "Also output the hashes before and after each change"),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, err := req.Context().GetNode()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		ctx := req.Context().Context
+		var nodes [2]*dag.Node
+		for i, arg := range req.Arguments() {
+			nodes[i], err = core.Resolve(ctx, n, path.Path(arg))
+			if err != nil {
+				res.SetError(err, cmds.ErrNormal)
+				return
+			}
+		}
+
+		changes, err := dagutils.Diff(ctx, n.DAG, nodes[0], nodes[1])
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+		res.SetOutput(&ObjectDiffOutput{Changes: changes})
+	},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: func(res cmds.Response) (io.Reader, error) {
+			out, ok := res.Output().(*ObjectDiffOutput)
+			if !ok {
+				return nil, u.ErrCast()
+			}
+			verbose, _, err := res.Request().Option("verbose").Bool()
+			if err != nil {
+				return nil, err
+			}
+
+			var buf bytes.Buffer
+			for _, c := range out.Changes {
+				p := c.Path
+				if p == "" {
+					p = "."
+				}
+				if !verbose {
+					fmt.Fprintf(&buf, "%s %s\n", c.Type, p)
+					continue
+				}
+				switch c.Type {
+				case dagutils.Add:
+					fmt.Fprintf(&buf, "%s %s %s\n", c.Type, p, c.After)
+				case dagutils.Remove:
+					fmt.Fprintf(&buf, "%s %s %s\n", c.Type, p, c.Before)
+				default:
+					fmt.Fprintf(&buf, "%s %s %s -> %s\n", c.Type, p, c.Before, c.After)
+				}
+			}
+			return &buf, nil
+		},
+	},
+	Type: ObjectDiffOutput{},
+}
+
+type ObjectDiffOutput struct {
+	Changes []*dagutils.Change
+}
+
+// templateList lists the registered templates for the help of 'ipfs
+// object new'.
+func templateList() string {
+	var buf bytes.Buffer
+	for _, name := range dagutils.Templates() {
+		fmt.Fprintf(&buf, "\t* %s\n", name)
+	}
+	return buf.String()
+}
+
+var objectNewCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Create a new object from a template",
+		ShortDescription: `
+'ipfs object new' is a plumbing command to store a new object, and
+outputs its hash. Without a template, the object has no data and no
+links. The available templates are:
+
+` + templateList(),
+	},
+
+	Arguments: []cmds.Argument{
+		cmds.StringArg("template", false, false, "Template to make the object from"),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, err := req.Context().GetNode()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		node := new(dag.Node)
+		if len(req.Arguments()) == 1 {
+			node, err = dagutils.NewFromTemplate(req.Arguments()[0])
+			if err != nil {
+				res.SetError(err, cmds.ErrClient)
+				return
+			}
+		}
+
+		output, err := addObject(n, node)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+		res.SetOutput(output)
+	},
+	Marshalers: hashMarshalers,
+	Type:       Object{},
+}
+
+var objectPatchCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Create a new object from an existing one",
+		ShortDescription: `
+'ipfs object patch' is a plumbing command to change the links or data of
+the object at <root>. The object is not modified in place: each
+subcommand stores a new object with the change, along with any objects
+changed on the way to it, and outputs the hash of the new root.
+`,
+		Synopsis: `
+ipfs object patch add-link <root> <name> <ref> - Link <ref> as <name>
+ipfs object patch rm-link <root> <name>        - Remove the link <name>
+ipfs object patch set-data <root> <data>       - Replace the data
+ipfs object patch append-data <root> <data>    - Append to the data
+`,
+	},
+
+	Subcommands: map[string]*cmds.Command{
+		"add-link":    patchAddLinkCmd,
+		"rm-link":     patchRmLinkCmd,
+		"set-data":    patchSetDataCmd,
+		"append-data": patchAppendDataCmd,
+	},
+}
+
+var patchAddLinkCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Link an object below another one",
+		ShortDescription: `
+'ipfs object patch add-link' stores a copy of <root> with a link to the
+object at <ref> named <name>, replacing any link of that name, and
+outputs the hash of the copy. <name> may be a slash-separated path, in
+which case the objects along it are updated as well. With --create, the
+objects missing along it are made as empty unixfs directories. Sharded
+unixfs directories can not be edited this way, as their links are not
+named by their entries.
+
+For example, to link a file into a directory:
+
+  ipfs object patch add-link $DIR docs/readme.txt $FILE --create
+`,
+	},
+
+	Arguments: []cmds.Argument{
+		cmds.StringArg("root", true, false, "Path of the object to modify"),
+		cmds.StringArg("name", true, false, "Name of the link to add, may be a slash-separated path"),
+		cmds.StringArg("ref", true, false, "Path of the object to link to"),
+	},
+	Options: []cmds.Option{
+		cmds.BoolOption("create", "p", "Create the missing intermediate directories"),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, err := req.Context().GetNode()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		create, _, err := req.Option("create").Bool()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+		var mkdir func() *dag.Node
+		if create {
+			mkdir = uio.NewEmptyDirectory
+		}
+
+		ctx := req.Context().Context
+		args := req.Arguments()
+		root, err := core.Resolve(ctx, n, path.Path(args[0]))
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+		ref, err := core.Resolve(ctx, n, path.Path(args[2]))
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		nroot, err := dagutils.InsertNodeAtPath(ctx, n.DAG, root, args[1], ref, mkdir)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+		output, err := addObject(n, nroot)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+		res.SetOutput(output)
+	},
+	Marshalers: hashMarshalers,
+	Type:       Object{},
+}
+
+var patchRmLinkCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Remove a link from an object",
+		ShortDescription: `
+'ipfs object patch rm-link' stores a copy of <root> without the link
+named <name>, and outputs the hash of the copy. <name> may be a
+slash-separated path, in which case the objects along it are updated as
+well. Sharded unixfs directories can not be edited this way, as their
+links are not named by their entries.
+`,
+	},
+
+	Arguments: []cmds.Argument{
+		cmds.StringArg("root", true, false, "Path of the object to modify"),
+		cmds.StringArg("name", true, false, "Name of the link to remove, may be a slash-separated path"),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, err := req.Context().GetNode()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		ctx := req.Context().Context
+		args := req.Arguments()
+		root, err := core.Resolve(ctx, n, path.Path(args[0]))
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		nroot, err := dagutils.RmLink(ctx, n.DAG, root, args[1])
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+		output, err := addObject(n, nroot)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+		res.SetOutput(output)
+	},
+	Marshalers: hashMarshalers,
+	Type:       Object{},
+}
+
+var patchSetDataCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Replace the data of an object",
+		ShortDescription: `
+'ipfs object patch set-data' stores a copy of <root> with its data
+replaced by <data>, which is read from stdin if not given as a file, and
+outputs the hash of the copy.
+`,
+	},
+
+	Arguments: []cmds.Argument{
+		cmds.StringArg("root", true, false, "Path of the object to modify"),
+		cmds.FileArg("data", true, false, "The new data of the object").EnableStdin(),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		patchData(req, res, func(old, data []byte) []byte { return data })
+	},
+	Marshalers: hashMarshalers,
+	Type:       Object{},
+}
+
+var patchAppendDataCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Append to the data of an object",
+		ShortDescription: `
+'ipfs object patch append-data' stores a copy of <root> with <data>,
+which is read from stdin if not given as a file, appended to its data,
+and outputs the hash of the copy.
+`,
+	},
+
+	Arguments: []cmds.Argument{
+		cmds.StringArg("root", true, false, "Path of the object to modify"),
+		cmds.FileArg("data", true, false, "The data to append").EnableStdin(),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		patchData(req, res, func(old, data []byte) []byte { return append(old, data...) })
+	},
+	Marshalers: hashMarshalers,
+	Type:       Object{},
+}
+
+// hashMarshalers output the hash of an Object.
+var hashMarshalers = cmds.MarshalerMap{
+	cmds.Text: func(res cmds.Response) (io.Reader, error) {
+		object, ok := res.Output().(*Object)
+		if !ok {
+			return nil, u.ErrCast()
+		}
+		return strings.NewReader(object.Hash + "\n"), nil
+	},
+}
+
+// patchData stores a copy of the object at the root argument, with its
+// data set by patch from the old data and the data argument.
+func patchData(req cmds.Request, res cmds.Response, patch func(old, data []byte) []byte) {
+	n, err := req.Context().GetNode()
+	if err != nil {
+		res.SetError(err, cmds.ErrNormal)
+		return
+	}
+
+	root, err := core.Resolve(req.Context().Context, n, path.Path(req.Arguments()[0]))
+	if err != nil {
+		res.SetError(err, cmds.ErrNormal)
+		return
+	}
+
+	input, err := req.Files().NextFile()
+	if err != nil && err != io.EOF {
+		res.SetError(err, cmds.ErrNormal)
+		return
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(input, inputLimit+10))
+	if err != nil {
+		res.SetError(err, cmds.ErrNormal)
+		return
+	}
+	if len(data) >= inputLimit {
+		res.SetError(ErrObjectTooLarge, cmds.ErrNormal)
+		return
+	}
+
+	nroot := root.Copy()
+	nroot.Data = patch(nroot.Data, data)
+	output, err := addObject(n, nroot)
+	if err != nil {
+		res.SetError(err, cmds.ErrNormal)
+		return
+	}
+	res.SetOutput(output)
+}
+
+// addObject adds root, and the new objects linked below it.
+func addObject(n *core.IpfsNode, root *dag.Node) (*Object, error) {
+	if err := n.DAG.AddRecursive(root); err != nil {
+		return nil, err
+	}
+	return getOutput(root)
+}
+
 var objectPutCmd = &cmds.Command{
 	Helptext: cmds.HelpText{
 		Tagline: "Stores input as a DAG object, outputs its key",
@@ -304,6 +708,7 @@ Data should be in the format specified by the --inputenc flag.
 	},
 	Options: []cmds.Option{
 		cmds.StringOption("inputenc", "Encoding type of input data, either \"protobuf\" or \"json\""),
+		hashOption,
 	},
 	Run: func(req cmds.Request, res cmds.Response) {
 		n, err := req.Context().GetNode()
@@ -327,7 +732,13 @@ Data should be in the format specified by the --inputenc flag.
 			inputenc = "json"
 		}
 
-		output, err := objectPut(n, input, inputenc)
+		hash, err := getHashFunc(req)
+		if err != nil {
+			res.SetError(err, cmds.ErrClient)
+			return
+		}
+
+		output, err := objectPut(n, input, inputenc, hash)
 		if err != nil {
 			errType := cmds.ErrNormal
 			if err == ErrUnknownObjectEnc {
@@ -341,7 +752,10 @@ Data should be in the format specified by the --inputenc flag.
 	},
 	Marshalers: cmds.MarshalerMap{
 		cmds.Text: func(res cmds.Response) (io.Reader, error) {
-			object := res.Output().(*Object)
+			object, ok := res.Output().(*Object)
+			if !ok {
+				return nil, u.ErrCast()
+			}
 			return strings.NewReader("added " + object.Hash), nil
 		},
 	},
@@ -352,7 +766,7 @@ Data should be in the format specified by the --inputenc flag.
 var ErrEmptyNode = errors.New("no data or links in this node")
 
 // objectPut takes a format option, serializes bytes from stdin and updates the dag with that data
-func objectPut(n *core.IpfsNode, input io.Reader, encoding string) (*Object, error) {
+func objectPut(n *core.IpfsNode, input io.Reader, encoding string, hash int) (*Object, error) {
 
 	data, err := ioutil.ReadAll(io.LimitReader(input, inputLimit+10))
 	if err != nil {
@@ -393,6 +807,7 @@ func objectPut(n *core.IpfsNode, input io.Reader, encoding string) (*Object, err
 	if err != nil {
 		return nil, err
 	}
+	dagnode.SetHashFunc(hash)
 
 	_, err = n.DAG.Add(dagnode)
 	if err != nil {
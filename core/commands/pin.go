@@ -2,11 +2,18 @@ package commands
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"sort"
+	"strings"
+	"time"
 
 	cmds "github.com/ipfs/go-ipfs/commands"
+	core "github.com/ipfs/go-ipfs/core"
 	corerepo "github.com/ipfs/go-ipfs/core/corerepo"
+	path "github.com/ipfs/go-ipfs/path"
+	"github.com/ipfs/go-ipfs/pin"
 	u "github.com/ipfs/go-ipfs/util"
 )
 
@@ -16,22 +23,54 @@ var PinCmd = &cmds.Command{
 	},
 
 	Subcommands: map[string]*cmds.Command{
-		"add": addPinCmd,
-		"rm":  rmPinCmd,
-		"ls":  listPinCmd,
+		"add":    addPinCmd,
+		"rm":     rmPinCmd,
+		"ls":     listPinCmd,
+		"update": updatePinCmd,
+		"status": statusPinCmd,
+		"verify": verifyPinCmd,
 	},
 }
 
 type PinOutput struct {
 	Pinned []u.Key
+	// Progress is the number of nodes fetched so far, sent with
+	// --progress before the pins are in place.
+	Progress int `json:",omitempty"`
 }
 
+// progressInterval is the least time between two progress messages of
+// 'ipfs pin add --progress'.
+const progressInterval = 100 * time.Millisecond
+
 var addPinCmd = &cmds.Command{
 	Helptext: cmds.HelpText{
 		Tagline: "Pins objects to local storage",
 		ShortDescription: `
 Retrieves the object named by <ipfs-path> and stores it locally
 on disk.
+`,
+		LongDescription: `
+Retrieves the object named by <ipfs-path> and stores it locally
+on disk.
+
+Use --name and --labels to record why the object is pinned. Labels are
+given as a comma separated list of key=value pairs, for example
+--labels=env=prod,team=web. 'ipfs pin ls' shows them, and can filter pins
+by them.
+
+Use --ttl to pin the object for a limited time only, for example
+--ttl=72h. The daemon removes the pin once it expires, and 'ipfs repo gc'
+removes expired pins before collecting.
+
+Pinning an object again with any of --name, --labels or --ttl replaces
+its name, labels and expiry.
+
+Use --progress to see how many nodes were fetched while pinning
+recursively. With --background, a recursive pin returns as soon as the
+object is found, and the daemon fetches the rest and pins it. Use
+'ipfs pin status' to follow it. Background pins that were not done
+when the daemon stopped resume when it starts again.
 `,
 	},
 
@@ -40,6 +79,11 @@ on disk.
 	},
 	Options: []cmds.Option{
 		cmds.BoolOption("recursive", "r", "Recursively pin the object linked to by the specified object(s)"),
+		cmds.StringOption("name", "A name for the pin(s)"),
+		cmds.StringOption("labels", "Comma separated key=value labels for the pin(s)"),
+		cmds.StringOption("ttl", "Remove the pin(s) after this duration, like 72h"),
+		cmds.BoolOption("progress", "p", "Stream the number of nodes fetched"),
+		cmds.BoolOption("background", "Fetch and pin recursively in the daemon, see 'ipfs pin status'"),
 	},
 	Type: PinOutput{},
 	Run: func(req cmds.Request, res cmds.Response) {
@@ -59,38 +103,334 @@ on disk.
 			recursive = false
 		}
 
-		added, err := corerepo.Pin(n, req.Arguments(), recursive)
+		meta, err := pinMetadataOptions(req)
+		if err != nil {
+			res.SetError(err, cmds.ErrClient)
+			return
+		}
+
+		ttl, found, err := req.Option("ttl").String()
 		if err != nil {
 			res.SetError(err, cmds.ErrNormal)
 			return
 		}
+		if found {
+			d, err := time.ParseDuration(ttl)
+			if err != nil || d <= 0 {
+				res.SetError(fmt.Errorf("invalid ttl %q, must be a positive duration like 72h", ttl), cmds.ErrClient)
+				return
+			}
+			expires := time.Now().Add(d)
+			meta.Expires = &expires
+		}
+
+		progress, _, err := req.Option("progress").Bool()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+		background, _, err := req.Option("background").Bool()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		if background {
+			if !recursive {
+				res.SetError(errors.New("--background only works with --recursive"), cmds.ErrClient)
+				return
+			}
+			statuses, err := corerepo.PinBackground(n, req.Arguments(), meta)
+			if err != nil {
+				res.SetError(err, cmds.ErrNormal)
+				return
+			}
+			out := new(PinOutput)
+			for _, st := range statuses {
+				out.Pinned = append(out.Pinned, st.Key)
+			}
+			res.SetOutput(out)
+			return
+		}
+
+		opts := corerepo.PinOptions{Recursive: recursive, Meta: meta}
+		if !progress {
+			added, err := corerepo.PinWithOptions(n, req.Arguments(), opts)
+			if err != nil {
+				res.SetError(err, cmds.ErrNormal)
+				return
+			}
+
+			res.SetOutput(&PinOutput{Pinned: added})
+			return
+		}
+
+		// Progress must not hold up the fetch waiting for the client:
+		// updates that do not fit in the buffer are dropped, the next one
+		// or the final count replaces them.
+		outChan := make(chan interface{}, 1)
+		res.SetOutput((<-chan interface{})(outChan))
+
+		go func() {
+			defer close(outChan)
+
+			var last time.Time
+			var fetched int
+			opts.Progress = func(c int) {
+				fetched = c
+				if time.Since(last) < progressInterval {
+					return
+				}
+				select {
+				case outChan <- &PinOutput{Progress: c}:
+					last = time.Now()
+				default:
+				}
+			}
+			added, err := corerepo.PinWithOptions(n, req.Arguments(), opts)
+			if err != nil {
+				res.SetError(err, cmds.ErrNormal)
+				return
+			}
+			if fetched > 0 {
+				outChan <- &PinOutput{Progress: fetched}
+			}
+			outChan <- &PinOutput{Pinned: added}
+		}()
+	},
+	PostRun: func(req cmds.Request, res cmds.Response) {
+		if res.Error() != nil {
+			return
+		}
+		outChan, ok := res.Output().(<-chan interface{})
+		if !ok {
+			// no --progress
+			return
+		}
+		if enc, _, _ := req.Option(cmds.EncShort).String(); cmds.EncodingType(enc) != cmds.Text {
+			return
+		}
+		res.SetOutput(nil)
 
-		res.SetOutput(&PinOutput{added})
+		var progressed bool
+		for v := range outChan {
+			out, ok := v.(*PinOutput)
+			if !ok {
+				res.SetError(u.ErrCast(), cmds.ErrNormal)
+				return
+			}
+			if out.Pinned == nil {
+				fmt.Fprintf(res.Stderr(), "\rfetched %d nodes", out.Progress)
+				progressed = true
+				continue
+			}
+			if progressed {
+				fmt.Fprintln(res.Stderr())
+			}
+			io.Copy(res.Stdout(), formatPinAdded(req, out))
+		}
 	},
 	Marshalers: cmds.MarshalerMap{
 		cmds.Text: func(res cmds.Response) (io.Reader, error) {
+			if outChan, ok := res.Output().(<-chan interface{}); ok {
+				return &cmds.ChannelMarshaler{
+					Channel: outChan,
+					Marshaler: func(v interface{}) (io.Reader, error) {
+						out, ok := v.(*PinOutput)
+						if !ok {
+							return nil, u.ErrCast()
+						}
+						if out.Pinned == nil {
+							return strings.NewReader(fmt.Sprintf("fetched %d nodes\n", out.Progress)), nil
+						}
+						return formatPinAdded(res.Request(), out), nil
+					},
+				}, nil
+			}
+
 			added, ok := res.Output().(*PinOutput)
 			if !ok {
 				return nil, u.ErrCast()
 			}
+			return formatPinAdded(res.Request(), added), nil
+		},
+	},
+}
 
-			var pintype string
-			rec, _, _ := res.Request().Option("recursive").Bool()
-			if rec {
-				pintype = "recursively"
-			} else {
-				pintype = "directly"
+func formatPinAdded(req cmds.Request, added *PinOutput) io.Reader {
+	var pintype string
+	rec, _, _ := req.Option("recursive").Bool()
+	if rec {
+		pintype = "recursively"
+	} else {
+		pintype = "directly"
+	}
+	format := "pinned %s %s\n"
+	if bg, _, _ := req.Option("background").Bool(); bg {
+		format = "pinning %s %s in the background\n"
+	}
+
+	buf := new(bytes.Buffer)
+	for _, k := range added.Pinned {
+		fmt.Fprintf(buf, format, k, pintype)
+	}
+	return buf
+}
+
+var statusPinCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Show the state of background pins",
+		ShortDescription: `
+Lists the pins added with 'ipfs pin add --background' that are not done
+yet, with the number of nodes fetched so far. Pins that failed are listed
+with their error until they are added again. Given objects, shows the
+state of their pins, which is one of "pinning", "failed", "pinned" or
+"not pinned".
+`,
+	},
+
+	Arguments: []cmds.Argument{
+		cmds.StringArg("ipfs-path", false, true, "Path to object(s) to show the pin state of"),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, err := req.Context().GetNode()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		var keys []u.Key
+		for _, p := range req.Arguments() {
+			dagnode, err := core.Resolve(req.Context().Context, n, path.Path(p))
+			if err != nil {
+				res.SetError(err, cmds.ErrNormal)
+				return
+			}
+			k, err := dagnode.Key()
+			if err != nil {
+				res.SetError(err, cmds.ErrNormal)
+				return
 			}
+			keys = append(keys, k)
+		}
 
+		statuses, err := corerepo.PinStatuses(n, keys)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+		res.SetOutput(&PinStatusOutput{Pins: statuses})
+	},
+	Type: PinStatusOutput{},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: func(res cmds.Response) (io.Reader, error) {
+			out, ok := res.Output().(*PinStatusOutput)
+			if !ok {
+				return nil, u.ErrCast()
+			}
 			buf := new(bytes.Buffer)
-			for _, k := range added.Pinned {
-				fmt.Fprintf(buf, "pinned %s %s\n", k, pintype)
+			for _, st := range out.Pins {
+				switch st.Status {
+				case corerepo.PinStatusPinning:
+					fmt.Fprintf(buf, "%s %s, fetched %d nodes\n", st.Key, st.Status, st.Fetched)
+				case corerepo.PinStatusFailed:
+					fmt.Fprintf(buf, "%s %s: %s\n", st.Key, st.Status, st.Error)
+				default:
+					fmt.Fprintf(buf, "%s %s\n", st.Key, st.Status)
+				}
 			}
 			return buf, nil
 		},
 	},
 }
 
+type PinStatusOutput struct {
+	Pins []*corerepo.PinStatus
+}
+
+var verifyPinCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Check that recursively pinned objects are complete locally",
+		ShortDescription: `
+'ipfs pin verify' walks the DAG of every recursive pin, reading only the
+local blockstore, and reports each pin as ok or broken. The nodes of a
+broken pin that are missing, corrupt or could not be read are listed
+below it. Nothing is fetched from the network, and nodes below a bad
+node are not checked.
+`,
+	},
+
+	Options: []cmds.Option{
+		cmds.BoolOption("quiet", "q", "Only report broken pins"),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, err := req.Context().GetNode()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		verifyChan, err := corerepo.VerifyPinsAsync(n, req.Context().Context)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		outChan := make(chan interface{})
+		res.SetOutput((<-chan interface{})(outChan))
+
+		go func() {
+			defer close(outChan)
+			for v := range verifyChan {
+				outChan <- v
+			}
+		}()
+	},
+	Type: corerepo.PinVerified{},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: func(res cmds.Response) (io.Reader, error) {
+			outChan, ok := res.Output().(<-chan interface{})
+			if !ok {
+				return nil, u.ErrCast()
+			}
+
+			quiet, _, err := res.Request().Option("quiet").Bool()
+			if err != nil {
+				return nil, err
+			}
+
+			marshal := func(v interface{}) (io.Reader, error) {
+				obj, ok := v.(*corerepo.PinVerified)
+				if !ok {
+					return nil, u.ErrCast()
+				}
+
+				buf := new(bytes.Buffer)
+				if obj.Ok {
+					if !quiet {
+						fmt.Fprintf(buf, "ok %s\n", obj.Key)
+					}
+					return buf, nil
+				}
+				fmt.Fprintf(buf, "broken %s\n", obj.Key)
+				for _, b := range obj.BadNodes {
+					if b.Error != "" {
+						fmt.Fprintf(buf, "  %s %s: %s\n", b.Status, b.Key, b.Error)
+					} else {
+						fmt.Fprintf(buf, "  %s %s\n", b.Status, b.Key)
+					}
+				}
+				return buf, nil
+			}
+
+			return &cmds.ChannelMarshaler{
+				Channel:   outChan,
+				Marshaler: marshal,
+			}, nil
+		},
+	},
+}
+
 var rmPinCmd = &cmds.Command{
 	Helptext: cmds.HelpText{
 		Tagline: "Unpin an object from local storage",
@@ -130,7 +470,7 @@ collected if needed.
 			return
 		}
 
-		res.SetOutput(&PinOutput{removed})
+		res.SetOutput(&PinOutput{Pinned: removed})
 	},
 	Marshalers: cmds.MarshalerMap{
 		cmds.Text: func(res cmds.Response) (io.Reader, error) {
@@ -148,6 +488,70 @@ collected if needed.
 	},
 }
 
+type PinUpdateOutput struct {
+	From u.Key
+	To   u.Key
+}
+
+var updatePinCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Move a recursive pin to another object",
+		ShortDescription: `
+Pins the object named by <to-path> recursively, and unpins <from-path>,
+which must be pinned recursively, in one step.
+`,
+		LongDescription: `
+Pins the object named by <to-path> recursively, and unpins <from-path>,
+which must be pinned recursively, in one step.
+
+Only the parts of <to-path> that differ from <from-path> are fetched and
+have their indirect pins updated, which is much faster than 'ipfs pin rm'
+followed by 'ipfs pin add' when the two share most of their objects.
+
+Use --keep-old to keep <from-path> pinned as well.
+`,
+	},
+
+	Arguments: []cmds.Argument{
+		cmds.StringArg("from-path", true, false, "Path to the recursively pinned object"),
+		cmds.StringArg("to-path", true, false, "Path to the object to pin instead"),
+	},
+	Options: []cmds.Option{
+		cmds.BoolOption("keep-old", "Keep the pin on <from-path>"),
+	},
+	Type: PinUpdateOutput{},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, err := req.Context().GetNode()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		keep, _, err := req.Option("keep-old").Bool()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		from, to, err := corerepo.Update(n, req.Arguments()[0], req.Arguments()[1], !keep)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		res.SetOutput(&PinUpdateOutput{From: from, To: to})
+	},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: func(res cmds.Response) (io.Reader, error) {
+			out, ok := res.Output().(*PinUpdateOutput)
+			if !ok {
+				return nil, u.ErrCast()
+			}
+			return strings.NewReader(fmt.Sprintf("updated %s to %s\n", out.From, out.To)), nil
+		},
+	},
+}
+
 var listPinCmd = &cmds.Command{
 	Helptext: cmds.HelpText{
 		Tagline: "List objects pinned to local storage",
@@ -167,6 +571,10 @@ Use --type=<type> to specify the type of pinned keys to list. Valid values are:
 
 To see the ref count on indirect pins, pass the -count option flag.
 Defaults to "direct".
+
+Use --name and --labels to only list the direct and recursive pins with
+that name, and with every one of the given comma separated key=value
+labels. --type then defaults to "all".
 `,
 	},
 
@@ -174,6 +582,8 @@ Defaults to "direct".
 		cmds.StringOption("type", "t", "The type of pinned keys to list. Can be \"direct\", \"indirect\", \"recursive\", or \"all\". Defaults to \"direct\""),
 		cmds.BoolOption("count", "n", "Show refcount when listing indirect pins"),
 		cmds.BoolOption("quiet", "q", "Write just hashes of objects"),
+		cmds.StringOption("name", "Only list the pins with this name"),
+		cmds.StringOption("labels", "Only list the pins with these comma separated key=value labels"),
 	},
 	Run: func(req cmds.Request, res cmds.Response) {
 		n, err := req.Context().GetNode()
@@ -187,8 +597,18 @@ Defaults to "direct".
 			res.SetError(err, cmds.ErrNormal)
 			return
 		}
+		filter, err := pinMetadataOptions(req)
+		if err != nil {
+			res.SetError(err, cmds.ErrClient)
+			return
+		}
+		filtered := !filter.IsEmpty()
+
 		if !found {
 			typeStr = "direct"
+			if filtered {
+				typeStr = "all"
+			}
 		}
 
 		switch typeStr {
@@ -198,16 +618,31 @@ Defaults to "direct".
 			res.SetError(err, cmds.ErrClient)
 		}
 
+		// direct and recursive pins, with their metadata
+		refKey := func(k u.Key, typ string) (RefKeyObject, bool) {
+			m, _ := n.Pinning.Metadata(k)
+			if filtered && !m.Matches(filter.Name, filter.Labels) {
+				return RefKeyObject{}, false
+			}
+			return RefKeyObject{
+				Type:    typ,
+				Count:   1,
+				Name:    m.Name,
+				Labels:  m.Labels,
+				Expires: m.Expires,
+			}, true
+		}
+
 		keys := make(map[string]RefKeyObject)
 		if typeStr == "direct" || typeStr == "all" {
 			for _, k := range n.Pinning.DirectKeys() {
-				keys[k.B58String()] = RefKeyObject{
-					Type:  "direct",
-					Count: 1,
+				if obj, ok := refKey(k, "direct"); ok {
+					keys[k.B58String()] = obj
 				}
 			}
 		}
-		if typeStr == "indirect" || typeStr == "all" {
+		// indirect pins have no metadata
+		if (typeStr == "indirect" || typeStr == "all") && !filtered {
 			for k, v := range n.Pinning.IndirectKeys() {
 				keys[k.B58String()] = RefKeyObject{
 					Type:  "indirect",
@@ -217,9 +652,8 @@ Defaults to "direct".
 		}
 		if typeStr == "recursive" || typeStr == "all" {
 			for _, k := range n.Pinning.RecursiveKeys() {
-				keys[k.B58String()] = RefKeyObject{
-					Type:  "recursive",
-					Count: 1,
+				if obj, ok := refKey(k, "recursive"); ok {
+					keys[k.B58String()] = obj
 				}
 			}
 		}
@@ -262,7 +696,7 @@ Defaults to "direct".
 					if quiet {
 						fmt.Fprintf(out, "%s\n", k)
 					} else {
-						fmt.Fprintf(out, "%s %s\n", k, v.Type)
+						fmt.Fprintf(out, "%s %s%s\n", k, v.Type, formatPinMetadata(v))
 					}
 				}
 			}
@@ -272,8 +706,64 @@ Defaults to "direct".
 }
 
 type RefKeyObject struct {
-	Type  string
-	Count int
+	Type    string
+	Count   int
+	Name    string            `json:",omitempty"`
+	Labels  map[string]string `json:",omitempty"`
+	Expires *time.Time        `json:",omitempty"`
+}
+
+// formatPinMetadata formats the name, quoted, the sorted labels and the
+// remaining lifetime of a pin, for appending to its line in 'ipfs pin ls'.
+func formatPinMetadata(obj RefKeyObject) string {
+	var out string
+	if obj.Name != "" {
+		out += fmt.Sprintf(" %q", obj.Name)
+	}
+	labels := make([]string, 0, len(obj.Labels))
+	for l, v := range obj.Labels {
+		labels = append(labels, l+"="+v)
+	}
+	sort.Strings(labels)
+	for _, l := range labels {
+		out += " " + l
+	}
+	if obj.Expires != nil {
+		if left := obj.Expires.Sub(time.Now()); left > 0 {
+			out += fmt.Sprintf(" (expires in %s)", left-left%time.Second)
+		} else {
+			out += " (expired)"
+		}
+	}
+	return out
+}
+
+// pinMetadataOptions reads the "name" and "labels" options of req. Labels
+// are given as comma separated key=value pairs.
+func pinMetadataOptions(req cmds.Request) (pin.Metadata, error) {
+	var m pin.Metadata
+	name, _, err := req.Option("name").String()
+	if err != nil {
+		return m, err
+	}
+	m.Name = name
+
+	labels, _, err := req.Option("labels").String()
+	if err != nil {
+		return m, err
+	}
+	if labels == "" {
+		return m, nil
+	}
+	m.Labels = make(map[string]string)
+	for _, kv := range strings.Split(labels, ",") {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return m, fmt.Errorf("invalid label %q, labels must be key=value", kv)
+		}
+		m.Labels[parts[0]] = parts[1]
+	}
+	return m, nil
 }
 
 type RefKeyList struct {
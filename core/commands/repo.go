@@ -4,9 +4,12 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"os"
 
 	cmds "github.com/ipfs/go-ipfs/commands"
 	corerepo "github.com/ipfs/go-ipfs/core/corerepo"
+	"github.com/ipfs/go-ipfs/repo/fsrepo"
+	mfsr "github.com/ipfs/go-ipfs/repo/fsrepo/migrations"
 	u "github.com/ipfs/go-ipfs/util"
 )
 
@@ -19,7 +22,12 @@ var RepoCmd = &cmds.Command{
 	},
 
 	Subcommands: map[string]*cmds.Command{
-		"gc": repoGcCmd,
+		"export":  repoExportCmd,
+		"gc":      repoGcCmd,
+		"import":  RepoImportCmd,
+		"migrate": RepoMigrateCmd,
+		"stat":    repoStatCmd,
+		"verify":  repoVerifyCmd,
 	},
 }
 
@@ -94,3 +102,374 @@ order to reclaim hard disk space.
 		},
 	},
 }
+
+var repoVerifyCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Verify the integrity of all blocks in the repo",
+		ShortDescription: `
+'ipfs repo verify' is a plumbing command that reads every block in the
+local blockstore and checks its data against its hash. Blocks that do
+not match are moved out of the blockstore into a quarantine area, so
+that they can be fetched again from the network.
+`,
+	},
+
+	Options: []cmds.Option{
+		cmds.BoolOption("quiet", "q", "Only report blocks that failed verification"),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, err := req.Context().GetNode()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		verifyChan, err := corerepo.VerifyAsync(n, req.Context().Context)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		outChan := make(chan interface{})
+		res.SetOutput((<-chan interface{})(outChan))
+
+		go func() {
+			defer close(outChan)
+			for v := range verifyChan {
+				outChan <- v
+			}
+		}()
+	},
+	Type: corerepo.BlockVerified{},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: func(res cmds.Response) (io.Reader, error) {
+			outChan, ok := res.Output().(<-chan interface{})
+			if !ok {
+				return nil, u.ErrCast()
+			}
+
+			quiet, _, err := res.Request().Option("quiet").Bool()
+			if err != nil {
+				return nil, err
+			}
+
+			marshal := func(v interface{}) (io.Reader, error) {
+				obj, ok := v.(*corerepo.BlockVerified)
+				if !ok {
+					return nil, u.ErrCast()
+				}
+
+				buf := new(bytes.Buffer)
+				switch {
+				case obj.Status == corerepo.BlockOk && quiet:
+				case obj.Error != "":
+					fmt.Fprintf(buf, "%s %s: %s\n", obj.Status, obj.Key, obj.Error)
+				default:
+					fmt.Fprintf(buf, "%s %s\n", obj.Status, obj.Key)
+				}
+				return buf, nil
+			}
+
+			return &cmds.ChannelMarshaler{
+				Channel:   outChan,
+				Marshaler: marshal,
+			}, nil
+		},
+	},
+}
+
+var repoStatCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Print status of the local repo",
+		ShortDescription: `
+'ipfs repo stat' reports the number of objects and the disk space used
+by each of the datastores that make up the local repo, followed by the
+total number of blocks, the total size, the repo path and its version.
+The hits and misses of the blockstore caches enabled in the config, and
+the size of the read cache, are listed last. They are counted since the node started, so they are only
+useful when asking a running daemon.
+`,
+	},
+
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, err := req.Context().GetNode()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		statChan, err := corerepo.RepoStatAsync(n, req.Context().Context, req.Context().ConfigRoot)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		outChan := make(chan interface{})
+		res.SetOutput((<-chan interface{})(outChan))
+
+		go func() {
+			defer close(outChan)
+			for s := range statChan {
+				outChan <- s
+			}
+		}()
+	},
+	Type: corerepo.Stat{},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: func(res cmds.Response) (io.Reader, error) {
+			outChan, ok := res.Output().(<-chan interface{})
+			if !ok {
+				return nil, u.ErrCast()
+			}
+
+			marshal := func(v interface{}) (io.Reader, error) {
+				stat, ok := v.(*corerepo.Stat)
+				if !ok {
+					return nil, u.ErrCast()
+				}
+
+				buf := new(bytes.Buffer)
+				if stat.Backend != "" {
+					fmt.Fprintf(buf, "%s:\n", stat.Backend)
+					fmt.Fprintf(buf, "\tNumObjects \t %d\n", stat.NumObjects)
+					fmt.Fprintf(buf, "\tSize \t %d\n", stat.RepoSize)
+					if stat.RepoPath != "" {
+						fmt.Fprintf(buf, "\tPath \t %s\n", stat.RepoPath)
+					}
+					return buf, nil
+				}
+				fmt.Fprintf(buf, "NumObjects \t %d\n", stat.NumObjects)
+				fmt.Fprintf(buf, "RepoSize \t %d\n", stat.RepoSize)
+				fmt.Fprintf(buf, "RepoPath \t %s\n", stat.RepoPath)
+				fmt.Fprintf(buf, "Version \t %s\n", stat.Version)
+				for _, c := range stat.Caches {
+					fmt.Fprintf(buf, "%s:\n", c.Name)
+					fmt.Fprintf(buf, "\tHits \t %d\n", c.Hits)
+					fmt.Fprintf(buf, "\tMisses \t %d\n", c.Misses)
+					if c.Size != 0 {
+						fmt.Fprintf(buf, "\tSize \t %d\n", c.Size)
+					}
+				}
+				return buf, nil
+			}
+
+			return &cmds.ChannelMarshaler{
+				Channel:   outChan,
+				Marshaler: marshal,
+			}, nil
+		},
+	},
+}
+
+type MigrateOutput struct {
+	Steps  []mfsr.Step
+	DryRun bool
+	Backup string `json:",omitempty"`
+}
+
+var RepoMigrateCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Migrate the repo to the version this program uses",
+		ShortDescription: `
+'ipfs repo migrate' moves the local repo to a new format version, one
+version at a time. By default it migrates to the version this program
+expects; use --to to pick another one, including older versions.
+
+Use --dry-run to list the migrations without running them, and --backup
+to copy the whole repo next to it before migrating. The daemon must not
+be running.
+`,
+	},
+
+	Options: []cmds.Option{
+		cmds.StringOption("to", "Version to migrate to (default: the current one)"),
+		cmds.BoolOption("dry-run", "n", "Only list the migrations that would run"),
+		cmds.BoolOption("backup", "b", "Copy the repo before migrating it"),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		to, found, err := req.Option("to").String()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+		if !found {
+			to = fsrepo.RepoVersion
+		}
+
+		var opts fsrepo.MigrateOptions
+		opts.DryRun, _, err = req.Option("dry-run").Bool()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+		opts.Backup, _, err = req.Option("backup").Bool()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		repoPath := req.Context().ConfigRoot
+		steps, err := fsrepo.Migrate(repoPath, to, opts)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		out := &MigrateOutput{Steps: steps, DryRun: opts.DryRun}
+		if opts.Backup && !opts.DryRun && len(steps) > 0 {
+			out.Backup = fsrepo.BackupPath(repoPath, steps[0].From)
+		}
+		res.SetOutput(out)
+	},
+	Type: MigrateOutput{},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: func(res cmds.Response) (io.Reader, error) {
+			out, ok := res.Output().(*MigrateOutput)
+			if !ok {
+				return nil, u.ErrCast()
+			}
+
+			buf := new(bytes.Buffer)
+			if len(out.Steps) == 0 {
+				fmt.Fprintln(buf, "repo is already at the requested version")
+				return buf, nil
+			}
+			if out.Backup != "" {
+				fmt.Fprintf(buf, "backed up repo to %s\n", out.Backup)
+			}
+			verb := "migrated"
+			if out.DryRun {
+				verb = "would migrate"
+			}
+			for _, s := range out.Steps {
+				fmt.Fprintf(buf, "%s repo from version %d to %d: %s\n", verb, s.From, s.To, s.Description)
+			}
+			return buf, nil
+		},
+	},
+}
+
+var repoExportCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Save the state of the node to an archive",
+		ShortDescription: `
+'ipfs repo export' writes every block in the local repo, the pin sets,
+the node's IPNS record and its config to a tar archive at <file>. Use
+'ipfs repo import' to restore it on another node.
+
+The private key is left out of the config unless --private-key is given.
+Keep archives made with it as safe as the key itself.
+`,
+	},
+
+	Arguments: []cmds.Argument{
+		cmds.StringArg("file", true, false, "Path of the archive to write"),
+	},
+	Options: []cmds.Option{
+		cmds.BoolOption("private-key", "Include the node's private key"),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, err := req.Context().GetNode()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		withKey, _, err := req.Option("private-key").Bool()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		pr, pw := io.Pipe()
+		go func() {
+			pw.CloseWithError(corerepo.Export(n, req.Context().Context, pw, withKey))
+		}()
+		res.SetOutput((io.Reader)(pr))
+	},
+	PostRun: func(req cmds.Request, res cmds.Response) {
+		if res.Output() == nil {
+			return
+		}
+		outReader := res.Output().(io.Reader)
+		res.SetOutput(nil)
+
+		outPath := req.Arguments()[0]
+		file, err := os.Create(outPath)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+		defer file.Close()
+
+		if _, err := io.Copy(file, outReader); err != nil {
+			os.Remove(outPath)
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+		fmt.Fprintf(res.Stderr(), "Saved repo to %s\n", outPath)
+	},
+}
+
+var RepoImportCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Restore the state of a node from an archive",
+		ShortDescription: `
+'ipfs repo import' reads an archive written by 'ipfs repo export' into
+the local repo. Every block is checked against its hash before it is
+stored; the import stops at the first one that does not match.
+
+Imported pins are added to the local ones. The imported config replaces
+the local one, except for the Datastore section, and the Identity
+section unless the archive holds a private key. The daemon must not be
+running.
+`,
+	},
+
+	Arguments: []cmds.Argument{
+		cmds.FileArg("file", true, false, "Archive to import"),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, err := req.Context().GetNode()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		file, err := req.Files().NextFile()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+		if file.IsDirectory() {
+			res.SetError(fmt.Errorf("%s is a directory", file.FileName()), cmds.ErrNormal)
+			return
+		}
+		defer file.Close()
+
+		stats, err := corerepo.Import(n, file)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+		res.SetOutput(stats)
+	},
+	Type: corerepo.ImportStats{},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: func(res cmds.Response) (io.Reader, error) {
+			stats, ok := res.Output().(*corerepo.ImportStats)
+			if !ok {
+				return nil, u.ErrCast()
+			}
+
+			buf := new(bytes.Buffer)
+			fmt.Fprintf(buf, "imported %d blocks and %d datastore keys\n", stats.Blocks, stats.DatastoreKeys)
+			if stats.PrivateKey {
+				fmt.Fprintln(buf, "imported config, including the private key")
+			} else {
+				fmt.Fprintln(buf, "imported config, keeping the local identity")
+			}
+			return buf, nil
+		},
+	},
+}
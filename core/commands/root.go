@@ -35,6 +35,8 @@ DATA STRUCTURE COMMANDS
 
     block         Interact with raw blocks in the datastore
     object        Interact with raw dag nodes
+    dag           Export and import DAGs as archives
+    filestore     Interact with the blocks of files added with --nocopy
 
 ADVANCED COMMANDS
 
@@ -84,9 +86,11 @@ var rootSubcommands = map[string]*cmds.Command{
 	"cat":       CatCmd,
 	"commands":  CommandsDaemonCmd,
 	"config":    ConfigCmd,
+	"dag":       DagCmd,
 	"dht":       DhtCmd,
 	"diag":      DiagCmd,
 	"dns":       DNSCmd,
+	"filestore": FilestoreCmd,
 	"get":       GetCmd,
 	"id":        IDCmd,
 	"log":       LogCmd,
@@ -45,6 +45,7 @@ import (
 	bsnet "github.com/ipfs/go-ipfs/exchange/bitswap/network"
 	offline "github.com/ipfs/go-ipfs/exchange/offline"
 	rp "github.com/ipfs/go-ipfs/exchange/reprovide"
+	filestore "github.com/ipfs/go-ipfs/filestore"
 
 	mount "github.com/ipfs/go-ipfs/fuse/mount"
 	ipnsfs "github.com/ipfs/go-ipfs/ipnsfs"
@@ -80,15 +81,20 @@ type IpfsNode struct {
 	Repo repo.Repo
 
 	// Local node
-	Pinning    pin.Pinner // the pinning manager
-	Mounts     Mounts     // current mount state, if any.
-	PrivateKey ic.PrivKey // the local node's private Key
+	Pinning    pin.Pinner   // the pinning manager
+	Held       pin.HeldKeys // nodes kept from gc while pins fetch them
+	Mounts     Mounts       // current mount state, if any.
+	PrivateKey ic.PrivKey   // the local node's private Key
 
 	// Services
 	Peerstore  peer.Peerstore       // storage for other Peer instances
-	Blockstore bstore.Blockstore    // the block store (lower level)
+	Blockstore bstore.GCBlockstore  // the block store (lower level)
+	BloomCache *bstore.BloomCache   // the blockstore bloom filter, if enabled
+	ReadCache  *bstore.ReadCache    // the blockstore read cache, if enabled
+	Filestore  *filestore.Filestore // blocks whose data is kept outside the repo
 	Blocks     *bserv.BlockService  // the block service, get/add blocks.
 	DAG        merkledag.DAGService // the merkle dag service, get/add objects.
+	LocalDAG   merkledag.DAGService // like DAG, but never fetches from the network.
 	Resolver   *path.Resolver       // the path resolution system
 	Reporter   metrics.Reporter
 	Discovery  discovery.Service
@@ -104,6 +110,8 @@ type IpfsNode struct {
 
 	IpnsFs *ipnsfs.Filesystem
 
+	localBlocks *bserv.BlockService // of LocalDAG
+
 	ctxgroup.ContextGroup
 
 	mode mode
@@ -140,6 +148,15 @@ func NewIPFSNode(parent context.Context, option ConfigOption) (*IpfsNode, error)
 	// to be initialized at this point, and 2) which variables will be
 	// initialized after this point.
 
+	var nodeCache *merkledag.NodeCache
+	if size := node.Repo.Config().Datastore.NodeCacheSize; size > 0 {
+		nodeCache, err = merkledag.NewNodeCache(size)
+		if err != nil {
+			return nil, err
+		}
+		// gc and repo verify delete blocks through the blockstore
+		node.Blockstore = bstore.NotifyDeletes(node.Blockstore, nodeCache.Invalidate)
+	}
 	node.Blocks, err = bserv.New(node.Blockstore, node.Exchange)
 	if err != nil {
 		return nil, err
@@ -147,16 +164,30 @@ func NewIPFSNode(parent context.Context, option ConfigOption) (*IpfsNode, error)
 	if node.Peerstore == nil {
 		node.Peerstore = peer.NewPeerstore()
 	}
-	node.DAG = merkledag.NewDAGService(node.Blocks)
-	node.Pinning, err = pin.LoadPinner(node.Repo.Datastore(), node.DAG)
+	if nodeCache != nil {
+		node.DAG = merkledag.NewCachedDAGService(node.Blocks, nodeCache)
+	} else {
+		node.DAG = merkledag.NewDAGService(node.Blocks)
+	}
+	localBlocks, err := bserv.New(node.Blockstore, offline.Exchange(node.Blockstore))
 	if err != nil {
+		return nil, err
+	}
+	node.localBlocks = localBlocks
+	node.LocalDAG = merkledag.NewDAGService(localBlocks)
+	// the pin sets are local, a missing one must not wait for the network
+	node.Pinning, err = pin.LoadPinner(node.Repo.Datastore(), node.DAG, node.LocalDAG)
+	if err == ds.ErrNotFound {
+		// no pins yet
 		node.Pinning = pin.NewPinner(node.Repo.Datastore(), node.DAG)
+	} else if err != nil {
+		return nil, fmt.Errorf("loading pins: %s", err)
 	}
 	node.Resolver = &path.Resolver{DAG: node.DAG}
 
 	// Setup the mutable ipns filesystem structure
 	if node.OnlineMode() {
-		fs, err := ipnsfs.NewFilesystem(ctx, node.DAG, node.Namesys, node.Pinning, node.PrivateKey)
+		fs, err := ipnsfs.NewFilesystem(ctx, node.DAG, node.Namesys, node.Pinning, node.Repo.Config().Unixfs.ShardThreshold(), node.PrivateKey)
 		if err != nil && err != kb.ErrLookupFailure {
 			return nil, err
 		}
@@ -221,10 +252,27 @@ func standardWithRouting(r repo.Repo, online bool, routingOption RoutingOption,
 			return nil, err
 		}
 
-		n.Blockstore, err = bstore.WriteCached(bstore.NewBlockstore(n.Repo.Datastore()), kSizeBlockstoreWriteCache)
+		bs := bstore.NewBlockstore(n.Repo.Datastore())
+		if size := n.Repo.Config().Datastore.BloomFilterSize; size > 0 {
+			n.BloomCache, err = bstore.BloomCached(bs, ctx, size)
+			if err != nil {
+				return nil, err
+			}
+			bs = n.BloomCache
+		}
+		if size := n.Repo.Config().Datastore.ReadCacheSize; size > 0 {
+			n.ReadCache, err = bstore.ReadCached(bs, size)
+			if err != nil {
+				return nil, err
+			}
+			bs = n.ReadCache
+		}
+		bs, err = bstore.WriteCached(bs, kSizeBlockstoreWriteCache)
 		if err != nil {
 			return nil, err
 		}
+		n.Filestore = filestore.New(n.Repo.Datastore())
+		n.Blockstore = filestore.Blockstore(bs, n.Filestore)
 
 		if online {
 			do := setupDiscoveryOption(n.Repo.Config().Discovery)
@@ -354,6 +402,10 @@ func (n *IpfsNode) teardown() error {
 		closers = append(closers, n.Blocks)
 	}
 
+	if n.localBlocks != nil {
+		closers = append(closers, n.localBlocks)
+	}
+
 	if n.Bootstrapper != nil {
 		closers = append(closers, n.Bootstrapper)
 	}
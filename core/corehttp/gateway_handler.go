@@ -18,6 +18,8 @@ import (
 	dag "github.com/ipfs/go-ipfs/merkledag"
 	path "github.com/ipfs/go-ipfs/path"
 	"github.com/ipfs/go-ipfs/routing"
+	ft "github.com/ipfs/go-ipfs/unixfs"
+	hamt "github.com/ipfs/go-ipfs/unixfs/hamt"
 	uio "github.com/ipfs/go-ipfs/unixfs/io"
 	u "github.com/ipfs/go-ipfs/util"
 )
@@ -167,11 +169,17 @@ func (i *gatewayHandler) getOrHeadHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	links, err := uio.DirLinks(ctx, i.node.DAG, nd)
+	if err != nil {
+		internalWebError(w, err)
+		return
+	}
+
 	// storage for directory listing
 	var dirListing []directoryItem
 	// loop through files
 	foundIndex := false
-	for _, link := range nd.Links {
+	for _, link := range links {
 		if link.Name == "index.html" {
 			if urlPath[len(urlPath)-1] != '/' {
 				http.Redirect(w, r, urlPath+"/", 302)
@@ -316,15 +324,15 @@ func (i *gatewayHandler) putHandler(w http.ResponseWriter, r *http.Request) {
 	if _, ok := err.(path.ErrNoLink); ok {
 		// Create empty directories, links will be made further down the code
 		for len(pathNodes) < len(components) {
-			pathNodes = append(pathNodes, uio.NewDirectory(i.node.DAG).GetNode())
+			pathNodes = append(pathNodes, uio.NewEmptyDirectory())
 		}
 	} else if err != nil {
 		webError(w, "Could not resolve parent object", err, http.StatusBadRequest)
 		return
 	}
 
-	for i := len(pathNodes) - 1; i >= 0; i-- {
-		newnode, err = pathNodes[i].UpdateNodeLink(components[i], newnode)
+	for j := len(pathNodes) - 1; j >= 0; j-- {
+		newnode, err = i.setLink(tctx, pathNodes[j], components[j], newnode)
 		if err != nil {
 			webError(w, "Could not update node links", err, http.StatusInternalServerError)
 			return
@@ -387,15 +395,14 @@ func (i *gatewayHandler) deleteHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// TODO(cyrptix): assumes len(pathNodes) > 1 - not found is an error above?
-	err = pathNodes[len(pathNodes)-1].RemoveNodeLink(components[len(components)-1])
+	newnode, err := i.removeLink(tctx, pathNodes[len(pathNodes)-1], components[len(components)-1])
 	if err != nil {
 		webError(w, "Could not delete link", err, http.StatusBadRequest)
 		return
 	}
 
-	newnode := pathNodes[len(pathNodes)-1]
-	for i := len(pathNodes) - 2; i >= 0; i-- {
-		newnode, err = pathNodes[i].UpdateNodeLink(components[i], newnode)
+	for j := len(pathNodes) - 2; j >= 0; j-- {
+		newnode, err = i.setLink(tctx, pathNodes[j], components[j], newnode)
 		if err != nil {
 			webError(w, "Could not update node links", err, http.StatusInternalServerError)
 			return
@@ -419,6 +426,63 @@ func (i *gatewayHandler) deleteHandler(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, ipfsPathPrefix+key.String()+"/"+strings.Join(components[:len(components)-1], "/"), http.StatusCreated)
 }
 
+// setLink returns a copy of parent with the link name pointing to nd. The
+// entries of unixfs directories are edited through a uio.Directory, so
+// that sharded directories stay valid.
+func (i *gatewayHandler) setLink(ctx context.Context, parent *dag.Node, name string, nd *dag.Node) (*dag.Node, error) {
+	dir, err := i.directory(parent)
+	if err != nil {
+		return nil, err
+	}
+	if dir == nil {
+		return parent.UpdateNodeLink(name, nd)
+	}
+	// the links of a shard do not carry the nodes for AddRecursive
+	if err := i.node.DAG.AddRecursive(nd); err != nil {
+		return nil, err
+	}
+	if err := dir.AddNode(ctx, name, nd); err != nil {
+		return nil, err
+	}
+	return dir.GetNode()
+}
+
+// removeLink returns a copy of parent without the link name, see setLink.
+func (i *gatewayHandler) removeLink(ctx context.Context, parent *dag.Node, name string) (*dag.Node, error) {
+	dir, err := i.directory(parent)
+	if err != nil {
+		return nil, err
+	}
+	if dir == nil {
+		nd := parent.Copy()
+		if err := nd.RemoveNodeLink(name); err != nil {
+			return nil, err
+		}
+		return nd, nil
+	}
+	if err := dir.RemoveChild(ctx, name); err != nil {
+		return nil, err
+	}
+	return dir.GetNode()
+}
+
+// directory returns a uio.Directory editing nd, or nil if nd is not a
+// unixfs directory.
+func (i *gatewayHandler) directory(nd *dag.Node) (*uio.Directory, error) {
+	if !hamt.IsShard(nd) {
+		pbd, err := ft.FromBytes(nd.Data)
+		if err != nil || pbd.GetType() != ft.TDirectory {
+			return nil, nil
+		}
+	}
+	dir, err := uio.NewDirectoryFromNode(i.node.DAG, nd)
+	if err != nil {
+		return nil, err
+	}
+	dir.SetShardThreshold(i.node.Repo.Config().Unixfs.ShardThreshold())
+	return dir, nil
+}
+
 func webError(w http.ResponseWriter, message string, err error, defaultCode int) {
 	if _, ok := err.(path.ErrNoLink); ok {
 		webErrorWithCode(w, message, err, http.StatusNotFound)
@@ -0,0 +1,314 @@
+package corerepo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
+	dsq "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/query"
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+
+	"github.com/ipfs/go-ipfs/core"
+	"github.com/ipfs/go-ipfs/merkledag"
+	path "github.com/ipfs/go-ipfs/path"
+	"github.com/ipfs/go-ipfs/pin"
+	u "github.com/ipfs/go-ipfs/util"
+)
+
+// Background pins are fetched by the daemon after 'ipfs pin add
+// --background' returned. Each one is recorded in the datastore below
+// pinningPrefix until it is in place, so that the daemon resumes it when
+// it is restarted.
+var pinningPrefix = ds.NewKey("/local/pinning")
+
+// The states reported by PinStatuses.
+const (
+	PinStatusPinning   = "pinning"
+	PinStatusFailed    = "failed"
+	PinStatusPinned    = "pinned"
+	PinStatusNotPinned = "not pinned"
+)
+
+// statusRecordInterval is how many nodes a background pin fetches between
+// updates of its record.
+const statusRecordInterval = 100
+
+var ErrBackgroundOffline = errors.New("background pinning needs a running daemon")
+
+// PinStatus is the state of a recursive pin.
+type PinStatus struct {
+	Key    u.Key
+	Status string
+	// Fetched is the number of nodes fetched so far by a background pin.
+	Fetched int    `json:",omitempty"`
+	Error   string `json:",omitempty"`
+	// Meta is recorded for a background pin once it is in place.
+	Meta pin.Metadata
+}
+
+// pinningLock serializes the updates of background pin records.
+var pinningLock sync.Mutex
+
+// PinBackground records recursive pins on the objects at paths as pinning,
+// and returns once the objects are found. The daemon then fetches and pins
+// them, and records meta for the pins unless it is empty.
+func PinBackground(n *core.IpfsNode, paths []string, meta pin.Metadata) ([]*PinStatus, error) {
+	if !n.OnlineMode() {
+		return nil, ErrBackgroundOffline
+	}
+
+	ctx, cancel := context.WithTimeout(n.Context(), time.Minute)
+	defer cancel()
+
+	var keys []u.Key
+	for _, fpath := range paths {
+		dagnode, err := core.Resolve(ctx, n, path.Path(fpath))
+		if err != nil {
+			return nil, fmt.Errorf("pin: %s", err)
+		}
+		k, err := dagnode.Key()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+
+	pinningLock.Lock()
+	defer pinningLock.Unlock()
+
+	d := n.Repo.Datastore()
+	var out []*PinStatus
+	for _, k := range keys {
+		st, err := loadPinStatus(d, k)
+		if err != nil && err != ds.ErrNotFound {
+			return nil, err
+		}
+		if err == nil && st.Status == PinStatusPinning {
+			// already running
+			out = append(out, st)
+			continue
+		}
+
+		st = &PinStatus{Key: k, Status: PinStatusPinning, Meta: meta}
+		if err := storePinStatus(d, st); err != nil {
+			return nil, err
+		}
+		cp := *st
+		out = append(out, &cp)
+		go runBackgroundPin(n, st)
+	}
+	return out, nil
+}
+
+// ResumeBackgroundPins restarts the background pins recorded as pinning,
+// which a previous daemon did not finish.
+func ResumeBackgroundPins(n *core.IpfsNode) error {
+	statuses, err := loadPinStatuses(n.Repo.Datastore())
+	if err != nil {
+		return err
+	}
+	for _, st := range statuses {
+		if st.Status == PinStatusPinning {
+			log.Infof("resuming background pin of %s", st.Key)
+			go runBackgroundPin(n, st)
+		}
+	}
+	return nil
+}
+
+// PinStatuses returns the state of the pins on keys, or of every
+// unfinished background pin if keys is empty.
+func PinStatuses(n *core.IpfsNode, keys []u.Key) ([]*PinStatus, error) {
+	pinningLock.Lock()
+	defer pinningLock.Unlock()
+
+	d := n.Repo.Datastore()
+	if len(keys) == 0 {
+		return loadPinStatuses(d)
+	}
+
+	var out []*PinStatus
+	for _, k := range keys {
+		st, err := loadPinStatus(d, k)
+		switch {
+		case err == nil:
+		case err != ds.ErrNotFound:
+			return nil, err
+		case n.Pinning.IsPinned(k):
+			st = &PinStatus{Key: k, Status: PinStatusPinned}
+		default:
+			st = &PinStatus{Key: k, Status: PinStatusNotPinned}
+		}
+		out = append(out, st)
+	}
+	return out, nil
+}
+
+func runBackgroundPin(n *core.IpfsNode, st *PinStatus) {
+	ctx := n.Context()
+	d := n.Repo.Datastore()
+
+	// every node is held before it is fetched, so that gc can not remove
+	// it between the time it is stored and the time it is held
+	var held []u.Key
+	hold := func(keys []u.Key) {
+		n.Held.Hold(keys)
+		held = append(held, keys...)
+	}
+	defer func() { n.Held.Release(held) }()
+
+	err := func() error {
+		hold([]u.Key{st.Key})
+		root, err := n.DAG.Get(ctx, st.Key)
+		if err != nil {
+			return err
+		}
+		fetched, err := fetchDAG(ctx, n.DAG, root, hold, func(fetched int) {
+			if fetched%statusRecordInterval != 0 {
+				return
+			}
+			pinningLock.Lock()
+			defer pinningLock.Unlock()
+			st.Fetched = fetched
+			if err := storePinStatus(d, st); err != nil {
+				log.Error(err)
+			}
+		})
+		if err != nil {
+			return err
+		}
+		st.Fetched = fetched
+
+		unlock := n.Blockstore.PinLock()
+		defer unlock()
+		if err := n.Pinning.Pin(ctx, root, true); err != nil {
+			return err
+		}
+		if !st.Meta.IsEmpty() {
+			if err := n.Pinning.SetMetadata(st.Key, st.Meta); err != nil {
+				return err
+			}
+		}
+		return n.Pinning.Flush()
+	}()
+
+	pinningLock.Lock()
+	defer pinningLock.Unlock()
+	switch {
+	case err == nil:
+		log.Infof("background pin of %s done, fetched %d nodes", st.Key, st.Fetched)
+		if err := d.Delete(pinStatusKey(st.Key)); err != nil {
+			log.Error(err)
+		}
+	case ctx.Err() != nil:
+		// the daemon is shutting down, leave the pin to be resumed
+	default:
+		log.Errorf("background pin of %s failed: %s", st.Key, err)
+		st.Status = PinStatusFailed
+		st.Error = err.Error()
+		if err := storePinStatus(d, st); err != nil {
+			log.Error(err)
+		}
+	}
+}
+
+// fetchDAG fetches every node below root, calling fetched with the number
+// of distinct nodes fetched so far, root included, and returns that
+// number. Unless hold is nil, it is called with the keys of the children
+// of each node before they are fetched.
+func fetchDAG(ctx context.Context, dserv merkledag.DAGService, root *merkledag.Node, hold func([]u.Key), fetched func(int)) (int, error) {
+	seen := make(map[u.Key]struct{})
+	var walk func(*merkledag.Node) error
+	walk = func(nd *merkledag.Node) error {
+		if hold != nil {
+			keys := make([]u.Key, len(nd.Links))
+			for i, l := range nd.Links {
+				keys[i] = u.Key(l.Hash)
+			}
+			hold(keys)
+		}
+		for _, ng := range dserv.GetDAG(ctx, nd) {
+			child, err := ng.Get(ctx)
+			if err != nil {
+				return err
+			}
+			k, err := child.Key()
+			if err != nil {
+				return err
+			}
+			if _, ok := seen[k]; ok {
+				continue
+			}
+			seen[k] = struct{}{}
+			fetched(len(seen) + 1)
+			if err := walk(child); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	fetched(1)
+	if err := walk(root); err != nil {
+		return 0, err
+	}
+	return len(seen) + 1, nil
+}
+
+func pinStatusKey(k u.Key) ds.Key {
+	return pinningPrefix.ChildString(k.B58String())
+}
+
+func loadPinStatus(d ds.Datastore, k u.Key) (*PinStatus, error) {
+	v, err := d.Get(pinStatusKey(k))
+	if err != nil {
+		return nil, err
+	}
+	return decodePinStatus(v)
+}
+
+func loadPinStatuses(d ds.Datastore) ([]*PinStatus, error) {
+	res, err := d.Query(dsq.Query{Prefix: pinningPrefix.String()})
+	if err != nil {
+		return nil, err
+	}
+	entries, err := res.Rest()
+	if err != nil {
+		return nil, err
+	}
+	var out []*PinStatus
+	for _, e := range entries {
+		if !pinningPrefix.IsAncestorOf(ds.NewKey(e.Key)) {
+			continue
+		}
+		st, err := decodePinStatus(e.Value)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, st)
+	}
+	return out, nil
+}
+
+func decodePinStatus(v interface{}) (*PinStatus, error) {
+	b, ok := v.([]byte)
+	if !ok {
+		return nil, errors.New("invalid background pin record in datastore")
+	}
+	st := new(PinStatus)
+	if err := json.Unmarshal(b, st); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+func storePinStatus(d ds.Datastore, st *PinStatus) error {
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	return d.Put(pinStatusKey(st.Key), b)
+}
@@ -0,0 +1,188 @@
+package corerepo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+	"github.com/ipfs/go-ipfs/blocks"
+	"github.com/ipfs/go-ipfs/core"
+	"github.com/ipfs/go-ipfs/merkledag"
+	"github.com/ipfs/go-ipfs/pin"
+	"github.com/ipfs/go-ipfs/repo"
+	"github.com/ipfs/go-ipfs/repo/config"
+	u "github.com/ipfs/go-ipfs/util"
+	"github.com/ipfs/go-ipfs/util/testutil"
+)
+
+func testNode(t *testing.T) *core.IpfsNode {
+	return testNodeWith(t, config.Datastore{})
+}
+
+// testNodeWith returns an offline node with the given datastore config.
+func testNodeWith(t *testing.T, dsCfg config.Datastore) *core.IpfsNode {
+	r := &repo.Mock{
+		C: config.Config{
+			Identity: config.Identity{
+				PeerID: "Qmfoo", // required by offline node
+			},
+			Datastore: dsCfg,
+		},
+		D: testutil.ThreadSafeCloserMapDatastore(),
+	}
+	n, err := core.NewIPFSNode(context.Background(), core.Offline(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// testDAG adds root{a, b{a}} and returns root. The blocks are put in the
+// blockstore directly: the offline exchange stores the blocks added through
+// the DAG service again from a worker, which would race with gc.
+func testDAG(t *testing.T, n *core.IpfsNode) *merkledag.Node {
+	a := &merkledag.Node{Data: []byte("a")}
+	b := &merkledag.Node{Data: []byte("b")}
+	root := &merkledag.Node{Data: []byte("root")}
+	if err := b.AddNodeLink("a", a); err != nil {
+		t.Fatal(err)
+	}
+	if err := root.AddNodeLink("a", a); err != nil {
+		t.Fatal(err)
+	}
+	if err := root.AddNodeLink("b", b); err != nil {
+		t.Fatal(err)
+	}
+	for _, nd := range []*merkledag.Node{a, b, root} {
+		data, err := nd.Encoded(false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := n.Blockstore.Put(blocks.NewBlock(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+// waitStatus waits for the pin on k to leave the pinning state.
+func waitStatus(t *testing.T, n *core.IpfsNode, k u.Key) *PinStatus {
+	for i := 0; i < 100; i++ {
+		statuses, err := PinStatuses(n, []u.Key{k})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if statuses[0].Status != PinStatusPinning {
+			return statuses[0]
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("background pin did not finish")
+	return nil
+}
+
+func TestFetchDAGCountsDistinctNodes(t *testing.T) {
+	n := testNode(t)
+	root := testDAG(t, n)
+
+	var last int
+	total, err := fetchDAG(context.Background(), n.DAG, root, nil, func(c int) { last = c })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 3 || last != 3 {
+		t.Fatalf("expected 3 nodes fetched, got %d (last progress %d)", total, last)
+	}
+}
+
+func TestGCKeepsFetchedNodes(t *testing.T) {
+	n := testNode(t)
+	root := testDAG(t, n)
+	rk, _ := root.Key()
+
+	held := []u.Key{rk}
+	hold := func(keys []u.Key) {
+		n.Held.Hold(keys)
+		held = append(held, keys...)
+	}
+	n.Held.Hold(held)
+	if _, err := fetchDAG(context.Background(), n.DAG, root, hold, func(int) {}); err != nil {
+		t.Fatal(err)
+	}
+	if err := GarbageCollect(n, context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if count := countBlocks(t, n); count != 3 {
+		t.Fatalf("expected gc to keep the 3 fetched nodes, %d left", count)
+	}
+
+	// the holds of a node do not keep the blocks of another
+	other := testNode(t)
+	testDAG(t, other)
+	if err := GarbageCollect(other, context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if count := countBlocks(t, other); count != 0 {
+		t.Fatalf("expected gc to remove the nodes held by another node, %d left", count)
+	}
+
+	n.Held.Release(held)
+	if err := GarbageCollect(n, context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if count := countBlocks(t, n); count != 0 {
+		t.Fatalf("expected gc to remove the released nodes, %d left", count)
+	}
+}
+
+func TestResumeBackgroundPins(t *testing.T) {
+	n := testNode(t)
+	root := testDAG(t, n)
+	k, err := root.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing := u.Key(u.Hash([]byte("not in the repo")))
+
+	// as left behind by a daemon that stopped while pinning
+	d := n.Repo.Datastore()
+	meta := pin.Metadata{Name: "resumed"}
+	for _, st := range []*PinStatus{
+		{Key: k, Status: PinStatusPinning, Fetched: 1, Meta: meta},
+		{Key: missing, Status: PinStatusPinning},
+	} {
+		if err := storePinStatus(d, st); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := ResumeBackgroundPins(n); err != nil {
+		t.Fatal(err)
+	}
+
+	if st := waitStatus(t, n, k); st.Status != PinStatusPinned {
+		t.Fatal("expected the pin to be in place, got", st.Status)
+	}
+	if m, ok := n.Pinning.Metadata(k); !ok || m.Name != "resumed" {
+		t.Fatal("the pin metadata was not recorded")
+	}
+	if st := waitStatus(t, n, missing); st.Status != PinStatusFailed || st.Error == "" {
+		t.Fatal("expected the pin of a missing object to fail, got", st.Status)
+	}
+
+	// only the failed pin is left
+	statuses, err := PinStatuses(n, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 1 || statuses[0].Key != missing {
+		t.Fatal("expected only the failed pin to be listed, got", statuses)
+	}
+}
+
+func TestPinBackgroundNeedsDaemon(t *testing.T) {
+	n := testNode(t)
+	if _, err := PinBackground(n, []string{"/ipfs/QmTumTjvcYCAvRRwQ8sDRxh8ezmrcr88YFU7iYNroGGTBZ"}, pin.Metadata{}); err != ErrBackgroundOffline {
+		t.Fatal("expected background pinning to fail offline, got", err)
+	}
+}
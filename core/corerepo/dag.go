@@ -0,0 +1,41 @@
+package corerepo
+
+import (
+	"io"
+
+	"github.com/ipfs/go-ipfs/core"
+	"github.com/ipfs/go-ipfs/merkledag/archive"
+	path "github.com/ipfs/go-ipfs/path"
+	u "github.com/ipfs/go-ipfs/util"
+)
+
+// ImportDAG adds the nodes in the DAG archive in r, and pins the archived
+// roots recursively if pin is set. It returns the keys of the roots and
+// the number of nodes added. The pin lock is only taken to pin the roots,
+// once the archive is imported; the imported nodes are held from gc until
+// then.
+func ImportDAG(n *core.IpfsNode, r io.Reader, pin bool) ([]u.Key, int, error) {
+	if !pin {
+		return archive.Import(n.DAG, r, nil)
+	}
+
+	var held []u.Key
+	defer func() { n.Held.Release(held) }()
+	roots, count, err := archive.Import(n.DAG, r, func(k u.Key) {
+		n.Held.Hold([]u.Key{k})
+		held = append(held, k)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// pinning fetches whatever the archive did not hold
+	paths := make([]string, len(roots))
+	for i, k := range roots {
+		paths[i] = path.FromKey(k).String()
+	}
+	if _, err := PinWithOptions(n, paths, PinOptions{Recursive: true}); err != nil {
+		return nil, 0, err
+	}
+	return roots, count, nil
+}
@@ -0,0 +1,399 @@
+package corerepo
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	b58 "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-base58"
+	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
+	syncds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/sync"
+	mh "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-multihash"
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+	"github.com/ipfs/go-ipfs/blocks"
+	"github.com/ipfs/go-ipfs/core"
+	peer "github.com/ipfs/go-ipfs/p2p/peer"
+	"github.com/ipfs/go-ipfs/pin"
+	"github.com/ipfs/go-ipfs/repo/config"
+	u "github.com/ipfs/go-ipfs/util"
+)
+
+// Layout of the archives written by Export. The version file comes first,
+// the config last, so that Import can tell a truncated archive. Blocks are
+// named by their base58 key, datastore entries by their hex encoded key,
+// as datastore keys may hold any byte.
+const (
+	exportVersion = "2"
+
+	archiveVersionFile  = "ipfs-repo-export"
+	archiveBlocksDir    = "blocks/"
+	archiveDatastoreDir = "datastore/"
+	archiveConfigFile   = "config"
+)
+
+// importBatchSize is the number of blocks Import writes at once.
+const importBatchSize = 256
+
+var (
+	ErrBadArchive       = errors.New("not an ipfs repo export")
+	ErrTruncatedArchive = errors.New("repo export is truncated")
+)
+
+// ImportStats reports what Import restored.
+type ImportStats struct {
+	Blocks        int
+	DatastoreKeys int
+	PrivateKey    bool
+}
+
+// Export writes a tar archive of the node's state to w: every block, the
+// pin sets, the node's IPNS record and its config. The private key is left
+// out of the config unless withKey is set.
+func Export(n *core.IpfsNode, ctx context.Context, w io.Writer, withKey bool) error {
+	// the pin sets have to match the blocks
+	unlock := n.Blockstore.PinLock()
+	defer unlock()
+	if err := n.Pinning.Flush(); err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(w)
+	put := func(name string, data []byte) error {
+		err := tw.WriteHeader(&tar.Header{
+			Name:     name,
+			Mode:     0644,
+			Size:     int64(len(data)),
+			ModTime:  time.Now(),
+			Typeflag: tar.TypeReg,
+		})
+		if err != nil {
+			return err
+		}
+		_, err = tw.Write(data)
+		return err
+	}
+
+	if err := put(archiveVersionFile, []byte(exportVersion+"\n")); err != nil {
+		return err
+	}
+
+	keys, err := n.Blockstore.AllKeysChan(ctx)
+	if err != nil {
+		return err
+	}
+	for k := range keys {
+		b, err := n.Blockstore.Get(k)
+		if err != nil {
+			return err
+		}
+		if err := put(archiveBlocksDir+k.B58String(), b.Data); err != nil {
+			return err
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	for _, k := range exportedDatastoreKeys(n.Identity) {
+		v, err := n.Repo.Datastore().Get(k)
+		if err == ds.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		data, ok := v.([]byte)
+		if !ok {
+			return fmt.Errorf("datastore value at %s is not []byte", k)
+		}
+		if err := put(archiveDatastoreDir+hex.EncodeToString(k.Bytes()), data); err != nil {
+			return err
+		}
+	}
+
+	cfg := *n.Repo.Config()
+	if !withKey {
+		cfg.Identity.PrivKey = ""
+	}
+	cfgdata, err := config.Marshal(&cfg)
+	if err != nil {
+		return err
+	}
+	if err := put(archiveConfigFile, cfgdata); err != nil {
+		return err
+	}
+
+	return tw.Close()
+}
+
+// exportedDatastoreKeys returns the keys of the pin set root and of the
+// IPNS record and public key of the node id. The pin sets themselves are
+// blocks.
+func exportedDatastoreKeys(id peer.ID) []ds.Key {
+	return []ds.Key{
+		pin.DatastoreKey,
+		// the records namesys publishes for this node, see namesys.Publish
+		u.Key("/ipns/" + string(id)).DsKey(),
+		u.Key("/pk/" + string(id)).DsKey(),
+	}
+}
+
+// checkDatastoreKeys returns an error unless keys are among the keys
+// Export writes for the node cfg belongs to. Anything else could overwrite
+// any part of the repo, blocks included, without being checked.
+func checkDatastoreKeys(cfg *config.Config, keys []ds.Key) error {
+	// decoded like core does it for the node's own id
+	id := peer.ID(b58.Decode(cfg.Identity.PeerID))
+	exported := make(map[ds.Key]struct{})
+	for _, k := range exportedDatastoreKeys(id) {
+		exported[k] = struct{}{}
+	}
+	for _, k := range keys {
+		if _, ok := exported[k]; !ok {
+			return fmt.Errorf("unexpected datastore key %s in archive", k)
+		}
+	}
+	return nil
+}
+
+// Import restores an archive written by Export into the node. The archive
+// is read completely, every block checked against its hash and every
+// datastore key against the keys Export writes before anything is written,
+// so that a corrupt or truncated archive leaves the repo as it was; it is
+// staged in a temporary file meanwhile. Imported pins are added to the
+// node's pins. The imported config replaces the node's config, but keeps
+// the node's datastore layout, and its identity unless the archive carries
+// a private key.
+func Import(n *core.IpfsNode, r io.Reader) (*ImportStats, error) {
+	staged, err := ioutil.TempFile("", "ipfs-import-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(staged.Name())
+	defer staged.Close()
+
+	var keys []ds.Key
+	collectKey := func(k ds.Key, _ []byte) error {
+		keys = append(keys, k)
+		return nil
+	}
+	cfg, err := readArchive(io.TeeReader(r, staged), nil, collectKey)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkDatastoreKeys(cfg, keys); err != nil {
+		return nil, err
+	}
+	if _, err := staged.Seek(0, 0); err != nil {
+		return nil, err
+	}
+
+	unlock := n.Blockstore.PinLock()
+	defer unlock()
+
+	stats := new(ImportStats)
+	pins := syncds.MutexWrap(ds.NewMapDatastore())
+	var batch []*blocks.Block
+	putBlock := func(b *blocks.Block) error {
+		batch = append(batch, b)
+		if len(batch) < importBatchSize {
+			return nil
+		}
+		if err := n.Blockstore.PutMany(batch); err != nil {
+			return err
+		}
+		stats.Blocks += len(batch)
+		batch = nil
+		return nil
+	}
+	putDatastore := func(k ds.Key, data []byte) error {
+		stats.DatastoreKeys++
+		if k == pin.DatastoreKey {
+			return pins.Put(k, data)
+		}
+		return n.Repo.Datastore().Put(k, data)
+	}
+	cfg, err = readArchive(staged, putBlock, putDatastore)
+	if err != nil {
+		return nil, err
+	}
+	if err := n.Blockstore.PutMany(batch); err != nil {
+		return nil, err
+	}
+	stats.Blocks += len(batch)
+
+	if err := importPins(n, pins); err != nil {
+		return nil, err
+	}
+
+	local := n.Repo.Config()
+	cfg.Datastore = local.Datastore
+	if cfg.Identity.PrivKey == "" {
+		cfg.Identity = local.Identity
+	} else {
+		stats.PrivateKey = true
+	}
+	if err := n.Repo.SetConfig(cfg); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// readArchive reads an archive written by Export, checking each entry, and
+// returns the config it ends with. The blocks and datastore entries are
+// passed to putBlock and putDatastore, unless they are nil.
+func readArchive(r io.Reader, putBlock func(*blocks.Block) error, putDatastore func(ds.Key, []byte) error) (*config.Config, error) {
+	var cfg *config.Config
+
+	tr := tar.NewReader(r)
+	for first := true; ; first = false {
+		h, err := tr.Next()
+		if err != nil && first {
+			return nil, ErrBadArchive
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+
+		if first {
+			if h.Name != archiveVersionFile {
+				return nil, ErrBadArchive
+			}
+			if v := strings.TrimSpace(string(data)); v != exportVersion {
+				return nil, fmt.Errorf("unsupported export version %s", v)
+			}
+			continue
+		}
+		if cfg != nil {
+			return nil, fmt.Errorf("unexpected file after the config in archive: %s", h.Name)
+		}
+
+		switch {
+		case h.Typeflag == tar.TypeDir:
+
+		case strings.HasPrefix(h.Name, archiveBlocksDir):
+			b, err := checkedBlock(strings.TrimPrefix(h.Name, archiveBlocksDir), data)
+			if err != nil {
+				return nil, err
+			}
+			if putBlock != nil {
+				if err := putBlock(b); err != nil {
+					return nil, err
+				}
+			}
+
+		case strings.HasPrefix(h.Name, archiveDatastoreDir):
+			kb, err := hex.DecodeString(strings.TrimPrefix(h.Name, archiveDatastoreDir))
+			if err != nil {
+				return nil, fmt.Errorf("invalid datastore key %s in archive", h.Name)
+			}
+			if putDatastore != nil {
+				if err := putDatastore(ds.NewKey(string(kb)), data); err != nil {
+					return nil, err
+				}
+			}
+
+		case h.Name == archiveConfigFile:
+			cfg = new(config.Config)
+			if err := json.Unmarshal(data, cfg); err != nil {
+				return nil, fmt.Errorf("invalid config in archive: %s", err)
+			}
+
+		default:
+			return nil, fmt.Errorf("unexpected file in archive: %s", h.Name)
+		}
+	}
+
+	if cfg == nil {
+		return nil, ErrTruncatedArchive
+	}
+	return cfg, nil
+}
+
+// checkedBlock returns the block named name, after checking that data
+// hashes to it.
+func checkedBlock(name string, data []byte) (*blocks.Block, error) {
+	k := u.B58KeyDecode(name)
+	dh, err := mh.Decode([]byte(k))
+	if err != nil {
+		return nil, fmt.Errorf("invalid block name %s in archive: %s", name, err)
+	}
+	sum, err := u.Sum(data, dh.Code, dh.Length)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(sum, []byte(k)) {
+		return nil, fmt.Errorf("block %s in archive does not match its hash", name)
+	}
+	return blocks.NewBlockWithHash(data, sum)
+}
+
+// importPins adds the pins stored in d to the node's pins. The recursive
+// pins the node does not have yet are pinned again rather than copied, so
+// that the indirect refcounts of DAGs also pinned by the node are not
+// counted twice; their blocks are all in the repo by now.
+func importPins(n *core.IpfsNode, d ds.ThreadSafeDatastore) error {
+	imported, err := pin.LoadPinner(d, n.DAG, n.LocalDAG)
+	if err == ds.ErrNotFound {
+		// the archive had no pins
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("pins in archive: %s", err)
+	}
+
+	recursive := make(map[u.Key]struct{})
+	for _, k := range n.Pinning.RecursiveKeys() {
+		recursive[k] = struct{}{}
+	}
+	ctx := n.Context()
+	for _, k := range imported.RecursiveKeys() {
+		if _, ok := recursive[k]; ok {
+			continue
+		}
+		root, err := n.DAG.Get(ctx, k)
+		if err != nil {
+			return fmt.Errorf("pin: %s", err)
+		}
+		if err := n.Pinning.Pin(ctx, root, true); err != nil {
+			return fmt.Errorf("pin: %s", err)
+		}
+		recursive[k] = struct{}{}
+	}
+	mp := n.Pinning.GetManual()
+	for _, k := range imported.DirectKeys() {
+		if _, ok := recursive[k]; !ok {
+			mp.PinWithMode(k, pin.Direct)
+		}
+	}
+
+	// metadata the node already has for a pin wins
+	for _, k := range append(imported.RecursiveKeys(), imported.DirectKeys()...) {
+		m, ok := imported.Metadata(k)
+		if !ok {
+			continue
+		}
+		if _, ok := n.Pinning.Metadata(k); ok {
+			continue
+		}
+		if err := n.Pinning.SetMetadata(k, m); err != nil {
+			return err
+		}
+	}
+	return n.Pinning.Flush()
+}
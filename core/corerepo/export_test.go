@@ -0,0 +1,170 @@
+package corerepo
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
+	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+	"github.com/ipfs/go-ipfs/blocks"
+	bstore "github.com/ipfs/go-ipfs/blocks/blockstore"
+	"github.com/ipfs/go-ipfs/core"
+	u "github.com/ipfs/go-ipfs/util"
+)
+
+func TestImportWritesNothingFromTruncatedArchive(t *testing.T) {
+	ctx := context.Background()
+	src := testNode(t)
+	root := testDAG(t, src)
+	if err := src.Pinning.Pin(ctx, root, true); err != nil {
+		t.Fatal(err)
+	}
+	// more than one batch of blocks
+	for i := 0; i < 2*importBatchSize; i++ {
+		if err := src.Blockstore.Put(blocks.NewBlock([]byte(fmt.Sprint(i)))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var archive bytes.Buffer
+	if err := Export(src, ctx, &archive, false); err != nil {
+		t.Fatal(err)
+	}
+	rk, _ := root.Key()
+
+	// the config comes last
+	dst := testNode(t)
+	before := countBlocks(t, dst)
+	truncated := archive.Bytes()[:archive.Len()-4096]
+	if _, err := Import(dst, bytes.NewReader(truncated)); err == nil {
+		t.Fatal("expected an error for a truncated archive")
+	}
+	if countBlocks(t, dst) != before {
+		t.Fatal("blocks of a truncated archive were imported")
+	}
+
+	stats, err := Import(dst, &archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Blocks == 0 {
+		t.Fatal("no blocks imported")
+	}
+	if has, _ := dst.Blockstore.Has(rk); !has {
+		t.Fatal("exported block missing after import")
+	}
+	if !dst.Pinning.IsPinned(rk) {
+		t.Fatal("exported pin missing after import")
+	}
+}
+
+func countBlocks(t *testing.T, n *core.IpfsNode) int {
+	keys, err := n.Blockstore.AllKeysChan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	for _ = range keys {
+		count++
+	}
+	return count
+}
+
+func TestImportKeepsIndirectRefcounts(t *testing.T) {
+	ctx := context.Background()
+	n := testNode(t)
+	root := testDAG(t, n)
+	if err := n.Pinning.Pin(ctx, root, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Pinning.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	before := make(map[u.Key]int)
+	for k, refs := range n.Pinning.IndirectKeys() {
+		before[k] = refs
+	}
+
+	var archive bytes.Buffer
+	if err := Export(n, ctx, &archive, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Import(n, &archive); err != nil {
+		t.Fatal(err)
+	}
+
+	after := n.Pinning.IndirectKeys()
+	if len(after) != len(before) {
+		t.Fatalf("expected %d indirect pins, got %d", len(before), len(after))
+	}
+	for k, refs := range before {
+		if after[k] != refs {
+			t.Errorf("indirect pin on %s: expected %d refs, got %d", k, refs, after[k])
+		}
+	}
+}
+
+func TestImportRefusesOtherDatastoreKeys(t *testing.T) {
+	ctx := context.Background()
+	src := testNode(t)
+	var archive bytes.Buffer
+	if err := Export(src, ctx, &archive, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// a block that skips the hash check
+	planted := blocks.NewBlock([]byte("planted"))
+	key := bstore.BlockPrefix.Child(planted.Key().DsKey())
+	var crafted bytes.Buffer
+	tr := tar.NewReader(&archive)
+	tw := tar.NewWriter(&crafted)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h.Name == archiveConfigFile {
+			fake := []byte("not the planted data")
+			err := tw.WriteHeader(&tar.Header{
+				Name:     archiveDatastoreDir + hex.EncodeToString(key.Bytes()),
+				Mode:     0644,
+				Size:     int64(len(fake)),
+				Typeflag: tar.TypeReg,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := tw.Write(fake); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := testNode(t)
+	if _, err := Import(dst, &crafted); err == nil {
+		t.Fatal("expected an error for a datastore entry Export does not write")
+	}
+	if _, err := dst.Repo.Datastore().Get(key); err != ds.ErrNotFound {
+		t.Fatal("the datastore entry was imported")
+	}
+}
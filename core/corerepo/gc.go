@@ -1,8 +1,13 @@
 package corerepo
 
 import (
+	"errors"
+	"time"
+
+	humanize "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/dustin/go-humanize"
 	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
 	"github.com/ipfs/go-ipfs/core"
+	"github.com/ipfs/go-ipfs/repo"
 	u "github.com/ipfs/go-ipfs/util"
 
 	eventlog "github.com/ipfs/go-ipfs/thirdparty/eventlog"
@@ -10,19 +15,35 @@ import (
 
 var log = eventlog.Logger("corerepo")
 
+var ErrMaxStorageExceeded = repo.ErrMaxStorageExceeded
+
+const (
+	defaultStorageGCWatermark = 90
+	defaultGCPeriod           = time.Hour
+)
+
 type KeyRemoved struct {
 	Key u.Key
 }
 
+// GarbageCollect removes expired pins, then all blocks that are not
+// pinned, except those held by pins in progress. It holds the blockstore's
+// GC lock, so it waits for in-progress adds and pins to finish.
 func GarbageCollect(n *core.IpfsNode, ctx context.Context) error {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel() // in case error occurs during operation
+	if _, err := UnpinExpired(n); err != nil {
+		return err
+	}
+	unlock := n.Blockstore.GCLock()
+	defer unlock()
+
 	keychan, err := n.Blockstore.AllKeysChan(ctx)
 	if err != nil {
 		return err
 	}
 	for k := range keychan { // rely on AllKeysChan to close chan
-		if !n.Pinning.IsPinned(k) {
+		if !n.Pinning.IsPinned(k) && !n.Held.IsHeld(k) {
 			err := n.Blockstore.DeleteBlock(k)
 			if err != nil {
 				return err
@@ -32,23 +53,31 @@ func GarbageCollect(n *core.IpfsNode, ctx context.Context) error {
 	return nil
 }
 
+// GarbageCollectAsync is like GarbageCollect, but sends the keys of removed
+// blocks over the returned channel. The GC lock is held until it is closed.
 func GarbageCollectAsync(n *core.IpfsNode, ctx context.Context) (<-chan *KeyRemoved, error) {
+	if _, err := UnpinExpired(n); err != nil {
+		return nil, err
+	}
+	unlock := n.Blockstore.GCLock()
 
 	keychan, err := n.Blockstore.AllKeysChan(ctx)
 	if err != nil {
+		unlock()
 		return nil, err
 	}
 
 	output := make(chan *KeyRemoved)
 	go func() {
 		defer close(output)
+		defer unlock()
 		for {
 			select {
 			case k, ok := <-keychan:
 				if !ok {
 					return
 				}
-				if !n.Pinning.IsPinned(k) {
+				if !n.Pinning.IsPinned(k) && !n.Held.IsHeld(k) {
 					err := n.Blockstore.DeleteBlock(k)
 					if err != nil {
 						log.Debugf("Error removing key from blockstore: %s", err)
@@ -66,3 +95,108 @@ func GarbageCollectAsync(n *core.IpfsNode, ctx context.Context) (<-chan *KeyRemo
 	}()
 	return output, nil
 }
+
+// gcConfig holds the storage limits read from Datastore config.
+type gcConfig struct {
+	storageMax uint64 // zero if there is no limit
+	storageGC  uint64 // watermark above which gc runs
+	period     time.Duration
+}
+
+func readGCConfig(n *core.IpfsNode) (*gcConfig, error) {
+	cfg := n.Repo.Config().Datastore
+	gc := &gcConfig{period: defaultGCPeriod}
+
+	max, err := cfg.StorageMaxBytes()
+	if err != nil {
+		return nil, err
+	}
+	if max == 0 {
+		return gc, nil
+	}
+	gc.storageMax = max
+
+	watermark := cfg.StorageGCWatermark
+	if watermark <= 0 || watermark > 100 {
+		watermark = defaultStorageGCWatermark
+	}
+	gc.storageGC = max * uint64(watermark) / 100
+
+	if cfg.GCPeriod != "" {
+		gc.period, err = time.ParseDuration(cfg.GCPeriod)
+		if err != nil {
+			return nil, err
+		}
+		if gc.period <= 0 {
+			return nil, errors.New("Datastore.GCPeriod must be positive")
+		}
+	}
+	return gc, nil
+}
+
+// PeriodicGC checks the repo size every Datastore.GCPeriod and runs a
+// garbage collection when it has grown above the Datastore.StorageGCWatermark
+// percentage of Datastore.StorageMax. It returns immediately if no
+// StorageMax is configured, and otherwise runs until ctx is done.
+func PeriodicGC(n *core.IpfsNode, ctx context.Context) error {
+	gc, err := readGCConfig(n)
+	if err != nil {
+		return err
+	}
+	if gc.storageMax == 0 {
+		return nil
+	}
+
+	ticker := time.NewTicker(gc.period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := maybeGC(n, ctx, gc, 0); err != nil && err != ErrMaxStorageExceeded {
+				log.Error(err)
+			}
+		}
+	}
+}
+
+// ConditionalGC is called before adding offset bytes to the repo. It runs a
+// garbage collection if the addition would take the repo above the gc
+// watermark, and returns ErrMaxStorageExceeded if it would still exceed
+// Datastore.StorageMax afterwards.
+func ConditionalGC(n *core.IpfsNode, ctx context.Context, offset uint64) error {
+	gc, err := readGCConfig(n)
+	if err != nil {
+		return err
+	}
+	if gc.storageMax == 0 {
+		return nil
+	}
+	return maybeGC(n, ctx, gc, offset)
+}
+
+func maybeGC(n *core.IpfsNode, ctx context.Context, gc *gcConfig, offset uint64) error {
+	usage, err := n.Repo.GetStorageUsage()
+	if err != nil {
+		return err
+	}
+	if usage+offset <= gc.storageGC {
+		return nil
+	}
+
+	log.Infof("Repo size %s is above the gc watermark %s, running gc",
+		humanize.Bytes(usage+offset), humanize.Bytes(gc.storageGC))
+	if err := GarbageCollect(n, ctx); err != nil {
+		return err
+	}
+
+	usage, err = n.Repo.GetStorageUsage()
+	if err != nil {
+		return err
+	}
+	if usage+offset > gc.storageMax {
+		return ErrMaxStorageExceeded
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package corerepo
+
+import (
+	"testing"
+
+	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+	"github.com/ipfs/go-ipfs/repo/config"
+)
+
+func TestGCDropsCachedNodes(t *testing.T) {
+	n := testNodeWith(t, config.Datastore{NodeCacheSize: 1 << 20})
+	root := testDAG(t, n)
+	k, _ := root.Key()
+
+	// cache the nodes, then collect them
+	if _, err := fetchDAG(context.Background(), n.DAG, root, nil, func(int) {}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := n.DAG.Get(context.Background(), k); err != nil {
+		t.Fatal(err)
+	}
+	if err := GarbageCollect(n, context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := n.DAG.Get(ctx, k); err == nil {
+		t.Fatal("collected node still returned")
+	}
+	if err := n.Pinning.Pin(ctx, root, true); err == nil {
+		t.Fatal("pinned a collected DAG")
+	}
+}
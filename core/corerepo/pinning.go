@@ -22,35 +22,91 @@ import (
 	"github.com/ipfs/go-ipfs/core"
 	"github.com/ipfs/go-ipfs/merkledag"
 	path "github.com/ipfs/go-ipfs/path"
+	"github.com/ipfs/go-ipfs/pin"
 	u "github.com/ipfs/go-ipfs/util"
 )
 
+// PinOptions controls how PinWithOptions pins objects.
+type PinOptions struct {
+	Recursive bool
+	// Meta is recorded for each of the pins, unless it is empty.
+	Meta pin.Metadata
+	// Progress, if set, is called with the number of nodes fetched so far
+	// while fetching the objects to pin recursively.
+	Progress func(fetched int)
+}
+
 func Pin(n *core.IpfsNode, paths []string, recursive bool) ([]u.Key, error) {
+	return PinWithOptions(n, paths, PinOptions{Recursive: recursive})
+}
+
+// PinWithOptions pins the objects at paths like Pin, as set by opts.
+func PinWithOptions(n *core.IpfsNode, paths []string, opts PinOptions) ([]u.Key, error) {
 	// TODO(cryptix): do we want a ctx as first param for (Un)Pin() as well, just like core.Resolve?
 	ctx := n.Context()
 
+	// the objects are fetched without the pin lock, which would keep gc
+	// and adds waiting meanwhile. Their nodes are held instead, and the
+	// lock is only taken to pin them.
+	var held []u.Key
+	hold := func(keys []u.Key) {
+		n.Held.Hold(keys)
+		held = append(held, keys...)
+	}
+	defer func() { n.Held.Release(held) }()
+
 	dagnodes := make([]*merkledag.Node, 0)
 	for _, fpath := range paths {
 		dagnode, err := core.Resolve(ctx, n, path.Path(fpath))
 		if err != nil {
 			return nil, fmt.Errorf("pin: %s", err)
 		}
+		k, err := dagnode.Key()
+		if err != nil {
+			return nil, err
+		}
+		hold([]u.Key{k})
 		dagnodes = append(dagnodes, dagnode)
 	}
 
+	if opts.Recursive {
+		var fetched int // by the pins before this one
+		for _, dagnode := range dagnodes {
+			ctx, cancel := context.WithTimeout(ctx, time.Minute)
+			defer cancel()
+			c, err := fetchDAG(ctx, n.DAG, dagnode, hold, func(c int) {
+				if opts.Progress != nil {
+					opts.Progress(fetched + c)
+				}
+			})
+			if err != nil {
+				return nil, fmt.Errorf("pin: %s", err)
+			}
+			fetched += c
+		}
+	}
+
+	unlock := n.Blockstore.PinLock()
+	defer unlock()
+
 	var out []u.Key
 	for _, dagnode := range dagnodes {
-		k, err := dagnode.Key()
+		k, err := storeResolved(n, dagnode)
 		if err != nil {
 			return nil, err
 		}
 
 		ctx, cancel := context.WithTimeout(ctx, time.Minute)
 		defer cancel()
-		err = n.Pinning.Pin(ctx, dagnode, recursive)
+		err = n.Pinning.Pin(ctx, dagnode, opts.Recursive)
 		if err != nil {
 			return nil, fmt.Errorf("pin: %s", err)
 		}
+		if !opts.Meta.IsEmpty() {
+			if err := n.Pinning.SetMetadata(k, opts.Meta); err != nil {
+				return nil, fmt.Errorf("pin: %s", err)
+			}
+		}
 		out = append(out, k)
 	}
 
@@ -62,6 +118,26 @@ func Pin(n *core.IpfsNode, paths []string, recursive bool) ([]u.Key, error) {
 	return out, nil
 }
 
+// storeResolved adds nd back to the blockstore if it is missing, and
+// returns its key. A resolved node is only held once its key is known, so
+// gc may remove it in between. The caller must hold the pin lock.
+func storeResolved(n *core.IpfsNode, nd *merkledag.Node) (u.Key, error) {
+	k, err := nd.Key()
+	if err != nil {
+		return "", err
+	}
+	has, err := n.Blockstore.Has(k)
+	if err != nil {
+		return "", err
+	}
+	if !has {
+		if _, err := n.DAG.Add(nd); err != nil {
+			return "", err
+		}
+	}
+	return k, nil
+}
+
 func Unpin(n *core.IpfsNode, paths []string, recursive bool) ([]u.Key, error) {
 	// TODO(cryptix): do we want a ctx as first param for (Un)Pin() as well, just like core.Resolve?
 	ctx := n.Context()
@@ -94,3 +170,102 @@ func Unpin(n *core.IpfsNode, paths []string, recursive bool) ([]u.Key, error) {
 	}
 	return unpinned, nil
 }
+
+// Update moves the recursive pin on the object at from to the object at
+// to, see pin.Pinner.Update. The pin on from is kept unless unpin is set.
+func Update(n *core.IpfsNode, from, to string, unpin bool) (u.Key, u.Key, error) {
+	ctx := n.Context()
+
+	// the new object is fetched and held like in PinWithOptions
+	var held []u.Key
+	hold := func(keys []u.Key) {
+		n.Held.Hold(keys)
+		held = append(held, keys...)
+	}
+	defer func() { n.Held.Release(held) }()
+
+	fromNode, err := core.Resolve(ctx, n, path.Path(from))
+	if err != nil {
+		return "", "", fmt.Errorf("pin: %s", err)
+	}
+	toNode, err := core.Resolve(ctx, n, path.Path(to))
+	if err != nil {
+		return "", "", fmt.Errorf("pin: %s", err)
+	}
+	fromKey, err := fromNode.Key()
+	if err != nil {
+		return "", "", err
+	}
+	toKey, err := toNode.Key()
+	if err != nil {
+		return "", "", err
+	}
+	hold([]u.Key{toKey})
+
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	if _, err := fetchDAG(ctx, n.DAG, toNode, hold, func(int) {}); err != nil {
+		return "", "", fmt.Errorf("pin: %s", err)
+	}
+
+	unlock := n.Blockstore.PinLock()
+	defer unlock()
+	if _, err := storeResolved(n, toNode); err != nil {
+		return "", "", err
+	}
+	if err := n.Pinning.Update(ctx, fromKey, toKey, unpin); err != nil {
+		return "", "", fmt.Errorf("pin: %s", err)
+	}
+
+	if err := n.Pinning.Flush(); err != nil {
+		return "", "", err
+	}
+	return fromKey, toKey, nil
+}
+
+// expirySweepPeriod is how often the daemon looks for expired pins.
+const expirySweepPeriod = time.Minute
+
+// UnpinExpired removes the pins whose expiry has passed, and returns their
+// keys. A pin that cannot be removed is logged and left for the next sweep.
+func UnpinExpired(n *core.IpfsNode) ([]u.Key, error) {
+	expired := n.Pinning.Expired(time.Now())
+	if len(expired) == 0 {
+		return nil, nil
+	}
+
+	var unpinned []u.Key
+	for _, k := range expired {
+		ctx, cancel := context.WithTimeout(n.Context(), time.Minute)
+		err := n.Pinning.Unpin(ctx, k, true)
+		cancel()
+		if err != nil {
+			log.Errorf("removing expired pin %s: %s", k, err)
+			continue
+		}
+		log.Infof("removed expired pin %s", k)
+		unpinned = append(unpinned, k)
+	}
+
+	if err := n.Pinning.Flush(); err != nil {
+		return nil, err
+	}
+	return unpinned, nil
+}
+
+// PeriodicUnpinExpired calls UnpinExpired every expirySweepPeriod until ctx
+// is done.
+func PeriodicUnpinExpired(n *core.IpfsNode, ctx context.Context) {
+	ticker := time.NewTicker(expirySweepPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := UnpinExpired(n); err != nil {
+				log.Error(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package corerepo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+	u "github.com/ipfs/go-ipfs/util"
+)
+
+func TestPinFetchesWithoutPinLock(t *testing.T) {
+	n := testNode(t)
+	root := testDAG(t, n)
+	rk, _ := root.Key()
+
+	opts := PinOptions{Recursive: true, Progress: func(fetched int) {
+		if fetched != 3 {
+			return
+		}
+		// gc waits for the pin lock, which the pin must not hold yet
+		done := make(chan error, 1)
+		go func() { done <- GarbageCollect(n, context.Background()) }()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Error("gc waited for the pin lock while fetching")
+		}
+	}}
+	if _, err := PinWithOptions(n, []string{"/ipfs/" + rk.B58String()}, opts); err != nil {
+		t.Fatal(err)
+	}
+	if !n.Pinning.IsPinned(rk) {
+		t.Fatal("expected the object to be pinned")
+	}
+	keys := []u.Key{rk}
+	for _, l := range root.Links {
+		keys = append(keys, u.Key(l.Hash))
+	}
+	for _, k := range keys {
+		if has, _ := n.Blockstore.Has(k); !has {
+			t.Fatalf("gc removed %s while it was held by the pin", k)
+		}
+	}
+}
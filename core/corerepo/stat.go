@@ -0,0 +1,83 @@
+package corerepo
+
+import (
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+	"github.com/ipfs/go-ipfs/core"
+	fsrepo "github.com/ipfs/go-ipfs/repo/fsrepo"
+)
+
+// Stat describes the storage used by the repo. Entries with a Backend name
+// cover a single datastore, the final entry without one covers the whole
+// repo, where NumObjects is the number of blocks stored. A block held by
+// both the blockstore and the filestore counts twice.
+type Stat struct {
+	Backend    string `json:",omitempty"`
+	NumObjects uint64
+	RepoSize   uint64
+	RepoPath   string
+	Version    string `json:",omitempty"`
+	// Caches describes the blockstore caches in use, in the summary.
+	Caches []CacheStat `json:",omitempty"`
+}
+
+// CacheStat describes how a blockstore cache was used since the node
+// started.
+type CacheStat struct {
+	Name   string
+	Hits   uint64
+	Misses uint64
+	// Size is the number of bytes of block data held in memory, for the
+	// caches that hold any.
+	Size int `json:",omitempty"`
+}
+
+func cacheStats(n *core.IpfsNode) []CacheStat {
+	var out []CacheStat
+	if bc := n.BloomCache; bc != nil {
+		out = append(out, CacheStat{Name: "bloom filter", Hits: bc.Hits(), Misses: bc.Misses()})
+	}
+	if rc := n.ReadCache; rc != nil {
+		out = append(out, CacheStat{Name: "read cache", Hits: rc.Hits(), Misses: rc.Misses(), Size: rc.Size()})
+	}
+	return out
+}
+
+// RepoStatAsync sends one Stat per datastore of the node's repo, followed
+// by a summary for the repo rooted at repoPath.
+func RepoStatAsync(n *core.IpfsNode, ctx context.Context, repoPath string) (<-chan *Stat, error) {
+	dstats, err := n.Repo.DatastoreStats()
+	if err != nil {
+		return nil, err
+	}
+
+	output := make(chan *Stat)
+	go func() {
+		defer close(output)
+
+		total := &Stat{
+			RepoPath: repoPath,
+			Version:  "fs-repo@" + fsrepo.RepoVersion,
+			Caches:   cacheStats(n),
+		}
+		for _, d := range dstats {
+			total.RepoSize += d.Size
+			total.NumObjects += d.NumBlocks
+			select {
+			case output <- &Stat{
+				Backend:    d.Name,
+				NumObjects: d.NumObjects,
+				RepoSize:   d.Size,
+				RepoPath:   d.Path,
+			}:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		select {
+		case output <- total:
+		case <-ctx.Done():
+		}
+	}()
+	return output, nil
+}
@@ -0,0 +1,208 @@
+package corerepo
+
+import (
+	"encoding/json"
+
+	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+	"github.com/ipfs/go-ipfs/blocks"
+	bstore "github.com/ipfs/go-ipfs/blocks/blockstore"
+	"github.com/ipfs/go-ipfs/core"
+	"github.com/ipfs/go-ipfs/filestore"
+	"github.com/ipfs/go-ipfs/merkledag"
+	u "github.com/ipfs/go-ipfs/util"
+)
+
+// QuarantinePrefix namespaces blocks that failed verification. They are kept
+// in the repo datastore, outside of the blockstore, so that they can be
+// inspected later while the block itself gets refetched from the network.
+var QuarantinePrefix = ds.NewKey("/quarantine")
+
+const (
+	BlockOk      = "ok"
+	BlockCorrupt = "corrupt"
+	BlockError   = "error"
+	BlockMissing = "missing"
+)
+
+// BlockVerified is the result of checking a single stored block.
+type BlockVerified struct {
+	Key    u.Key
+	Status string
+	Error  string `json:",omitempty"`
+}
+
+// storedBlocks returns a blockstore reading the blocks of the node from
+// the repo, rather than the copies the node's blockstore may have cached.
+func storedBlocks(n *core.IpfsNode) bstore.Blockstore {
+	bs := bstore.NewBlockstore(n.Repo.Datastore())
+	if n.Filestore == nil {
+		return bs
+	}
+	return filestore.Blockstore(bs, n.Filestore)
+}
+
+// VerifyAsync reads every block in the repo, which checks it against its
+// key. Blocks whose data does not match are moved to QuarantinePrefix.
+func VerifyAsync(n *core.IpfsNode, ctx context.Context) (<-chan *BlockVerified, error) {
+	bs := storedBlocks(n)
+	keychan, err := bs.AllKeysChan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	output := make(chan *BlockVerified)
+	go func() {
+		defer close(output)
+		for {
+			select {
+			case k, ok := <-keychan:
+				if !ok {
+					return
+				}
+				res := verifyBlock(n, bs, k)
+				select {
+				case output <- res:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return output, nil
+}
+
+func verifyBlock(n *core.IpfsNode, bs bstore.Blockstore, k u.Key) *BlockVerified {
+	res := &BlockVerified{Key: k}
+
+	// the blockstore checks the data against k, and so does the filestore
+	// with the data it reads back from files
+	_, err := bs.Get(k)
+	if err == nil {
+		res.Status = BlockOk
+		return res
+	}
+	if !isCorrupt(err) {
+		res.Status = BlockError
+		res.Error = err.Error()
+		return res
+	}
+
+	res.Status = BlockCorrupt
+	if err := quarantine(n, k); err != nil {
+		log.Debugf("Error quarantining corrupt block %s: %s", k, err)
+		res.Error = err.Error()
+	}
+	return res
+}
+
+// isCorrupt tells whether err from reading a block means the data stored
+// for it is not the data of the block. Files of the filestore that are
+// missing or unreadable are not, they may come back.
+func isCorrupt(err error) bool {
+	if cerr, ok := err.(*filestore.ChangedError); ok {
+		return cerr.WrongHash()
+	}
+	return err == blocks.ErrWrongHash
+}
+
+// PinVerified is the result of checking the DAG of a recursive pin.
+type PinVerified struct {
+	Key u.Key
+	Ok  bool
+	// BadNodes lists the nodes of the DAG that are missing, corrupt or
+	// could not be read.
+	BadNodes []*BlockVerified `json:",omitempty"`
+}
+
+// VerifyPinsAsync walks the DAG of every recursive pin using only the
+// blocks stored in the repo, and sends the result for each pin over the returned
+// channel. The nodes below a bad node are not checked. Subtrees shared by
+// several pins are only read once.
+func VerifyPinsAsync(n *core.IpfsNode, ctx context.Context) (<-chan *PinVerified, error) {
+	roots := n.Pinning.RecursiveKeys()
+
+	bs := storedBlocks(n)
+	output := make(chan *PinVerified)
+	go func() {
+		defer close(output)
+		checked := make(map[u.Key][]*BlockVerified)
+		for _, k := range roots {
+			res := &PinVerified{Key: k}
+			seen := make(map[u.Key]struct{})
+			for _, b := range verifyDAG(ctx, bs, k, checked) {
+				if _, ok := seen[b.Key]; !ok {
+					seen[b.Key] = struct{}{}
+					res.BadNodes = append(res.BadNodes, b)
+				}
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			res.Ok = len(res.BadNodes) == 0
+			select {
+			case output <- res:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return output, nil
+}
+
+// verifyDAG returns the bad nodes of the DAG below k, k included. checked
+// holds them for the nodes already visited.
+func verifyDAG(ctx context.Context, bs bstore.Blockstore, k u.Key, checked map[u.Key][]*BlockVerified) []*BlockVerified {
+	if bad, ok := checked[k]; ok {
+		return bad
+	}
+
+	var bad []*BlockVerified
+	b, err := bs.Get(k)
+	switch {
+	case err == bstore.ErrNotFound:
+		bad = append(bad, &BlockVerified{Key: k, Status: BlockMissing})
+	case isCorrupt(err):
+		bad = append(bad, &BlockVerified{Key: k, Status: BlockCorrupt})
+	case err != nil:
+		bad = append(bad, &BlockVerified{Key: k, Status: BlockError, Error: err.Error()})
+	default:
+		nd, err := merkledag.Decoded(b.Data)
+		if err != nil {
+			bad = append(bad, &BlockVerified{Key: k, Status: BlockError, Error: err.Error()})
+			break
+		}
+		for _, l := range nd.Links {
+			if ctx.Err() != nil {
+				return nil
+			}
+			bad = append(bad, verifyDAG(ctx, bs, u.Key(l.Hash), checked)...)
+		}
+	}
+	checked[k] = bad
+	return bad
+}
+
+// quarantine moves what the repo stores for k to QuarantinePrefix and
+// removes the block from the blockstore, so the next request for k goes out
+// to the exchange. For a block of the filestore, that is the reference to
+// the file its data was read from.
+func quarantine(n *core.IpfsNode, k u.Key) error {
+	data, err := n.Repo.Datastore().Get(bstore.BlockPrefix.Child(k.DsKey()))
+	if err == ds.ErrNotFound && n.Filestore != nil {
+		var ref *filestore.DataRef
+		ref, err = n.Filestore.Ref(k)
+		if err == nil {
+			data, err = json.Marshal(ref)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if err := n.Repo.Datastore().Put(QuarantinePrefix.Child(k.DsKey()), data); err != nil {
+		return err
+	}
+	return n.Blockstore.DeleteBlock(k)
+}
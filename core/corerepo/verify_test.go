@@ -0,0 +1,156 @@
+package corerepo
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+	"github.com/ipfs/go-ipfs/blocks"
+	bstore "github.com/ipfs/go-ipfs/blocks/blockstore"
+	"github.com/ipfs/go-ipfs/filestore"
+	"github.com/ipfs/go-ipfs/merkledag"
+	"github.com/ipfs/go-ipfs/repo/config"
+	ftpb "github.com/ipfs/go-ipfs/unixfs/pb"
+	u "github.com/ipfs/go-ipfs/util"
+)
+
+func TestVerifyPins(t *testing.T) {
+	ctx := context.Background()
+	n := testNode(t)
+
+	// root{a, b{a}}, with a removed and b corrupted, and a complete pin
+	root := testDAG(t, n)
+	a, err := root.Links[0].GetNode(ctx, n.DAG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := root.Links[1].GetNode(ctx, n.DAG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	good := &merkledag.Node{Data: []byte("good")}
+	if _, err := n.DAG.Add(good); err != nil {
+		t.Fatal(err)
+	}
+	for _, nd := range []*merkledag.Node{root, good} {
+		if err := n.Pinning.Pin(ctx, nd, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ak, _ := a.Key()
+	bk, _ := b.Key()
+	if err := n.Blockstore.DeleteBlock(ak); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Repo.Datastore().Put(bstore.BlockPrefix.Child(bk.DsKey()), []byte("corrupt")); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := VerifyPinsAsync(n, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootKey, _ := root.Key()
+	goodKey, _ := good.Key()
+	got := make(map[u.Key]*PinVerified)
+	for res := range results {
+		got[res.Key] = res
+	}
+	if len(got) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(got))
+	}
+	if !got[goodKey].Ok {
+		t.Fatal("complete pin reported broken:", got[goodKey].BadNodes)
+	}
+
+	broken := got[rootKey]
+	if broken.Ok || len(broken.BadNodes) != 2 {
+		t.Fatal("expected 2 bad nodes in the broken pin, got", broken.BadNodes)
+	}
+	status := make(map[u.Key]string)
+	for _, bad := range broken.BadNodes {
+		status[bad.Key] = bad.Status
+	}
+	if status[ak] != BlockMissing || status[bk] != BlockCorrupt {
+		t.Fatal("unexpected bad nodes:", status)
+	}
+}
+
+func TestVerifyQuarantinesFilestoreBlocks(t *testing.T) {
+	n := testNode(t)
+
+	f, err := ioutil.TempFile("", "verify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	data := []byte("some file data")
+	if _, err := f.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	enc, err := filestore.LeafBlock(data, int32(ftpb.Data_File))
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := u.Key(u.Hash(enc))
+	ref := &filestore.DataRef{Path: f.Name(), Size: uint64(len(data)), Type: int32(ftpb.Data_File)}
+	if err := n.Filestore.Put(k, ref); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(f.Name(), []byte("Some file data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := VerifyAsync(n, context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []*BlockVerified
+	for res := range results {
+		got = append(got, res)
+	}
+	if len(got) != 1 || got[0].Key != k || got[0].Status != BlockCorrupt || got[0].Error != "" {
+		t.Fatalf("expected %s to be quarantined, got %v", k, got)
+	}
+	if has, _ := n.Blockstore.Has(k); has {
+		t.Fatal("quarantined block still in the blockstore")
+	}
+	if has, _ := n.Repo.Datastore().Has(QuarantinePrefix.Child(k.DsKey())); !has {
+		t.Fatal("block reference not quarantined")
+	}
+}
+
+func TestVerifyReadsPastTheCache(t *testing.T) {
+	n := testNodeWith(t, config.Datastore{ReadCacheSize: 1 << 20})
+
+	b := blocks.NewBlock([]byte("cached"))
+	if err := n.Blockstore.Put(b); err != nil {
+		t.Fatal(err)
+	}
+	// cache it, then corrupt it on disk
+	if _, err := n.Blockstore.Get(b.Key()); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Repo.Datastore().Put(bstore.BlockPrefix.Child(b.Key().DsKey()), []byte("corrupt")); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := VerifyAsync(n, context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []*BlockVerified
+	for res := range results {
+		got = append(got, res)
+	}
+	if len(got) != 1 || got[0].Status != BlockCorrupt {
+		t.Fatalf("expected the block to be found corrupt, got %v", got)
+	}
+	if _, err := n.Blockstore.Get(b.Key()); err != bstore.ErrNotFound {
+		t.Fatal("expected the quarantined block to be gone, got", err)
+	}
+}
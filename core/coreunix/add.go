@@ -3,7 +3,6 @@ package coreunix
 import (
 	"errors"
 	"io"
-	"io/ioutil"
 	"os"
 	gopath "path"
 	"time"
@@ -17,7 +16,7 @@ import (
 	merkledag "github.com/ipfs/go-ipfs/merkledag"
 	"github.com/ipfs/go-ipfs/pin"
 	"github.com/ipfs/go-ipfs/thirdparty/eventlog"
-	unixfs "github.com/ipfs/go-ipfs/unixfs"
+	uio "github.com/ipfs/go-ipfs/unixfs/io"
 )
 
 var log = eventlog.Logger("coreunix")
@@ -25,6 +24,9 @@ var log = eventlog.Logger("coreunix")
 // Add builds a merkledag from the a reader, pinning all objects to the local
 // datastore. Returns a key representing the root node.
 func Add(n *core.IpfsNode, r io.Reader) (string, error) {
+	unlock := n.Blockstore.PinLock()
+	defer unlock()
+
 	// TODO more attractive function signature importer.BuildDagFromReader
 	dagNode, err := importer.BuildDagFromReader(
 		r,
@@ -48,6 +50,9 @@ func Add(n *core.IpfsNode, r io.Reader) (string, error) {
 
 // AddR recursively adds files in |path|.
 func AddR(n *core.IpfsNode, root string) (key string, err error) {
+	unlock := n.Blockstore.PinLock()
+	defer unlock()
+
 	f, err := os.Open(root)
 	if err != nil {
 		return "", err
@@ -73,12 +78,25 @@ func AddR(n *core.IpfsNode, root string) (key string, err error) {
 // Returns the path of the added file ("<dir hash>/filename"), the DAG node of
 // the directory, and and error if any.
 func AddWrapped(n *core.IpfsNode, r io.Reader, filename string) (string, *merkledag.Node, error) {
-	file := files.NewReaderFile(filename, ioutil.NopCloser(r), nil)
-	dir := files.NewSliceFile("", []files.File{file})
-	dagnode, err := addDir(n, dir)
+	unlock := n.Blockstore.PinLock()
+	defer unlock()
+
+	file, err := add(n, r)
 	if err != nil {
 		return "", nil, err
 	}
+	dagnode, err := WrapNode(n, filename, file)
+	if err != nil {
+		return "", nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Minute)
+	defer cancel()
+	if err := n.Pinning.Pin(ctx, dagnode, true); err != nil {
+		return "", nil, err
+	}
+	if err := n.Pinning.Flush(); err != nil {
+		return "", nil, err
+	}
 	k, err := dagnode.Key()
 	if err != nil {
 		return "", nil, err
@@ -86,6 +104,26 @@ func AddWrapped(n *core.IpfsNode, r io.Reader, filename string) (string, *merkle
 	return gopath.Join(k.String(), filename), dagnode, nil
 }
 
+// WrapNode adds a directory object holding dagnode under the base name of
+// filename, hashed like dagnode, and returns it. Unlike AddWrapped it does
+// not pin the directory or take the pin lock, which callers that pin it
+// afterwards must already hold.
+func WrapNode(n *core.IpfsNode, filename string, dagnode *merkledag.Node) (*merkledag.Node, error) {
+	tree := uio.NewDirectory(n.DAG)
+	tree.SetHashFunc(dagnode.HashFunc())
+	if err := tree.AddNode(n.Context(), gopath.Base(filename), dagnode); err != nil {
+		return nil, err
+	}
+	nd, err := tree.GetNode()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := n.DAG.Add(nd); err != nil {
+		return nil, err
+	}
+	return nd, nil
+}
+
 func add(n *core.IpfsNode, reader io.Reader) (*merkledag.Node, error) {
 	mp, ok := n.Pinning.(pin.ManualPinner)
 	if !ok {
@@ -134,7 +172,8 @@ func addFile(n *core.IpfsNode, file files.File) (*merkledag.Node, error) {
 
 func addDir(n *core.IpfsNode, dir files.File) (*merkledag.Node, error) {
 
-	tree := &merkledag.Node{Data: unixfs.FolderPBData()}
+	tree := uio.NewDirectory(n.DAG)
+	tree.SetShardThreshold(n.Repo.Config().Unixfs.ShardThreshold())
 
 Loop:
 	for {
@@ -153,15 +192,19 @@ Loop:
 
 		_, name := gopath.Split(file.FileName())
 
-		err = tree.AddNodeLink(name, node)
+		err = tree.AddNode(context.TODO(), name, node)
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	err := addNode(n, tree)
+	nd, err := tree.GetNode()
+	if err != nil {
+		return nil, err
+	}
+	err = addNode(n, nd)
 	if err != nil {
 		return nil, err
 	}
-	return tree, nil
+	return nd, nil
 }
@@ -1,12 +1,18 @@
 package coreunix
 
 import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
 	"os"
 	"path"
 	"testing"
+	"time"
 
 	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
 	"github.com/ipfs/go-ipfs/core"
+	"github.com/ipfs/go-ipfs/core/corerepo"
 	"github.com/ipfs/go-ipfs/repo"
 	"github.com/ipfs/go-ipfs/repo/config"
 	"github.com/ipfs/go-ipfs/util/testutil"
@@ -35,3 +41,79 @@ func TestAddRecursive(t *testing.T) {
 		t.Fatal("keys do not match")
 	}
 }
+
+func TestAddGCLive(t *testing.T) {
+	r := &repo.Mock{
+		C: config.Config{
+			Identity: config.Identity{
+				PeerID: "Qmfoo", // required by offline node
+			},
+		},
+		D: testutil.ThreadSafeCloserMapDatastore(),
+	}
+	node, err := core.NewIPFSNode(context.Background(), core.Offline(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := make([]byte, 1024*1024)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	piper, pipew := io.Pipe()
+	addDone := make(chan string)
+	go func() {
+		k, err := Add(node, piper)
+		if err != nil {
+			t.Error(err)
+		}
+		addDone <- k
+	}()
+
+	// write the first half, so the add has stored some blocks but is
+	// still waiting for more data.
+	if _, err := pipew.Write(data[:len(data)/2]); err != nil {
+		t.Fatal(err)
+	}
+
+	gcDone := make(chan struct{})
+	go func() {
+		defer close(gcDone)
+		gcout, err := corerepo.GarbageCollectAsync(node, context.Background())
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		for _ = range gcout {
+		}
+	}()
+
+	select {
+	case <-gcDone:
+		t.Fatal("gc ran while an add was in progress")
+	case <-time.After(time.Millisecond * 100):
+	}
+
+	if _, err := pipew.Write(data[len(data)/2:]); err != nil {
+		t.Fatal(err)
+	}
+	pipew.Close()
+
+	k := <-addDone
+	select {
+	case <-gcDone:
+	case <-time.After(time.Second * 5):
+		t.Fatal("gc did not run after the add finished")
+	}
+
+	rd, err := Cat(node, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := ioutil.ReadAll(rd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, data) {
+		t.Fatal("added data does not match after gc")
+	}
+}
@@ -65,6 +65,7 @@ func NewMockNode() (*IpfsNode, error) {
 	}
 
 	nd.DAG = mdag.NewDAGService(bserv)
+	nd.LocalDAG = nd.DAG
 
 	nd.Pinning = pin.NewPinner(nd.Repo.Datastore(), nd.DAG)
 
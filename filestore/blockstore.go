@@ -0,0 +1,113 @@
+package filestore
+
+import (
+	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+	"github.com/ipfs/go-ipfs/blocks"
+	bstore "github.com/ipfs/go-ipfs/blocks/blockstore"
+	u "github.com/ipfs/go-ipfs/util"
+)
+
+// Blockstore returns a blockstore serving the blocks of fs along with the
+// blocks of bs. New blocks are written to bs.
+func Blockstore(bs bstore.GCBlockstore, fs *Filestore) bstore.GCBlockstore {
+	return &blockstore{blockstore: bs, fs: fs}
+}
+
+type blockstore struct {
+	blockstore bstore.GCBlockstore
+	fs         *Filestore
+}
+
+func (b *blockstore) DeleteBlock(k u.Key) error {
+	had, err := b.fs.Has(k)
+	if err != nil {
+		return err
+	}
+	if had {
+		if err := b.fs.Delete(k); err != nil {
+			return err
+		}
+	}
+
+	err = b.blockstore.DeleteBlock(k)
+	if had && err == ds.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+func (b *blockstore) Has(k u.Key) (bool, error) {
+	has, err := b.blockstore.Has(k)
+	if err != nil || has {
+		return has, err
+	}
+	return b.fs.Has(k)
+}
+
+func (b *blockstore) Get(k u.Key) (*blocks.Block, error) {
+	blk, err := b.blockstore.Get(k)
+	if err != bstore.ErrNotFound {
+		return blk, err
+	}
+
+	blk, err = b.fs.Get(k)
+	if err == ErrNotFound {
+		return nil, bstore.ErrNotFound
+	}
+	return blk, err
+}
+
+func (b *blockstore) Put(blk *blocks.Block) error {
+	return b.blockstore.Put(blk)
+}
+
+func (b *blockstore) PutMany(blks []*blocks.Block) error {
+	return b.blockstore.PutMany(blks)
+}
+
+// AllKeysChan sends the keys of the blockstore, then the keys only found
+// in the filestore.
+func (b *blockstore) AllKeysChan(ctx context.Context) (<-chan u.Key, error) {
+	keys, err := b.blockstore.AllKeysChan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan u.Key)
+	go func() {
+		defer close(out)
+		for k := range keys {
+			select {
+			case out <- k:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		entries, err := b.fs.ListAsync(ctx)
+		if err != nil {
+			log.Debug("filestore.AllKeysChan got err:", err)
+			return
+		}
+		for e := range entries {
+			if has, _ := b.blockstore.Has(e.Key); has {
+				continue
+			}
+			select {
+			case out <- e.Key:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+func (b *blockstore) GCLock() func() {
+	return b.blockstore.GCLock()
+}
+
+func (b *blockstore) PinLock() func() {
+	return b.blockstore.PinLock()
+}
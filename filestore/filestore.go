@@ -0,0 +1,265 @@
+// package filestore keeps track of blocks whose data lives in files outside
+// the repo, as added by 'ipfs add --nocopy'. Only the leaves of a file are
+// kept this way: the filestore records where their data is, and rebuilds
+// the blocks from the file when they are read.
+package filestore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
+	dsns "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/namespace"
+	dsq "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/query"
+	mh "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-multihash"
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+	blocks "github.com/ipfs/go-ipfs/blocks"
+	dag "github.com/ipfs/go-ipfs/merkledag"
+	ft "github.com/ipfs/go-ipfs/unixfs"
+	ftpb "github.com/ipfs/go-ipfs/unixfs/pb"
+	u "github.com/ipfs/go-ipfs/util"
+)
+
+var log = u.Logger("filestore")
+
+// Prefix namespaces the filestore in the repo datastore.
+var Prefix = ds.NewKey("filestore")
+
+var ErrNotFound = errors.New("filestore: block not found")
+
+// DataRef locates the data of a leaf block in a file.
+type DataRef struct {
+	Path   string // absolute
+	Offset uint64
+	Size   uint64
+	Type   int32 // unixfs type of the leaf node
+}
+
+// ChangedError is returned when the data of a block no longer matches the
+// block, or can not be read any more.
+type ChangedError struct {
+	Key    u.Key
+	Ref    *DataRef
+	Reason string
+}
+
+func (e *ChangedError) Error() string {
+	return fmt.Sprintf("filestore: block %s: %s: %s", e.Key, e.Ref.Path, e.Reason)
+}
+
+const reasonWrongHash = "data does not match the block hash"
+
+// WrongHash tells whether the file could be read, but holds other data
+// than the block was made of.
+func (e *ChangedError) WrongHash() bool {
+	return e.Reason == reasonWrongHash
+}
+
+// Filestore maps block keys to DataRefs.
+type Filestore struct {
+	datastore ds.Datastore
+}
+
+// New returns a filestore keeping its references in d.
+func New(d ds.Datastore) *Filestore {
+	return &Filestore{datastore: dsns.Wrap(d, Prefix)}
+}
+
+// Put records that the block k is the leaf holding the data at ref.
+func (f *Filestore) Put(k u.Key, ref *DataRef) error {
+	b, err := json.Marshal(ref)
+	if err != nil {
+		return err
+	}
+	return f.datastore.Put(k.DsKey(), b)
+}
+
+// Ref returns where the data of block k lives.
+func (f *Filestore) Ref(k u.Key) (*DataRef, error) {
+	v, err := f.datastore.Get(k.DsKey())
+	if err == ds.ErrNotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	b, ok := v.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("filestore: invalid entry for %s", k)
+	}
+	return decodeRef(b)
+}
+
+func decodeRef(b []byte) (*DataRef, error) {
+	ref := new(DataRef)
+	if err := json.Unmarshal(b, ref); err != nil {
+		return nil, fmt.Errorf("filestore: invalid entry: %s", err)
+	}
+	return ref, nil
+}
+
+func (f *Filestore) Has(k u.Key) (bool, error) {
+	return f.datastore.Has(k.DsKey())
+}
+
+func (f *Filestore) Delete(k u.Key) error {
+	return f.datastore.Delete(k.DsKey())
+}
+
+// Get reads the data of block k back from its file, and rebuilds the
+// block. It returns a *ChangedError if the file no longer holds the data
+// the block was made of.
+func (f *Filestore) Get(k u.Key) (*blocks.Block, error) {
+	ref, err := f.Ref(k)
+	if err != nil {
+		return nil, err
+	}
+	b, err := readBlock(k, ref)
+	if cerr, ok := err.(*ChangedError); ok {
+		log.Errorf("%s", cerr)
+	}
+	return b, err
+}
+
+// readBlock rebuilds block k from the file ref points at.
+func readBlock(k u.Key, ref *DataRef) (*blocks.Block, error) {
+	changed := func(reason string) error {
+		return &ChangedError{Key: k, Ref: ref, Reason: reason}
+	}
+
+	fi, err := os.Open(ref.Path)
+	if os.IsNotExist(err) {
+		return nil, changed("file is missing")
+	}
+	if err != nil {
+		return nil, changed(err.Error())
+	}
+	defer fi.Close()
+
+	data := make([]byte, ref.Size)
+	_, err = fi.ReadAt(data, int64(ref.Offset))
+	if err == io.EOF {
+		return nil, changed("file is too short")
+	}
+	if err != nil {
+		return nil, changed(err.Error())
+	}
+
+	enc, err := LeafBlock(data, ref.Type)
+	if err != nil {
+		return nil, err
+	}
+	dh, err := mh.Decode([]byte(k))
+	if err != nil {
+		return nil, err
+	}
+	sum, err := u.Sum(enc, dh.Code, dh.Length)
+	if err != nil {
+		return nil, err
+	}
+	if u.Key(sum) != k {
+		return nil, changed(reasonWrongHash)
+	}
+	return blocks.NewBlockWithHash(enc, sum)
+}
+
+// LeafBlock returns the encoded leaf node of unixfs type typ holding data,
+// as the importer builds it.
+func LeafBlock(data []byte, typ int32) ([]byte, error) {
+	ufs := &ft.FSNode{Type: ftpb.Data_DataType(typ), Data: data}
+	pbdata, err := ufs.GetBytes()
+	if err != nil {
+		return nil, err
+	}
+	nd := &dag.Node{Data: pbdata}
+	return nd.Encoded(false)
+}
+
+// Entry is a block of the filestore, along with the result of checking
+// its data when it comes from Verify.
+type Entry struct {
+	Key u.Key
+	DataRef
+	Status string `json:",omitempty"`
+	Error  string `json:",omitempty"`
+}
+
+// Status of the entries returned by Verify.
+const (
+	StatusOk      = "ok"
+	StatusChanged = "changed"
+	StatusError   = "error"
+)
+
+// ListAsync sends every entry of the filestore on the returned channel.
+func (f *Filestore) ListAsync(ctx context.Context) (<-chan *Entry, error) {
+	// datastore/namespace does *NOT* fix up Query.Prefix
+	res, err := f.datastore.Query(dsq.Query{Prefix: Prefix.String()})
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan *Entry)
+	go func() {
+		defer close(out)
+		defer res.Close()
+
+		for r := range res.Next() {
+			if r.Error != nil {
+				log.Debug("filestore.ListAsync got err:", r.Error)
+				return
+			}
+			b, ok := r.Value.([]byte)
+			if !ok {
+				continue
+			}
+			ref, err := decodeRef(b)
+			if err != nil {
+				log.Debug(err)
+				continue
+			}
+
+			select {
+			case out <- &Entry{Key: u.KeyFromDsKey(ds.NewKey(r.Key)), DataRef: *ref}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// VerifyAsync checks the data of every entry of the filestore, and sends
+// the entries with their status on the returned channel.
+func (f *Filestore) VerifyAsync(ctx context.Context) (<-chan *Entry, error) {
+	entries, err := f.ListAsync(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan *Entry)
+	go func() {
+		defer close(out)
+		for e := range entries {
+			e.Status = StatusOk
+			if _, err := readBlock(e.Key, &e.DataRef); err != nil {
+				e.Status = StatusError
+				if cerr, ok := err.(*ChangedError); ok {
+					e.Status = StatusChanged
+					err = errors.New(cerr.Reason)
+				}
+				e.Error = err.Error()
+			}
+
+			select {
+			case out <- e:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
@@ -0,0 +1,161 @@
+package filestore
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
+	syncds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/sync"
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+	bstore "github.com/ipfs/go-ipfs/blocks/blockstore"
+	ftpb "github.com/ipfs/go-ipfs/unixfs/pb"
+	u "github.com/ipfs/go-ipfs/util"
+)
+
+// addLeaf records the leaf holding data, found at offset in the file at
+// path, and returns its key.
+func addLeaf(t *testing.T, fs *Filestore, path string, offset int, data []byte) u.Key {
+	enc, err := LeafBlock(data, int32(ftpb.Data_File))
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := u.Key(u.Hash(enc))
+	ref := &DataRef{
+		Path:   path,
+		Offset: uint64(offset),
+		Size:   uint64(len(data)),
+		Type:   int32(ftpb.Data_File),
+	}
+	if err := fs.Put(k, ref); err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func tempFile(t *testing.T, data []byte) string {
+	f, err := ioutil.TempFile("", "filestore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
+
+func TestGetReadsFromFile(t *testing.T) {
+	data := []byte("some data, and some more data")
+	path := tempFile(t, data)
+	defer os.Remove(path)
+
+	fs := New(syncds.MutexWrap(ds.NewMapDatastore()))
+	k := addLeaf(t, fs, path, 5, data[5:15])
+
+	b, err := fs.Get(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Key() != k {
+		t.Fatal("got the wrong block")
+	}
+	enc, _ := LeafBlock(data[5:15], int32(ftpb.Data_File))
+	if !bytes.Equal(b.Data, enc) {
+		t.Fatal("block data does not match")
+	}
+}
+
+func TestGetReportsChangedFile(t *testing.T) {
+	data := []byte("some data, and some more data")
+	path := tempFile(t, data)
+	defer os.Remove(path)
+
+	fs := New(syncds.MutexWrap(ds.NewMapDatastore()))
+	k := addLeaf(t, fs, path, 0, data)
+
+	if err := ioutil.WriteFile(path, []byte("Some data, and some more data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Get(k); err == nil {
+		t.Fatal("expected an error for a changed file")
+	} else if cerr, ok := err.(*ChangedError); !ok {
+		t.Fatal("expected a ChangedError, got", err)
+	} else if !cerr.WrongHash() {
+		t.Fatal("expected the data not to match the hash")
+	}
+
+	if err := os.Truncate(path, 4); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Get(k); err == nil {
+		t.Fatal("expected an error for a truncated file")
+	} else if err.(*ChangedError).WrongHash() {
+		t.Fatal("a truncated file is not a hash mismatch")
+	}
+
+	os.Remove(path)
+	if _, err := fs.Get(k); err == nil {
+		t.Fatal("expected an error for a missing file")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	data := []byte("some data, and some more data")
+	path := tempFile(t, data)
+	defer os.Remove(path)
+
+	fs := New(syncds.MutexWrap(ds.NewMapDatastore()))
+	good := addLeaf(t, fs, path, 0, data[:10])
+	bad := addLeaf(t, fs, path, 10, []byte("not in the file"))
+
+	entries, err := fs.VerifyAsync(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := make(map[u.Key]string)
+	for e := range entries {
+		status[e.Key] = e.Status
+	}
+	if len(status) != 2 || status[good] != StatusOk || status[bad] != StatusChanged {
+		t.Fatal("unexpected verify results:", status)
+	}
+}
+
+func TestBlockstoreServesFilestore(t *testing.T) {
+	data := []byte("some data, and some more data")
+	path := tempFile(t, data)
+	defer os.Remove(path)
+
+	d := syncds.MutexWrap(ds.NewMapDatastore())
+	fs := New(d)
+	bs := Blockstore(bstore.NewBlockstore(d), fs)
+	k := addLeaf(t, fs, path, 0, data)
+
+	if has, err := bs.Has(k); err != nil || !has {
+		t.Fatal("blockstore should have the filestore block")
+	}
+	if _, err := bs.Get(k); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := bs.AllKeysChan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	for range keys {
+		n++
+	}
+	if n != 1 {
+		t.Fatalf("expected 1 key, got %d", n)
+	}
+
+	if err := bs.DeleteBlock(k); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bs.Get(k); err != bstore.ErrNotFound {
+		t.Fatal("expected ErrNotFound after delete, got", err)
+	}
+}
@@ -1,8 +1,10 @@
 package helpers
 
 import (
+	"github.com/ipfs/go-ipfs/filestore"
 	dag "github.com/ipfs/go-ipfs/merkledag"
 	"github.com/ipfs/go-ipfs/pin"
+	u "github.com/ipfs/go-ipfs/util"
 )
 
 // DagBuilderHelper wraps together a bunch of objects needed to
@@ -13,6 +15,11 @@ type DagBuilderHelper struct {
 	in       <-chan []byte
 	nextData []byte // the next item to return.
 	maxlinks int
+	batch    *dag.Batch
+	fstore   *filestore.Filestore
+	fpath    string
+	offset   uint64 // of the next item in the input
+	hashFunc int
 }
 
 type DagBuilderParams struct {
@@ -24,6 +31,15 @@ type DagBuilderParams struct {
 
 	// Pinner to use for pinning files (optionally nil)
 	Pinner pin.ManualPinner
+
+	// Multihash function to hash the nodes with (0 for the default)
+	HashFunc int
+
+	// Filestore, if set, records where the data of each leaf is in the
+	// file at FilePath, instead of storing the leaf. The input must be
+	// the contents of that file.
+	Filestore *filestore.Filestore
+	FilePath  string
 }
 
 // Generate a new DagBuilderHelper from the given params, using 'in' as a
@@ -34,6 +50,10 @@ func (dbp *DagBuilderParams) New(in <-chan []byte) *DagBuilderHelper {
 		mp:       dbp.Pinner,
 		in:       in,
 		maxlinks: dbp.Maxlinks,
+		batch:    dbp.Dagserv.Batch(),
+		fstore:   dbp.Filestore,
+		fpath:    dbp.FilePath,
+		hashFunc: dbp.HashFunc,
 	}
 }
 
@@ -75,6 +95,7 @@ func (db *DagBuilderHelper) Next() []byte {
 	db.prepareNext() // idempotent
 	d := db.nextData
 	db.nextData = nil // signal we've consumed it
+	db.offset += uint64(len(d))
 	return d
 }
 
@@ -106,6 +127,7 @@ func (db *DagBuilderHelper) FillNodeLayer(node *UnixfsNode) error {
 }
 
 func (db *DagBuilderHelper) FillNodeWithData(node *UnixfsNode) error {
+	offset := db.offset
 	data := db.Next()
 	if data == nil { // we're done!
 		return nil
@@ -116,16 +138,57 @@ func (db *DagBuilderHelper) FillNodeWithData(node *UnixfsNode) error {
 	}
 
 	node.SetData(data)
+	if db.fstore != nil {
+		node.ref = &filestore.DataRef{
+			Path:   db.fpath,
+			Offset: offset,
+			Size:   uint64(len(data)),
+		}
+	}
 	return nil
 }
 
+// put stores dn, the dag node of n, with add. Leaves of a file added
+// without copy are recorded in the filestore instead.
+func (db *DagBuilderHelper) put(n *UnixfsNode, dn *dag.Node, add func(*dag.Node) (u.Key, error)) (u.Key, error) {
+	if n.ref == nil || len(dn.Links) != 0 {
+		return add(dn)
+	}
+
+	k, err := dn.Key()
+	if err != nil {
+		return "", err
+	}
+	ref := *n.ref
+	ref.Type = int32(n.ufmt.Type)
+	return k, db.fstore.Put(k, &ref)
+}
+
+// getDagNode returns the dag node of n, hashed with the function of the
+// builder.
+func (db *DagBuilderHelper) getDagNode(n *UnixfsNode) (*dag.Node, error) {
+	dn, err := n.GetDagNode()
+	if err != nil {
+		return nil, err
+	}
+	if db.hashFunc != 0 {
+		dn.SetHashFunc(db.hashFunc)
+	}
+	return dn, nil
+}
+
 func (db *DagBuilderHelper) Add(node *UnixfsNode) (*dag.Node, error) {
-	dn, err := node.GetDagNode()
+	dn, err := db.getDagNode(node)
 	if err != nil {
 		return nil, err
 	}
 
-	key, err := db.dserv.Add(dn)
+	// the children are in the batch; store them before the root.
+	if err := db.batch.Commit(); err != nil {
+		return nil, err
+	}
+
+	key, err := db.put(node, dn, db.dserv.Add)
 	if err != nil {
 		return nil, err
 	}
@@ -141,6 +204,12 @@ func (db *DagBuilderHelper) Add(node *UnixfsNode) (*dag.Node, error) {
 	return dn, nil
 }
 
+// Close stores any children still waiting in the batch. It must be called
+// when a dag is built without going through Add.
+func (db *DagBuilderHelper) Close() error {
+	return db.batch.Commit()
+}
+
 func (db *DagBuilderHelper) Maxlinks() int {
 	return db.maxlinks
 }
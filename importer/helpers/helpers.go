@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+	"github.com/ipfs/go-ipfs/filestore"
 	chunk "github.com/ipfs/go-ipfs/importer/chunk"
 	dag "github.com/ipfs/go-ipfs/merkledag"
 	"github.com/ipfs/go-ipfs/pin"
@@ -42,6 +43,7 @@ var ErrSizeLimitExceeded = fmt.Errorf("object size limit exceeded")
 type UnixfsNode struct {
 	node *dag.Node
 	ufmt *ft.FSNode
+	ref  *filestore.DataRef // where the data is, for leaves added without copy
 }
 
 // NewUnixfsNode creates a new Unixfs node to represent a file
@@ -95,7 +97,7 @@ func (n *UnixfsNode) GetChild(i int, ds dag.DAGService) (*UnixfsNode, error) {
 func (n *UnixfsNode) AddChild(child *UnixfsNode, db *DagBuilderHelper) error {
 	n.ufmt.AddBlockSize(child.ufmt.FileSize())
 
-	childnode, err := child.GetDagNode()
+	childnode, err := db.getDagNode(child)
 	if err != nil {
 		return err
 	}
@@ -107,7 +109,7 @@ func (n *UnixfsNode) AddChild(child *UnixfsNode, db *DagBuilderHelper) error {
 		return err
 	}
 
-	childkey, err := db.dserv.Add(childnode)
+	childkey, err := db.put(child, childnode, db.batch.Add)
 	if err != nil {
 		return err
 	}
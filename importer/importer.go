@@ -7,6 +7,7 @@ import (
 	"io"
 	"os"
 
+	"github.com/ipfs/go-ipfs/filestore"
 	bal "github.com/ipfs/go-ipfs/importer/balanced"
 	"github.com/ipfs/go-ipfs/importer/chunk"
 	h "github.com/ipfs/go-ipfs/importer/helpers"
@@ -52,6 +53,32 @@ func BuildDagFromReader(r io.Reader, ds dag.DAGService, mp pin.ManualPinner, spl
 	return bal.BalancedLayout(dbp.New(blkch))
 }
 
+// BuildDagFromReaderNoCopy builds a DAG like BuildDagFromReader, but records
+// the position of each leaf's data in the file at fpath in fs, instead of
+// storing the leaves. r must read the contents of fpath from the start.
+func BuildDagFromReaderNoCopy(r io.Reader, ds dag.DAGService, mp pin.ManualPinner, spl chunk.BlockSplitter, fs *filestore.Filestore, fpath string) (*dag.Node, error) {
+	return BuildDagFromReaderWithParams(r, spl, h.DagBuilderParams{
+		Dagserv:   ds,
+		Pinner:    mp,
+		Filestore: fs,
+		FilePath:  fpath,
+	})
+}
+
+// BuildDagFromReaderWithParams builds a balanced DAG from r as described
+// by dbp, which may also choose the hash function or a filestore. Maxlinks
+// defaults to h.DefaultLinksPerBlock.
+func BuildDagFromReaderWithParams(r io.Reader, spl chunk.BlockSplitter, dbp h.DagBuilderParams) (*dag.Node, error) {
+	if dbp.Maxlinks == 0 {
+		dbp.Maxlinks = h.DefaultLinksPerBlock
+	}
+
+	// Start the splitter
+	blkch := spl.Split(r)
+
+	return bal.BalancedLayout(dbp.New(blkch))
+}
+
 func BuildTrickleDagFromReader(r io.Reader, ds dag.DAGService, mp pin.ManualPinner, spl chunk.BlockSplitter) (*dag.Node, error) {
 	// Start the splitter
 	blkch := spl.Split(r)
@@ -4,10 +4,19 @@ import (
 	"bytes"
 	"io"
 	"io/ioutil"
+	"os"
 	"testing"
 
+	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
+	dssync "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/sync"
+	mh "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-multihash"
 	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+	"github.com/ipfs/go-ipfs/blocks/blockstore"
+	bsrv "github.com/ipfs/go-ipfs/blockservice"
+	"github.com/ipfs/go-ipfs/exchange/offline"
+	"github.com/ipfs/go-ipfs/filestore"
 	chunk "github.com/ipfs/go-ipfs/importer/chunk"
+	h "github.com/ipfs/go-ipfs/importer/helpers"
 	dag "github.com/ipfs/go-ipfs/merkledag"
 	mdtest "github.com/ipfs/go-ipfs/merkledag/test"
 	uio "github.com/ipfs/go-ipfs/unixfs/io"
@@ -115,3 +124,102 @@ func runReadBench(b *testing.B, nd *dag.Node, ds dag.DAGService) {
 		cancel()
 	}
 }
+
+func TestBuildDagFromReaderNoCopy(t *testing.T) {
+	buf := make([]byte, 10000)
+	u.NewTimeSeededRand().Read(buf)
+	f, err := ioutil.TempFile("", "nocopy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(buf); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	d := dssync.MutexWrap(ds.NewMapDatastore())
+	fs := filestore.New(d)
+	bs := filestore.Blockstore(blockstore.NewBlockstore(d), fs)
+	bserv, err := bsrv.New(bs, offline.Exchange(bs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dserv := dag.NewDAGService(bserv)
+
+	nd, err := BuildDagFromReaderNoCopy(bytes.NewReader(buf), dserv, nil, &chunk.SizeSplitter{1000}, fs, f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := fs.ListAsync(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var leaves int
+	for e := range entries {
+		if e.Path != f.Name() {
+			t.Fatal("leaf refers to the wrong file:", e.Path)
+		}
+		leaves++
+	}
+	if leaves != 10 {
+		t.Fatalf("expected 10 leaves in the filestore, got %d", leaves)
+	}
+
+	dr, err := uio.NewDagReader(context.TODO(), nd, dserv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := ioutil.ReadAll(dr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, buf) {
+		t.Fatal("bad read")
+	}
+}
+
+func TestBuildDagWithHashFunc(t *testing.T) {
+	ds := mdtest.Mock(t)
+	buf := make([]byte, 10000)
+	u.NewTimeSeededRand().Read(buf)
+
+	nd, err := BuildDagFromReaderWithParams(bytes.NewReader(buf), &chunk.SizeSplitter{1000}, h.DagBuilderParams{
+		Dagserv:  ds,
+		HashFunc: mh.BLAKE2B,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root, err := nd.Multihash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashes := []mh.Multihash{root}
+	for _, l := range nd.Links {
+		hashes = append(hashes, l.Hash)
+	}
+	for _, hash := range hashes {
+		dh, err := mh.Decode(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dh.Code != mh.BLAKE2B {
+			t.Fatalf("expected a blake2b hash, got %s", dh.Name)
+		}
+	}
+
+	dr, err := uio.NewDagReader(context.TODO(), nd, ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := ioutil.ReadAll(dr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, buf) {
+		t.Fatal("bad read")
+	}
+}
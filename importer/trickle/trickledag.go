@@ -81,6 +81,9 @@ func TrickleAppend(base *dag.Node, db *h.DagBuilderHelper) (*dag.Node, error) {
 		}
 
 		if db.Done() {
+			if err := db.Close(); err != nil {
+				return nil, err
+			}
 			return ufsn.GetDagNode()
 		}
 
@@ -115,6 +118,9 @@ func TrickleAppend(base *dag.Node, db *h.DagBuilderHelper) (*dag.Node, error) {
 		}
 	}
 
+	if err := db.Close(); err != nil {
+		return nil, err
+	}
 	return ufsn.GetDagNode()
 }
 
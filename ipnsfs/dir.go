@@ -11,6 +11,7 @@ import (
 
 	dag "github.com/ipfs/go-ipfs/merkledag"
 	ft "github.com/ipfs/go-ipfs/unixfs"
+	uio "github.com/ipfs/go-ipfs/unixfs/io"
 	ufspb "github.com/ipfs/go-ipfs/unixfs/pb"
 )
 
@@ -25,20 +26,29 @@ type Directory struct {
 	files     map[string]*File
 
 	lock sync.Mutex
+	// dir holds the entries, node is its current node
+	dir  *uio.Directory
 	node *dag.Node
 
 	name string
 }
 
-func NewDirectory(name string, node *dag.Node, parent childCloser, fs *Filesystem) *Directory {
+func NewDirectory(name string, node *dag.Node, parent childCloser, fs *Filesystem) (*Directory, error) {
+	dir, err := uio.NewDirectoryFromNode(fs.dserv, node)
+	if err != nil {
+		return nil, err
+	}
+	dir.SetShardThreshold(fs.shardThreshold)
+
 	return &Directory{
 		fs:        fs,
 		name:      name,
+		dir:       dir,
 		node:      node,
 		parent:    parent,
 		childDirs: make(map[string]*Directory),
 		files:     make(map[string]*File),
-	}
+	}, nil
 }
 
 // closeChild updates the child by the given name to the dag node 'nd'
@@ -51,16 +61,22 @@ func (d *Directory) closeChild(name string, nd *dag.Node) error {
 
 	d.lock.Lock()
 	defer d.lock.Unlock()
-	err = d.node.RemoveNodeLink(name)
-	if err != nil && err != dag.ErrNotFound {
+	err = d.dir.AddNode(context.TODO(), name, nd)
+	if err != nil {
 		return err
 	}
 
-	err = d.node.AddNodeLinkClean(name, nd)
+	return d.flush()
+}
+
+// flush updates the dag node of this directory after a change to its
+// entries, then propogates it upward
+func (d *Directory) flush() error {
+	nd, err := d.dir.GetNode()
 	if err != nil {
 		return err
 	}
-
+	d.node = nd
 	return d.parent.closeChild(d.name, d.node)
 }
 
@@ -85,7 +101,7 @@ func (d *Directory) childFile(name string) (*File, error) {
 	}
 
 	switch i.GetType() {
-	case ufspb.Data_Directory:
+	case ufspb.Data_Directory, ufspb.Data_HAMTShard:
 		return nil, ErrIsDirectory
 	case ufspb.Data_File:
 		nfi, err := NewFile(name, nd, d, d.fs)
@@ -120,8 +136,11 @@ func (d *Directory) childDir(name string) (*Directory, error) {
 	}
 
 	switch i.GetType() {
-	case ufspb.Data_Directory:
-		ndir := NewDirectory(name, nd, d, d.fs)
+	case ufspb.Data_Directory, ufspb.Data_HAMTShard:
+		ndir, err := NewDirectory(name, nd, d, d.fs)
+		if err != nil {
+			return nil, err
+		}
 		d.childDirs[name] = ndir
 		return ndir, nil
 	case ufspb.Data_File:
@@ -136,16 +155,17 @@ func (d *Directory) childDir(name string) (*Directory, error) {
 // childFromDag searches through this directories dag node for a child link
 // with the given name
 func (d *Directory) childFromDag(name string) (*dag.Node, error) {
-	for _, lnk := range d.node.Links {
-		if lnk.Name == name {
-			ctx, cancel := context.WithTimeout(context.TODO(), time.Minute)
-			defer cancel()
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Minute)
+	defer cancel()
 
-			return lnk.GetNode(ctx, d.fs.dserv)
-		}
+	lnk, err := d.dir.Find(ctx, name)
+	if err == dag.ErrNotFound {
+		return nil, os.ErrNotExist
 	}
-
-	return nil, os.ErrNotExist
+	if err != nil {
+		return nil, err
+	}
+	return lnk.GetNode(ctx, d.fs.dserv)
 }
 
 // Child returns the child of this directory by the given name
@@ -174,8 +194,14 @@ func (d *Directory) List() []string {
 	d.lock.Lock()
 	defer d.lock.Unlock()
 
+	links, err := d.dir.Links(context.TODO())
+	if err != nil {
+		log.Errorf("listing %s: %s", d.name, err)
+		return nil
+	}
+
 	var out []string
-	for _, lnk := range d.node.Links {
+	for _, lnk := range links {
 		out = append(out, lnk.Name)
 	}
 	return out
@@ -195,12 +221,12 @@ func (d *Directory) Mkdir(name string) (*Directory, error) {
 	}
 
 	ndir := &dag.Node{Data: ft.FolderPBData()}
-	err = d.node.AddNodeLinkClean(name, ndir)
+	err = d.dir.AddNode(context.TODO(), name, ndir)
 	if err != nil {
 		return nil, err
 	}
 
-	err = d.parent.closeChild(d.name, d.node)
+	err = d.flush()
 	if err != nil {
 		return nil, err
 	}
@@ -215,12 +241,12 @@ func (d *Directory) Unlink(name string) error {
 	delete(d.childDirs, name)
 	delete(d.files, name)
 
-	err := d.node.RemoveNodeLink(name)
+	err := d.dir.RemoveChild(context.TODO(), name)
 	if err != nil {
 		return err
 	}
 
-	return d.parent.closeChild(d.name, d.node)
+	return d.flush()
 }
 
 // AddChild adds the node 'nd' under this directory giving it the name 'name'
@@ -237,14 +263,18 @@ func (d *Directory) AddChild(name string, nd *dag.Node) error {
 		return errors.New("directory already has entry by that name")
 	}
 
-	err = d.node.AddNodeLinkClean(name, nd)
+	err = d.dir.AddNode(context.TODO(), name, nd)
 	if err != nil {
 		return err
 	}
 
 	switch pbn.GetType() {
-	case ft.TDirectory:
-		d.childDirs[name] = NewDirectory(name, nd, d, d.fs)
+	case ft.TDirectory, ft.THAMTShard:
+		ndir, err := NewDirectory(name, nd, d, d.fs)
+		if err != nil {
+			return err
+		}
+		d.childDirs[name] = ndir
 	case ft.TFile, ft.TMetadata, ft.TRaw:
 		nfi, err := NewFile(name, nd, d, d.fs)
 		if err != nil {
@@ -254,7 +284,7 @@ func (d *Directory) AddChild(name string, nd *dag.Node) error {
 	default:
 		return ErrInvalidChild
 	}
-	return d.parent.closeChild(d.name, d.node)
+	return d.flush()
 }
 
 func (d *Directory) GetNode() (*dag.Node, error) {
@@ -43,18 +43,24 @@ type Filesystem struct {
 
 	pins pin.Pinner
 
+	// shardThreshold is the number of entries above which a directory
+	// is sharded, never if below 1
+	shardThreshold int
+
 	roots map[string]*KeyRoot
 }
 
-// NewFilesystem instantiates an ipns filesystem using the given parameters and locally owned keys
-func NewFilesystem(ctx context.Context, ds dag.DAGService, nsys namesys.NameSystem, pins pin.Pinner, keys ...ci.PrivKey) (*Filesystem, error) {
+// NewFilesystem instantiates an ipns filesystem using the given parameters and locally owned keys.
+// Directories with more than shardThreshold entries are sharded, unless it is below 1.
+func NewFilesystem(ctx context.Context, ds dag.DAGService, nsys namesys.NameSystem, pins pin.Pinner, shardThreshold int, keys ...ci.PrivKey) (*Filesystem, error) {
 	roots := make(map[string]*KeyRoot)
 	fs := &Filesystem{
-		roots:    roots,
-		nsys:     nsys,
-		dserv:    ds,
-		pins:     pins,
-		resolver: &path.Resolver{DAG: ds},
+		roots:          roots,
+		nsys:           nsys,
+		dserv:          ds,
+		pins:           pins,
+		resolver:       &path.Resolver{DAG: ds},
+		shardThreshold: shardThreshold,
 	}
 	for _, k := range keys {
 		pkh, err := k.GetPublic().Hash()
@@ -181,8 +187,11 @@ func (fs *Filesystem) newKeyRoot(parent context.Context, k ci.PrivKey) (*KeyRoot
 	}
 
 	switch pbn.GetType() {
-	case ft.TDirectory:
-		root.val = NewDirectory(pointsTo.String(), mnode, root, fs)
+	case ft.TDirectory, ft.THAMTShard:
+		root.val, err = NewDirectory(pointsTo.String(), mnode, root, fs)
+		if err != nil {
+			return nil, err
+		}
 	case ft.TFile, ft.TMetadata, ft.TRaw:
 		fi, err := NewFile(pointsTo.String(), mnode, root, fs)
 		if err != nil {
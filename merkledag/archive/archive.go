@@ -0,0 +1,210 @@
+// Package archive reads and writes DAG archives, which carry the blocks
+// of one or more DAGs between nodes with their exact hashes.
+//
+// An archive is a stream of uvarint length prefixed fields. It starts with
+// a header:
+//
+//	"ipfs-dag-archive\n"
+//	uvarint version
+//	uvarint number of roots
+//	the multihash of each root
+//
+// followed by a (multihash, data) pair for each block, parents before
+// their children, and ends with an empty multihash, so that a truncated
+// archive is told from a complete one.
+package archive
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	mh "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-multihash"
+	"github.com/ipfs/go-ipfs/blocks"
+	u "github.com/ipfs/go-ipfs/util"
+)
+
+const (
+	magic   = "ipfs-dag-archive\n"
+	Version = 1
+)
+
+// maxFieldSize bounds the fields read from an archive, blocks are much
+// smaller.
+const maxFieldSize = 4 << 20
+
+var (
+	ErrBadArchive       = errors.New("not an ipfs dag archive")
+	ErrTruncatedArchive = errors.New("dag archive is truncated")
+)
+
+// Writer writes an archive.
+type Writer struct {
+	w   *bufio.Writer
+	buf [binary.MaxVarintLen64]byte
+}
+
+// NewWriter writes the header of an archive of the DAGs below roots to w.
+func NewWriter(w io.Writer, roots []u.Key) (*Writer, error) {
+	aw := &Writer{w: bufio.NewWriter(w)}
+	if _, err := aw.w.WriteString(magic); err != nil {
+		return nil, err
+	}
+	if err := aw.writeUvarint(Version); err != nil {
+		return nil, err
+	}
+	if err := aw.writeUvarint(uint64(len(roots))); err != nil {
+		return nil, err
+	}
+	for _, k := range roots {
+		if err := aw.writeField([]byte(k)); err != nil {
+			return nil, err
+		}
+	}
+	return aw, nil
+}
+
+// WriteBlock writes the block with key k.
+func (aw *Writer) WriteBlock(k u.Key, data []byte) error {
+	if k == "" {
+		return errors.New("archive: empty key")
+	}
+	if err := aw.writeField([]byte(k)); err != nil {
+		return err
+	}
+	return aw.writeField(data)
+}
+
+// Close ends the archive and flushes it to the underlying writer, which
+// it does not close.
+func (aw *Writer) Close() error {
+	if err := aw.writeField(nil); err != nil {
+		return err
+	}
+	return aw.w.Flush()
+}
+
+func (aw *Writer) writeUvarint(v uint64) error {
+	n := binary.PutUvarint(aw.buf[:], v)
+	_, err := aw.w.Write(aw.buf[:n])
+	return err
+}
+
+func (aw *Writer) writeField(b []byte) error {
+	if err := aw.writeUvarint(uint64(len(b))); err != nil {
+		return err
+	}
+	_, err := aw.w.Write(b)
+	return err
+}
+
+// Reader reads an archive.
+type Reader struct {
+	// Roots are the keys of the roots of the archived DAGs.
+	Roots []u.Key
+
+	r    *bufio.Reader
+	done bool
+}
+
+// NewReader reads the header of the archive in r.
+func NewReader(r io.Reader) (*Reader, error) {
+	ar := &Reader{r: bufio.NewReader(r)}
+
+	m := make([]byte, len(magic))
+	if _, err := io.ReadFull(ar.r, m); err != nil || string(m) != magic {
+		return nil, ErrBadArchive
+	}
+	v, err := ar.readUvarint()
+	if err != nil {
+		return nil, err
+	}
+	if v != Version {
+		return nil, fmt.Errorf("unsupported dag archive version %d", v)
+	}
+
+	nroots, err := ar.readUvarint()
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < nroots; i++ {
+		k, err := ar.readKey()
+		if err != nil {
+			return nil, err
+		}
+		if k == "" {
+			return nil, ErrBadArchive
+		}
+		ar.Roots = append(ar.Roots, k)
+	}
+	return ar, nil
+}
+
+// Next returns the next block in the archive, once its data is checked
+// against its key, or io.EOF after the last one. A block that does not
+// match its key is an error.
+func (ar *Reader) Next() (*blocks.Block, error) {
+	if ar.done {
+		return nil, io.EOF
+	}
+
+	k, err := ar.readKey()
+	if err != nil {
+		return nil, err
+	}
+	if k == "" {
+		ar.done = true
+		return nil, io.EOF
+	}
+	data, err := ar.readField()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := blocks.VerifyHash(data, mh.Multihash(k)); err != nil {
+		return nil, fmt.Errorf("block %s: %s", k, err)
+	}
+	return &blocks.Block{Multihash: mh.Multihash(k), Data: data}, nil
+}
+
+func (ar *Reader) readUvarint() (uint64, error) {
+	v, err := binary.ReadUvarint(ar.r)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return 0, ErrTruncatedArchive
+	}
+	return v, err
+}
+
+func (ar *Reader) readField() ([]byte, error) {
+	n, err := ar.readUvarint()
+	if err != nil {
+		return nil, err
+	}
+	if n > maxFieldSize {
+		return nil, fmt.Errorf("dag archive field of %d bytes is too large", n)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(ar.r, b); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, ErrTruncatedArchive
+		}
+		return nil, err
+	}
+	return b, nil
+}
+
+func (ar *Reader) readKey() (u.Key, error) {
+	b, err := ar.readField()
+	if err != nil {
+		return "", err
+	}
+	if len(b) == 0 {
+		return "", nil
+	}
+	if _, err := mh.Cast(b); err != nil {
+		return "", fmt.Errorf("invalid key in dag archive: %s", err)
+	}
+	return u.Key(b), nil
+}
@@ -0,0 +1,147 @@
+package archive
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+
+	dag "github.com/ipfs/go-ipfs/merkledag"
+	mdtest "github.com/ipfs/go-ipfs/merkledag/test"
+	"github.com/ipfs/go-ipfs/merkledag/traverse"
+	u "github.com/ipfs/go-ipfs/util"
+)
+
+// testDAGs adds a{x, shared{x}} and b{shared}, and returns a and b.
+func testDAGs(t *testing.T, ds dag.DAGService) (*dag.Node, *dag.Node) {
+	x := &dag.Node{Data: []byte("x")}
+	shared := &dag.Node{Data: []byte("shared")}
+	a := &dag.Node{Data: []byte("a")}
+	b := &dag.Node{Data: []byte("b")}
+	for _, l := range []struct {
+		from, to *dag.Node
+		name     string
+	}{
+		{shared, x, "x"},
+		{a, x, "x"},
+		{a, shared, "shared"},
+		{b, shared, "shared"},
+	} {
+		if err := l.from.AddNodeLink(l.name, l.to); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, n := range []*dag.Node{a, b} {
+		if err := ds.AddRecursive(n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return a, b
+}
+
+func exportTestDAGs(t *testing.T) (*bytes.Buffer, []u.Key) {
+	ds := mdtest.Mock(t)
+	a, b := testDAGs(t, ds)
+	buf := new(bytes.Buffer)
+	n, err := Export(ds, buf, []*dag.Node{a, b})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 4 {
+		t.Fatalf("expected 4 blocks exported, got %d", n)
+	}
+	ak, _ := a.Key()
+	bk, _ := b.Key()
+	return buf, []u.Key{ak, bk}
+}
+
+func TestRoundTrip(t *testing.T) {
+	buf, keys := exportTestDAGs(t)
+
+	ds := mdtest.Mock(t)
+	roots, n, err := Import(ds, buf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 4 {
+		t.Fatalf("expected 4 blocks imported, got %d", n)
+	}
+	if len(roots) != 2 || roots[0] != keys[0] || roots[1] != keys[1] {
+		t.Fatal("wrong roots imported:", roots)
+	}
+
+	// the imported DAGs are complete
+	for _, k := range roots {
+		root, err := ds.Get(context.Background(), k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = traverse.Traverse(root, traverse.Options{
+			DAG:  ds,
+			Func: func(traverse.State) error { return nil },
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestImportChecksBlocks(t *testing.T) {
+	buf, _ := exportTestDAGs(t)
+	archive := buf.Bytes()
+
+	if _, _, err := Import(mdtest.Mock(t), bytes.NewReader(archive[:len(archive)-1]), nil); err != ErrTruncatedArchive {
+		t.Fatal("expected a truncated archive to fail, got", err)
+	}
+	if _, _, err := Import(mdtest.Mock(t), strings.NewReader("not an archive"), nil); err != ErrBadArchive {
+		t.Fatal("expected a bad archive to fail, got", err)
+	}
+
+	// the last byte of data, before the end of the archive
+	corrupt := append([]byte(nil), archive...)
+	corrupt[len(corrupt)-2] ^= 0xff
+	_, _, err := Import(mdtest.Mock(t), bytes.NewReader(corrupt), nil)
+	if err == nil || !strings.Contains(err.Error(), "did not match") {
+		t.Fatal("expected a corrupt block to fail, got", err)
+	}
+}
+
+func TestImportAddsNothingFromCorruptArchive(t *testing.T) {
+	// more data than a batch holds before it is committed, then a corrupt
+	// block
+	src := mdtest.Mock(t)
+	var roots []*dag.Node
+	for i := 0; i < 4; i++ {
+		nd := &dag.Node{Data: bytes.Repeat([]byte{byte(i)}, 3<<20)}
+		if i == 3 {
+			nd.Data = []byte("small")
+		}
+		if _, err := src.Add(nd); err != nil {
+			t.Fatal(err)
+		}
+		roots = append(roots, nd)
+	}
+	buf := new(bytes.Buffer)
+	if _, err := Export(src, buf, roots); err != nil {
+		t.Fatal(err)
+	}
+	corrupt := buf.Bytes()
+	corrupt[len(corrupt)-2] ^= 0xff
+
+	ds := mdtest.Mock(t)
+	var added int
+	if _, _, err := Import(ds, bytes.NewReader(corrupt), func(u.Key) { added++ }); err == nil {
+		t.Fatal("expected a corrupt block to fail")
+	}
+	if added != 0 {
+		t.Fatalf("expected no nodes to be added, got %d", added)
+	}
+	k, _ := roots[0].Key()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := ds.Get(ctx, k); err == nil {
+		t.Fatal("the first node of the corrupt archive was added")
+	}
+}
@@ -0,0 +1,149 @@
+package archive
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	mh "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-multihash"
+	dag "github.com/ipfs/go-ipfs/merkledag"
+	"github.com/ipfs/go-ipfs/merkledag/traverse"
+	u "github.com/ipfs/go-ipfs/util"
+)
+
+// Export writes an archive of the DAGs below roots to w, with each node
+// once, in depth-first order. It returns the number of blocks written.
+func Export(ds dag.DAGService, w io.Writer, roots []*dag.Node) (int, error) {
+	keys := make([]u.Key, len(roots))
+	for i, root := range roots {
+		k, err := root.Key()
+		if err != nil {
+			return 0, err
+		}
+		keys[i] = k
+	}
+
+	aw, err := NewWriter(w, keys)
+	if err != nil {
+		return 0, err
+	}
+
+	written := make(map[u.Key]struct{})
+	for _, root := range roots {
+		err := traverse.Traverse(root, traverse.Options{
+			DAG:            ds,
+			Order:          traverse.DFSPre,
+			SkipDuplicates: true,
+			Func: func(st traverse.State) error {
+				k, err := st.Node.Key()
+				if err != nil {
+					return err
+				}
+				if _, ok := written[k]; ok {
+					// below an earlier root
+					return nil
+				}
+				data, err := st.Node.Encoded(false)
+				if err != nil {
+					return err
+				}
+				written[k] = struct{}{}
+				return aw.WriteBlock(k, data)
+			},
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(written), aw.Close()
+}
+
+// Import adds the nodes in the archive in r to ds, and returns the keys of
+// the archived roots and the number of nodes added. The archive is read
+// completely and every node checked against its key before any is added,
+// so a corrupt or truncated archive adds nothing; it is staged in a
+// temporary file meanwhile. adding, unless nil, is called with the key of
+// each node before it is added.
+func Import(ds dag.DAGService, r io.Reader, adding func(u.Key)) ([]u.Key, int, error) {
+	staged, err := ioutil.TempFile("", "ipfs-dag-import-")
+	if err != nil {
+		return nil, 0, err
+	}
+	defer os.Remove(staged.Name())
+	defer staged.Close()
+
+	if _, _, err := readNodes(io.TeeReader(r, staged), func(*dag.Node) error { return nil }); err != nil {
+		return nil, 0, err
+	}
+	if _, err := staged.Seek(0, 0); err != nil {
+		return nil, 0, err
+	}
+
+	batch := ds.Batch()
+	roots, count, err := readNodes(staged, func(nd *dag.Node) error {
+		if adding != nil {
+			k, err := nd.Key()
+			if err != nil {
+				return err
+			}
+			adding(k)
+		}
+		_, err := batch.Add(nd)
+		return err
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := batch.Commit(); err != nil {
+		return nil, 0, err
+	}
+	return roots, count, nil
+}
+
+// readNodes reads the archive in r, passing every node to add once it is
+// checked against its key, and returns the keys of the archived roots and
+// the number of nodes read. It stops at the first node that does not
+// match.
+func readNodes(r io.Reader, add func(*dag.Node) error) ([]u.Key, int, error) {
+	ar, err := NewReader(r)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	count := 0
+	for {
+		b, err := ar.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+
+		nd, err := dag.Decoded(b.Data)
+		if err != nil {
+			return nil, 0, fmt.Errorf("block %s: %s", b.Key(), err)
+		}
+		dh, err := mh.Decode(b.Multihash)
+		if err != nil {
+			return nil, 0, err
+		}
+		nd.SetHashFunc(dh.Code)
+
+		// the DAGService stores nodes as it encodes them
+		k, err := nd.Key()
+		if err != nil {
+			return nil, 0, err
+		}
+		if k != b.Key() {
+			return nil, 0, fmt.Errorf("block %s is not a canonically encoded merkledag node", b.Key())
+		}
+
+		if err := add(nd); err != nil {
+			return nil, 0, err
+		}
+		count++
+	}
+	return ar.Roots, count, nil
+}
@@ -0,0 +1,110 @@
+package merkledag
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+
+	bserv "github.com/ipfs/go-ipfs/blockservice"
+	u "github.com/ipfs/go-ipfs/util"
+)
+
+// NewCachedDAGService returns a DAGService that keeps the nodes decoded by
+// Get in cache, and returns copies of them. Remove drops the removed nodes
+// from the cache; blocks deleted from bs directly, by a garbage collection
+// for instance, must be passed to cache.Invalidate, or they can still be
+// returned by Get. See blockstore.NotifyDeletes.
+func NewCachedDAGService(bs *bserv.BlockService, cache *NodeCache) DAGService {
+	return &dagService{Blocks: bs, cache: cache}
+}
+
+// NodeCache is an LRU cache of decoded nodes, for NewCachedDAGService.
+type NodeCache struct {
+	maxBytes int
+
+	lk      sync.Mutex
+	entries map[u.Key]*list.Element
+	lru     *list.List // of *nodeCacheEntry, most recently used first
+	size    int        // encoded size of the cached nodes
+	// invalidations counts the calls to Invalidate, so that a Get does not
+	// cache a node whose block was deleted while it was reading it.
+	invalidations uint64
+}
+
+type nodeCacheEntry struct {
+	key  u.Key
+	node *Node
+	size int
+}
+
+// NewNodeCache returns a cache of nodes holding up to |maxBytes| of their
+// encoded size, evicting the least recently used ones.
+func NewNodeCache(maxBytes int) (*NodeCache, error) {
+	if maxBytes <= 0 {
+		return nil, errors.New("node cache size must be positive")
+	}
+	return &NodeCache{
+		maxBytes: maxBytes,
+		entries:  make(map[u.Key]*list.Element),
+		lru:      list.New(),
+	}, nil
+}
+
+// get returns a copy of the node cached under k, or nil along with the
+// number of invalidations so far, to pass to add.
+func (c *NodeCache) get(k u.Key) (*Node, uint64) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	e, ok := c.entries[k]
+	if !ok {
+		return nil, c.invalidations
+	}
+	c.lru.MoveToFront(e)
+	return copyNode(e.Value.(*nodeCacheEntry).node), c.invalidations
+}
+
+// add caches a copy of nd, decoded from size bytes, under k, unless nodes
+// were invalidated since get returned invalidations.
+func (c *NodeCache) add(k u.Key, nd *Node, size int, invalidations uint64) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	if c.invalidations != invalidations {
+		return
+	}
+	if _, ok := c.entries[k]; ok || size > c.maxBytes {
+		return
+	}
+
+	c.entries[k] = c.lru.PushFront(&nodeCacheEntry{key: k, node: copyNode(nd), size: size})
+	c.size += size
+	for c.size > c.maxBytes {
+		c.remove(c.lru.Back())
+	}
+}
+
+// Invalidate drops the node cached under k, if any.
+func (c *NodeCache) Invalidate(k u.Key) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	if e, ok := c.entries[k]; ok {
+		c.remove(e)
+	}
+	c.invalidations++
+}
+
+// remove removes e from the cache. Caller must hold lk.
+func (c *NodeCache) remove(e *list.Element) {
+	ent := e.Value.(*nodeCacheEntry)
+	c.lru.Remove(e)
+	c.size -= ent.size
+	delete(c.entries, ent.key)
+}
+
+// copyNode returns a copy of nd that shares no mutable state with it.
+func copyNode(nd *Node) *Node {
+	c := nd.Copy()
+	for i, l := range c.Links {
+		c.Links[i] = &Link{Name: l.Name, Size: l.Size, Hash: l.Hash}
+	}
+	return c
+}
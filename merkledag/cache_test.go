@@ -0,0 +1,155 @@
+package merkledag_test
+
+import (
+	"bytes"
+	"testing"
+
+	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
+	dssync "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/sync"
+	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+	bstore "github.com/ipfs/go-ipfs/blocks/blockstore"
+	bserv "github.com/ipfs/go-ipfs/blockservice"
+	offline "github.com/ipfs/go-ipfs/exchange/offline"
+	. "github.com/ipfs/go-ipfs/merkledag"
+	u "github.com/ipfs/go-ipfs/util"
+)
+
+func getCachedDagserv(t *testing.T, maxBytes int) (DAGService, bstore.Blockstore) {
+	bs := bstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+	blockserv, err := bserv.New(bs, offline.Exchange(bs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache, err := NewNodeCache(maxBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewCachedDAGService(blockserv, cache), bs
+}
+
+func addCacheTestNode(t *testing.T, dserv DAGService, data string) u.Key {
+	nd := &Node{Data: []byte(data)}
+	if err := nd.AddNodeLinkClean("child", &Node{Data: []byte("child of " + data)}); err != nil {
+		t.Fatal(err)
+	}
+	k, err := dserv.Add(nd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestCachedDAGServiceReturnsCopies(t *testing.T) {
+	ctx := context.Background()
+	dserv, bs := getCachedDagserv(t, 1024)
+	k := addCacheTestNode(t, dserv, "root")
+
+	nd, err := dserv.Get(ctx, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nd.Data[0] = 'X'
+	nd.Links[0].Name = "changed"
+	nd.Links[0].Node = &Node{}
+
+	// served from the cache once the block is gone without it knowing
+	if err := bs.DeleteBlock(k); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		nd, err = dserv.Get(ctx, k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(nd.Data, []byte("root")) || nd.Links[0].Name != "child" || nd.Links[0].Node != nil {
+			t.Fatal("cached node was modified through a returned node")
+		}
+		nk, err := nd.Key()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if nk != k {
+			t.Fatal("cached node has the wrong key")
+		}
+		nd.Data = []byte("changed")
+	}
+}
+
+func TestCachedDAGServiceRemove(t *testing.T) {
+	ctx := context.Background()
+	dserv, _ := getCachedDagserv(t, 1024)
+	k := addCacheTestNode(t, dserv, "root")
+
+	nd, err := dserv.Get(ctx, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dserv.Remove(nd); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := dserv.Get(ctx, k); err == nil {
+		t.Fatal("removed node was still returned")
+	}
+}
+
+func TestCachedDAGServiceEvicts(t *testing.T) {
+	ctx := context.Background()
+	dserv, bs := getCachedDagserv(t, 100)
+	k1 := addCacheTestNode(t, dserv, "first")
+	k2 := addCacheTestNode(t, dserv, "second")
+
+	for _, k := range []u.Key{k1, k2} {
+		if _, err := dserv.Get(ctx, k); err != nil {
+			t.Fatal(err)
+		}
+		if err := bs.DeleteBlock(k); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := dserv.Get(ctx, k2); err != nil {
+		t.Fatal("expected the last node read to be cached, got", err)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := dserv.Get(ctx, k1); err == nil {
+		t.Fatal("expected the first node read to be evicted")
+	}
+}
+
+func TestCachedDAGServiceSeesBlockstoreDeletes(t *testing.T) {
+	ctx := context.Background()
+	cache, err := NewNodeCache(1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bs := bstore.NotifyDeletes(bstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore())), cache.Invalidate)
+	blockserv, err := bserv.New(bs, offline.Exchange(bs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dserv := NewCachedDAGService(blockserv, cache)
+	k := addCacheTestNode(t, dserv, "root")
+
+	if _, err := dserv.Get(ctx, k); err != nil {
+		t.Fatal(err)
+	}
+	if err := bs.DeleteBlock(k); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := dserv.Get(ctx, k); err == nil {
+		t.Fatal("node of a deleted block was still returned")
+	}
+}
+
+func TestNodeCacheSizeNotPositive(t *testing.T) {
+	if _, err := NewNodeCache(0); err == nil {
+		t.Fatal("expected an error for a zero cache size")
+	}
+}
@@ -84,7 +84,11 @@ func (n *Node) Encoded(force bool) ([]byte, error) {
 		if err != nil {
 			return []byte{}, err
 		}
-		n.cached = u.Hash(n.encoded)
+		n.cached, err = u.Sum(n.encoded, n.HashFunc(), -1)
+		if err != nil {
+			n.encoded = nil
+			return []byte{}, err
+		}
 	}
 
 	return n.encoded, nil
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"sync"
 
+	mh "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-multihash"
 	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
 	blocks "github.com/ipfs/go-ipfs/blocks"
 	bserv "github.com/ipfs/go-ipfs/blockservice"
@@ -25,19 +26,23 @@ type DAGService interface {
 	// nodes of the passed in node.
 	GetDAG(context.Context, *Node) []NodeGetter
 	GetNodes(context.Context, []u.Key) []NodeGetter
+
+	// Batch returns a Batch that adds nodes to this DAGService in groups.
+	Batch() *Batch
 }
 
 func NewDAGService(bs *bserv.BlockService) DAGService {
-	return &dagService{bs}
+	return &dagService{Blocks: bs}
 }
 
 // dagService is an IPFS Merkle DAG service.
 // - the root is virtual (like a forest)
 // - stores nodes' data in a BlockService
-// TODO: should cache Nodes that are in memory, and be
-//       able to free some of them when vm pressure is high
+// - may keep decoded nodes in a cache, see NewCachedDAGService
 type dagService struct {
 	Blocks *bserv.BlockService
+
+	cache *NodeCache // nil if nodes are not cached
 }
 
 // Add adds a node to the dagService, storing the block in the BlockService
@@ -61,6 +66,10 @@ func (n *dagService) Add(nd *Node) (u.Key, error) {
 	return n.Blocks.AddBlock(b)
 }
 
+func (n *dagService) Batch() *Batch {
+	return &Batch{ds: n, MaxSize: 8 * 1024 * 1024}
+}
+
 // AddRecursive adds the given node and all child nodes to the BlockService
 func (n *dagService) AddRecursive(nd *Node) error {
 	_, err := n.Add(nd)
@@ -87,12 +96,41 @@ func (n *dagService) Get(ctx context.Context, k u.Key) (*Node, error) {
 		return nil, fmt.Errorf("dagService is nil")
 	}
 
+	var invalidations uint64
+	if n.cache != nil {
+		var nd *Node
+		nd, invalidations = n.cache.get(k)
+		if nd != nil {
+			return nd, nil
+		}
+	}
+
 	b, err := n.Blocks.GetBlock(ctx, k)
 	if err != nil {
 		return nil, err
 	}
 
-	return Decoded(b.Data)
+	nd, err := decodeBlock(b)
+	if err != nil {
+		return nil, err
+	}
+	if n.cache != nil {
+		n.cache.add(k, nd, len(b.Data), invalidations)
+	}
+	return nd, nil
+}
+
+// decodeBlock decodes the node in b, keeping the hash function of its key
+// so that the node hashes back to it.
+func decodeBlock(b *blocks.Block) (*Node, error) {
+	nd, err := Decoded(b.Data)
+	if err != nil {
+		return nil, err
+	}
+	if dh, err := mh.Decode(b.Multihash); err == nil {
+		nd.SetHashFunc(dh.Code)
+	}
+	return nd, nil
 }
 
 // Remove deletes the given node and all of its children from the BlockService
@@ -106,7 +144,57 @@ func (n *dagService) Remove(nd *Node) error {
 	if err != nil {
 		return err
 	}
-	return n.Blocks.DeleteBlock(k)
+	err = n.Blocks.DeleteBlock(k)
+	if n.cache != nil {
+		n.cache.Invalidate(k)
+	}
+	return err
+}
+
+// Batch collects nodes and adds their blocks to the BlockService together,
+// once more than MaxSize bytes are pending or Commit is called. Nodes added
+// to a Batch can not be retrieved before they are committed.
+type Batch struct {
+	ds *dagService
+
+	blocks  []*blocks.Block
+	size    int
+	MaxSize int
+}
+
+// Add queues nd to be added, and returns its key.
+func (t *Batch) Add(nd *Node) (u.Key, error) {
+	d, err := nd.Encoded(false)
+	if err != nil {
+		return "", err
+	}
+
+	b := new(blocks.Block)
+	b.Data = d
+	b.Multihash, err = nd.Multihash()
+	if err != nil {
+		return "", err
+	}
+
+	k := u.Key(b.Multihash)
+
+	t.blocks = append(t.blocks, b)
+	t.size += len(b.Data)
+	if t.size > t.MaxSize {
+		return k, t.Commit()
+	}
+	return k, nil
+}
+
+// Commit adds all pending nodes to the BlockService.
+func (t *Batch) Commit() error {
+	if len(t.blocks) == 0 {
+		return nil
+	}
+	_, err := t.ds.Blocks.AddBlocks(t.blocks)
+	t.blocks = nil
+	t.size = 0
+	return err
 }
 
 // FetchGraph asynchronously fetches all nodes that are children of the given
@@ -199,7 +287,7 @@ func (ds *dagService) GetNodes(ctx context.Context, keys []u.Key) []NodeGetter {
 					return
 				}
 
-				nd, err := Decoded(blk.Data)
+				nd, err := decodeBlock(blk)
 				if err != nil {
 					// NB: can happen with improperly formatted input data
 					log.Debug("Got back bad block!")
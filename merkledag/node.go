@@ -24,6 +24,9 @@ type Node struct {
 	encoded []byte
 
 	cached mh.Multihash
+
+	// multihash function the node is hashed with, 0 for the default
+	hashFunc int
 }
 
 // NodeStat is a statistics object for a Node. Mostly sizes.
@@ -166,6 +169,7 @@ func (n *Node) Copy() *Node {
 
 	nnode.Links = make([]*Link, len(n.Links))
 	copy(nnode.Links, n.Links)
+	nnode.hashFunc = n.hashFunc
 	return nnode
 }
 
@@ -226,6 +230,22 @@ func (n *Node) Multihash() (mh.Multihash, error) {
 	return n.cached, nil
 }
 
+// SetHashFunc sets the multihash function the node is hashed with.
+func (n *Node) SetHashFunc(code int) {
+	if code != n.hashFunc {
+		n.hashFunc = code
+		n.encoded = nil
+	}
+}
+
+// HashFunc returns the multihash function the node is hashed with.
+func (n *Node) HashFunc() int {
+	if n.hashFunc == 0 {
+		return mh.SHA2_256
+	}
+	return n.hashFunc
+}
+
 // Key returns the Multihash as a key, for maps.
 func (n *Node) Key() (u.Key, error) {
 	h, err := n.Multihash()
@@ -0,0 +1,153 @@
+// Package dagutils provides functions to compare and manipulate merkledag
+// trees.
+package dagutils
+
+import (
+	"bytes"
+	gopath "path"
+	"sort"
+
+	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+
+	dag "github.com/ipfs/go-ipfs/merkledag"
+	ft "github.com/ipfs/go-ipfs/unixfs"
+	hamt "github.com/ipfs/go-ipfs/unixfs/hamt"
+	u "github.com/ipfs/go-ipfs/util"
+)
+
+// ChangeType says how a path differs between two trees.
+type ChangeType string
+
+const (
+	Add    ChangeType = "added"
+	Remove ChangeType = "removed"
+	Mod    ChangeType = "modified"
+)
+
+// Change is a difference between two trees at Path, which is relative to
+// their roots and empty for the roots themselves. Before is not set for an
+// added path, and After is not set for a removed one.
+type Change struct {
+	Type   ChangeType
+	Path   string
+	Before u.Key `json:",omitempty"`
+	After  u.Key `json:",omitempty"`
+}
+
+// Diff returns the changes from the tree rooted at a to the tree rooted at
+// b, in depth-first order: a path comes before the paths below it, and the
+// links of a node are visited in name order. Links are matched by name,
+// and subtrees with the same hash on both sides are not fetched. A node
+// whose links are not uniquely named, like the chunks of a unixfs file, is
+// compared as a whole and reported modified. Otherwise a node is reported
+// modified when its data differs, and the changes below it are reported as
+// well. A sharded unixfs directory is compared by its entries, like the
+// plain directory holding them.
+func Diff(ctx context.Context, ds dag.DAGService, a, b *dag.Node) ([]*Change, error) {
+	var changes []*Change
+	if err := diff(ctx, ds, "", a, b, &changes); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+func diff(ctx context.Context, ds dag.DAGService, p string, a, b *dag.Node, changes *[]*Change) error {
+	ak, err := a.Key()
+	if err != nil {
+		return err
+	}
+	bk, err := b.Key()
+	if err != nil {
+		return err
+	}
+	if ak == bk {
+		return nil
+	}
+
+	alinks, adata, aok, err := entries(ctx, ds, a)
+	if err != nil {
+		return err
+	}
+	blinks, bdata, bok, err := entries(ctx, ds, b)
+	if err != nil {
+		return err
+	}
+	if !aok || !bok || !bytes.Equal(adata, bdata) {
+		*changes = append(*changes, &Change{Type: Mod, Path: p, Before: ak, After: bk})
+		if !aok || !bok {
+			return nil
+		}
+	}
+
+	names := make([]string, 0, len(alinks)+len(blinks))
+	for name := range alinks {
+		names = append(names, name)
+	}
+	for name := range blinks {
+		if _, ok := alinks[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		al, inA := alinks[name]
+		bl, inB := blinks[name]
+		lp := gopath.Join(p, name)
+		switch {
+		case !inB:
+			*changes = append(*changes, &Change{Type: Remove, Path: lp, Before: u.Key(al.Hash)})
+		case !inA:
+			*changes = append(*changes, &Change{Type: Add, Path: lp, After: u.Key(bl.Hash)})
+		case !bytes.Equal(al.Hash, bl.Hash):
+			an, err := al.GetNode(ctx, ds)
+			if err != nil {
+				return err
+			}
+			bn, err := bl.GetNode(ctx, ds)
+			if err != nil {
+				return err
+			}
+			if err := diff(ctx, ds, lp, an, bn, changes); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// entries returns the links of n by name, the data n is compared by, and
+// whether every link has a name of its own. The links of a sharded
+// directory are its entries, and its data that of a plain directory.
+func entries(ctx context.Context, ds dag.DAGService, n *dag.Node) (map[string]*dag.Link, []byte, bool, error) {
+	if !hamt.IsShard(n) {
+		links, ok := namedLinks(n.Links)
+		return links, n.Data, ok, nil
+	}
+	shard, err := hamt.NewShardFromNode(ds, n)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	shardLinks, err := shard.Links(ctx)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	links, ok := namedLinks(shardLinks)
+	return links, ft.FolderPBData(), ok, nil
+}
+
+// namedLinks returns links by name, and whether every link has a name of
+// its own.
+func namedLinks(ls []*dag.Link) (map[string]*dag.Link, bool) {
+	links := make(map[string]*dag.Link, len(ls))
+	for _, l := range ls {
+		if l.Name == "" {
+			return nil, false
+		}
+		if _, ok := links[l.Name]; ok {
+			return nil, false
+		}
+		links[l.Name] = l
+	}
+	return links, true
+}
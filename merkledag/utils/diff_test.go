@@ -0,0 +1,171 @@
+package dagutils
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+
+	dag "github.com/ipfs/go-ipfs/merkledag"
+	mdtest "github.com/ipfs/go-ipfs/merkledag/test"
+	ft "github.com/ipfs/go-ipfs/unixfs"
+	hamt "github.com/ipfs/go-ipfs/unixfs/hamt"
+)
+
+func mustAddLink(t *testing.T, n *dag.Node, name string, child *dag.Node) {
+	if err := n.AddNodeLink(name, child); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	ctx := context.Background()
+	ds := mdtest.Mock(t)
+
+	same := &dag.Node{Data: []byte("same")}
+	old := &dag.Node{Data: []byte("old")}
+	changed := &dag.Node{Data: []byte("changed")}
+	gone := &dag.Node{Data: []byte("gone")}
+	added := &dag.Node{Data: []byte("added")}
+
+	// a: {same, dir{file: old, gone}, sub{same}}
+	adir := &dag.Node{Data: []byte("dir")}
+	mustAddLink(t, adir, "file", old)
+	mustAddLink(t, adir, "gone", gone)
+	asub := &dag.Node{Data: []byte("sub")}
+	mustAddLink(t, asub, "same", same)
+	a := &dag.Node{Data: []byte("root")}
+	mustAddLink(t, a, "same", same)
+	mustAddLink(t, a, "dir", adir)
+	mustAddLink(t, a, "sub", asub)
+
+	// b: {same, dir{file: changed, new: added}, sub{same}}, with new root data
+	bdir := &dag.Node{Data: []byte("dir")}
+	mustAddLink(t, bdir, "file", changed)
+	mustAddLink(t, bdir, "new", added)
+	b := &dag.Node{Data: []byte("new root")}
+	mustAddLink(t, b, "same", same)
+	mustAddLink(t, b, "dir", bdir)
+	mustAddLink(t, b, "sub", asub)
+
+	for _, n := range []*dag.Node{a, b} {
+		if err := ds.AddRecursive(n); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	changes, err := Diff(ctx, ds, a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldKey, _ := old.Key()
+	changedKey, _ := changed.Key()
+	goneKey, _ := gone.Key()
+	addedKey, _ := added.Key()
+	expected := []Change{
+		{Type: Mod, Path: ""},
+		{Type: Mod, Path: "dir/file", Before: oldKey, After: changedKey},
+		{Type: Remove, Path: "dir/gone", Before: goneKey},
+		{Type: Add, Path: "dir/new", After: addedKey},
+	}
+	if len(changes) != len(expected) {
+		t.Fatalf("expected %d changes, got %d", len(expected), len(changes))
+	}
+	for i, c := range changes {
+		e := expected[i]
+		if c.Type != e.Type || c.Path != e.Path {
+			t.Fatalf("change %d: expected %s %q, got %s %q", i, e.Type, e.Path, c.Type, c.Path)
+		}
+		if e.Path != "" && (c.Before != e.Before || c.After != e.After) {
+			t.Fatalf("change %d: wrong keys for %q", i, c.Path)
+		}
+	}
+
+	changes, err = Diff(ctx, ds, a, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Fatal("expected no changes between identical trees, got", len(changes))
+	}
+}
+
+func TestDiffUnnamedLinks(t *testing.T) {
+	ctx := context.Background()
+	ds := mdtest.Mock(t)
+
+	// like the chunks of a file, which are not compared one by one
+	a := &dag.Node{Data: []byte("file")}
+	mustAddLink(t, a, "", &dag.Node{Data: []byte("one")})
+	b := &dag.Node{Data: []byte("file")}
+	mustAddLink(t, b, "", &dag.Node{Data: []byte("two")})
+
+	changes, err := Diff(ctx, ds, a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Type != Mod || changes[0].Path != "" {
+		t.Fatal("expected the root to be reported modified, got", changes)
+	}
+}
+
+func TestDiffShards(t *testing.T) {
+	ctx := context.Background()
+	ds := mdtest.Mock(t)
+
+	// a shard with enough entries to have child shards, and a plain
+	// directory with the same entries
+	plain := &dag.Node{Data: ft.FolderPBData()}
+	shard, err := hamt.NewShard(ds, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		nd := &dag.Node{Data: []byte(strconv.Itoa(i))}
+		if _, err := ds.Add(nd); err != nil {
+			t.Fatal(err)
+		}
+		name := "entry" + strconv.Itoa(i)
+		mustAddLink(t, plain, name, nd)
+		if err := shard.Set(ctx, name, nd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a, err := shard.Node()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := Diff(ctx, ds, plain, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Fatal("expected a shard to match the plain directory of its entries, got", changes)
+	}
+
+	added := &dag.Node{Data: []byte("added")}
+	if _, err := ds.Add(added); err != nil {
+		t.Fatal(err)
+	}
+	if err := shard.Set(ctx, "new", added); err != nil {
+		t.Fatal(err)
+	}
+	if err := shard.Remove(ctx, "entry3"); err != nil {
+		t.Fatal(err)
+	}
+	b, err := shard.Node()
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes, err = Diff(ctx, ds, a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 2 ||
+		changes[0].Type != Remove || changes[0].Path != "entry3" ||
+		changes[1].Type != Add || changes[1].Path != "new" {
+		t.Fatal("expected entry3 removed and new added, got", changes)
+	}
+}
@@ -0,0 +1,57 @@
+package dagutils
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	dag "github.com/ipfs/go-ipfs/merkledag"
+)
+
+// A Template makes a new node for 'ipfs object new'. Packages defining
+// formats register their templates, like unixfs/io does for "unixfs-dir".
+type Template func() *dag.Node
+
+var (
+	templatesLock sync.RWMutex
+	templates     = make(map[string]Template)
+)
+
+// RegisterTemplate makes the nodes made by t available under name. It is
+// an error to register a name twice.
+func RegisterTemplate(name string, t Template) error {
+	templatesLock.Lock()
+	defer templatesLock.Unlock()
+
+	if _, ok := templates[name]; ok {
+		return fmt.Errorf("template %q is already registered", name)
+	}
+	templates[name] = t
+	return nil
+}
+
+// NewFromTemplate returns a new node made by the template registered under
+// name.
+func NewFromTemplate(name string) (*dag.Node, error) {
+	templatesLock.RLock()
+	t, ok := templates[name]
+	templatesLock.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown template %q", name)
+	}
+	return t(), nil
+}
+
+// Templates returns the names of the registered templates, sorted.
+func Templates() []string {
+	templatesLock.RLock()
+	defer templatesLock.RUnlock()
+
+	names := make([]string, 0, len(templates))
+	for name := range templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
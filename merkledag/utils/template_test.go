@@ -0,0 +1,35 @@
+package dagutils
+
+import (
+	"testing"
+
+	dag "github.com/ipfs/go-ipfs/merkledag"
+)
+
+func TestTemplates(t *testing.T) {
+	if _, err := NewFromTemplate("test-beep"); err == nil {
+		t.Fatal("expected an unknown template to fail")
+	}
+	beep := func() *dag.Node { return &dag.Node{Data: []byte("beep")} }
+	if err := RegisterTemplate("test-beep", beep); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterTemplate("test-beep", beep); err == nil {
+		t.Fatal("expected registering a template twice to fail")
+	}
+
+	// every call makes a node of its own
+	a, err := NewFromTemplate("test-beep")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := NewFromTemplate("test-beep")
+	if a == b || string(a.Data) != "beep" {
+		t.Fatal("template did not make a new node")
+	}
+
+	names := Templates()
+	if len(names) != 1 || names[0] != "test-beep" {
+		t.Fatal("unexpected templates:", names)
+	}
+}
@@ -0,0 +1,124 @@
+package dagutils
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+
+	dag "github.com/ipfs/go-ipfs/merkledag"
+	hamt "github.com/ipfs/go-ipfs/unixfs/hamt"
+)
+
+var ErrEmptyPath = errors.New("empty link path")
+
+// InsertNodeAtPath returns a copy of root with toinsert linked at the
+// slash-separated path pth, replacing any link already there. The nodes
+// along pth are copied with their links updated. A node missing along pth
+// is made with create, or is an error if create is nil. The new nodes are
+// not added to ds.
+func InsertNodeAtPath(ctx context.Context, ds dag.DAGService, root *dag.Node, pth string, toinsert *dag.Node, create func() *dag.Node) (*dag.Node, error) {
+	parts, err := splitLinkPath(pth)
+	if err != nil {
+		return nil, err
+	}
+	return insertNodeAtPath(ctx, ds, root, parts, toinsert, create)
+}
+
+func insertNodeAtPath(ctx context.Context, ds dag.DAGService, root *dag.Node, parts []string, toinsert *dag.Node, create func() *dag.Node) (*dag.Node, error) {
+	if hamt.IsShard(root) {
+		return nil, shardError(root)
+	}
+	if len(parts) == 1 {
+		return root.UpdateNodeLink(parts[0], toinsert)
+	}
+
+	child, err := childNode(ctx, ds, root, parts[0])
+	if err == dag.ErrNotFound && create != nil {
+		child, err = create(), nil
+	}
+	if err != nil {
+		return nil, linkError(root, parts[0], err)
+	}
+
+	nchild, err := insertNodeAtPath(ctx, ds, child, parts[1:], toinsert, create)
+	if err != nil {
+		return nil, err
+	}
+	return root.UpdateNodeLink(parts[0], nchild)
+}
+
+// RmLink returns a copy of root without the link at the slash-separated
+// path pth. The nodes along pth are copied with their links updated, and
+// are not added to ds.
+func RmLink(ctx context.Context, ds dag.DAGService, root *dag.Node, pth string) (*dag.Node, error) {
+	parts, err := splitLinkPath(pth)
+	if err != nil {
+		return nil, err
+	}
+	return rmLink(ctx, ds, root, parts)
+}
+
+func rmLink(ctx context.Context, ds dag.DAGService, root *dag.Node, parts []string) (*dag.Node, error) {
+	if hamt.IsShard(root) {
+		return nil, shardError(root)
+	}
+	if len(parts) == 1 {
+		nroot := root.Copy()
+		if err := nroot.RemoveNodeLink(parts[0]); err != nil {
+			return nil, linkError(root, parts[0], err)
+		}
+		return nroot, nil
+	}
+
+	child, err := childNode(ctx, ds, root, parts[0])
+	if err != nil {
+		return nil, linkError(root, parts[0], err)
+	}
+	nchild, err := rmLink(ctx, ds, child, parts[1:])
+	if err != nil {
+		return nil, err
+	}
+	return root.UpdateNodeLink(parts[0], nchild)
+}
+
+func childNode(ctx context.Context, ds dag.DAGService, n *dag.Node, name string) (*dag.Node, error) {
+	l, err := n.GetNodeLink(name)
+	if err != nil {
+		return nil, err
+	}
+	return l.GetNode(ctx, ds)
+}
+
+func linkError(n *dag.Node, name string, err error) error {
+	if err != dag.ErrNotFound {
+		return err
+	}
+	k, kerr := n.Key()
+	if kerr != nil {
+		return kerr
+	}
+	return fmt.Errorf("no link named %q under %s", name, k)
+}
+
+// shardError refuses to edit the links of a node of a sharded unixfs
+// directory, which are named by the buckets of its entries. Sharded
+// directories are edited through unixfs/io.Directory instead.
+func shardError(n *dag.Node) error {
+	k, err := n.Key()
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("%s is a sharded directory, its links can not be edited directly", k)
+}
+
+func splitLinkPath(pth string) ([]string, error) {
+	parts := strings.Split(strings.Trim(pth, "/"), "/")
+	for _, p := range parts {
+		if p == "" {
+			return nil, ErrEmptyPath
+		}
+	}
+	return parts, nil
+}
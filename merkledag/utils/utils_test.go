@@ -0,0 +1,116 @@
+package dagutils
+
+import (
+	"testing"
+
+	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+
+	dag "github.com/ipfs/go-ipfs/merkledag"
+	mdtest "github.com/ipfs/go-ipfs/merkledag/test"
+	hamt "github.com/ipfs/go-ipfs/unixfs/hamt"
+)
+
+func TestInsertNodeAtPath(t *testing.T) {
+	ctx := context.Background()
+	ds := mdtest.Mock(t)
+
+	root := &dag.Node{Data: []byte("root")}
+	leaf := &dag.Node{Data: []byte("leaf")}
+	if _, err := ds.Add(leaf); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := InsertNodeAtPath(ctx, ds, root, "a/b/leaf", leaf, nil); err == nil {
+		t.Fatal("expected missing intermediate nodes to be an error")
+	}
+
+	mkdir := func() *dag.Node { return &dag.Node{Data: []byte("dir")} }
+	nroot, err := InsertNodeAtPath(ctx, ds, root, "/a/b/leaf", leaf, mkdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ds.AddRecursive(nroot); err != nil {
+		t.Fatal(err)
+	}
+	if len(root.Links) != 0 {
+		t.Fatal("the original root was modified")
+	}
+
+	// a second link into the now existing directories
+	nroot, err = InsertNodeAtPath(ctx, ds, nroot, "a/b/other", leaf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ds.AddRecursive(nroot); err != nil {
+		t.Fatal(err)
+	}
+
+	b := mustChild(t, ds, mustChild(t, ds, nroot, "a"), "b")
+	if len(b.Links) != 2 {
+		t.Fatalf("expected 2 links below a/b, got %d", len(b.Links))
+	}
+
+	nroot, err = RmLink(ctx, ds, nroot, "a/b/leaf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ds.AddRecursive(nroot); err != nil {
+		t.Fatal(err)
+	}
+	changes, err := Diff(ctx, ds, b, mustChild(t, ds, mustChild(t, ds, nroot, "a"), "b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Type != Remove || changes[0].Path != "leaf" {
+		t.Fatal("expected only a/b/leaf to be removed, got", changes)
+	}
+
+	if _, err := RmLink(ctx, ds, nroot, "a/nope"); err == nil {
+		t.Fatal("expected removing a missing link to fail")
+	}
+	if _, err := RmLink(ctx, ds, nroot, "a//b"); err != ErrEmptyPath {
+		t.Fatal("expected an empty path component to fail, got", err)
+	}
+}
+
+func TestEditShardRefused(t *testing.T) {
+	ctx := context.Background()
+	ds := mdtest.Mock(t)
+
+	leaf := &dag.Node{Data: []byte("leaf")}
+	shard, err := hamt.NewShard(ds, hamt.DefaultFanout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := shard.Set(ctx, "leaf", leaf); err != nil {
+		t.Fatal(err)
+	}
+	sharded, err := shard.Node()
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := &dag.Node{Data: []byte("root")}
+	mustAddLink(t, root, "dir", sharded)
+	if err := ds.AddRecursive(root); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := InsertNodeAtPath(ctx, ds, root, "dir/other", leaf, nil); err == nil {
+		t.Fatal("expected adding a link to a shard to fail")
+	}
+	if _, err := RmLink(ctx, ds, sharded, "leaf"); err == nil {
+		t.Fatal("expected removing a link from a shard to fail")
+	}
+	// the links around a shard can still be edited
+	if _, err := RmLink(ctx, ds, root, "dir"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func mustChild(t *testing.T, ds dag.DAGService, n *dag.Node, name string) *dag.Node {
+	child, err := childNode(context.Background(), ds, n, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return child
+}
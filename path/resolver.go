@@ -10,6 +10,7 @@ import (
 	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
 
 	merkledag "github.com/ipfs/go-ipfs/merkledag"
+	hamt "github.com/ipfs/go-ipfs/unixfs/hamt"
 	u "github.com/ipfs/go-ipfs/util"
 )
 
@@ -109,12 +110,24 @@ func (s *Resolver) ResolveLinks(ctx context.Context, ndd *merkledag.Node, names
 
 		var next u.Key
 		var nlink *merkledag.Link
-		// for each of the links in nd, the current object
-		for _, link := range nd.Links {
-			if link.Name == name {
+		if hamt.IsShard(nd) {
+			// the entries of a sharded directory are below its links
+			link, err := s.findShardLink(ctx, nd, name)
+			if err != nil && err != merkledag.ErrNotFound {
+				return result, err
+			}
+			if err == nil {
 				next = u.Key(link.Hash)
 				nlink = link
-				break
+			}
+		} else {
+			// for each of the links in nd, the current object
+			for _, link := range nd.Links {
+				if link.Name == name {
+					next = u.Key(link.Hash)
+					nlink = link
+					break
+				}
 			}
 		}
 
@@ -141,3 +154,13 @@ func (s *Resolver) ResolveLinks(ctx context.Context, ndd *merkledag.Node, names
 	}
 	return result, nil
 }
+
+func (s *Resolver) findShardLink(ctx context.Context, nd *merkledag.Node, name string) (*merkledag.Link, error) {
+	shard, err := hamt.NewShardFromNode(s.DAG, nd)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	return shard.Find(ctx, name)
+}
@@ -0,0 +1,47 @@
+package pin
+
+import (
+	"sync"
+
+	"github.com/ipfs/go-ipfs/util"
+)
+
+// HeldKeys counts the holds on keys that gc must keep although they are
+// not pinned yet, like the nodes of a DAG being fetched to be pinned. The
+// zero value holds no keys.
+type HeldKeys struct {
+	lk   sync.Mutex
+	keys map[util.Key]int
+}
+
+// Hold adds a hold on each of keys.
+func (h *HeldKeys) Hold(keys []util.Key) {
+	h.lk.Lock()
+	defer h.lk.Unlock()
+	if h.keys == nil {
+		h.keys = make(map[util.Key]int)
+	}
+	for _, k := range keys {
+		h.keys[k]++
+	}
+}
+
+// Release removes a hold on each of keys.
+func (h *HeldKeys) Release(keys []util.Key) {
+	h.lk.Lock()
+	defer h.lk.Unlock()
+	for _, k := range keys {
+		if h.keys[k] <= 1 {
+			delete(h.keys, k)
+		} else {
+			h.keys[k]--
+		}
+	}
+}
+
+// IsHeld returns whether there is a hold on k.
+func (h *HeldKeys) IsHeld(k util.Key) bool {
+	h.lk.Lock()
+	defer h.lk.Unlock()
+	return h.keys[k] > 0
+}
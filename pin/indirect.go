@@ -1,7 +1,6 @@
 package pin
 
 import (
-	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
 	"github.com/ipfs/go-ipfs/blocks/set"
 	"github.com/ipfs/go-ipfs/util"
 )
@@ -11,41 +10,48 @@ type indirectPin struct {
 	refCounts map[util.Key]int
 }
 
-func NewIndirectPin(dstore ds.Datastore) *indirectPin {
+func newIndirectPin() *indirectPin {
 	return &indirectPin{
-		blockset:  set.NewDBWrapperSet(dstore, set.NewSimpleBlockSet()),
+		blockset:  set.NewSimpleBlockSet(),
 		refCounts: make(map[util.Key]int),
 	}
 }
 
-func loadIndirPin(d ds.Datastore, k ds.Key) (*indirectPin, error) {
-	var rcStore map[string]int
-	err := loadSet(d, k, &rcStore)
-	if err != nil {
-		return nil, err
+// add adds count references to k, as loaded from a pin set.
+func (i *indirectPin) add(k util.Key, count int) {
+	if count <= 0 {
+		return
 	}
-
-	refcnt := make(map[util.Key]int)
-	var keys []util.Key
-	for encK, v := range rcStore {
-		if v > 0 {
-			k := util.B58KeyDecode(encK)
-			keys = append(keys, k)
-			refcnt[k] = v
-		}
+	if i.refCounts[k] <= 0 {
+		i.blockset.AddBlock(k)
 	}
-	// log.Debugf("indirPin keys: %#v", keys)
-
-	return &indirectPin{blockset: set.SimpleSetFromKeys(keys), refCounts: refcnt}, nil
+	i.refCounts[k] += count
 }
 
-func storeIndirPin(d ds.Datastore, k ds.Key, p *indirectPin) error {
+// adjust changes the count of k by delta, dropping k once it reaches zero.
+func (i *indirectPin) adjust(k util.Key, delta int) {
+	if delta == 0 {
+		return
+	}
+	c := i.refCounts[k] + delta
+	if c <= 0 {
+		i.blockset.RemoveBlock(k)
+		delete(i.refCounts, k)
+		return
+	}
+	if i.refCounts[k] <= 0 {
+		i.blockset.AddBlock(k)
+	}
+	i.refCounts[k] = c
+}
 
-	rcStore := map[string]int{}
-	for k, v := range p.refCounts {
-		rcStore[util.B58KeyEncode(k)] = v
+// items returns the keys and their counts, to be stored as a pin set.
+func (i *indirectPin) items() []setItem {
+	items := make([]setItem, 0, len(i.refCounts))
+	for k, v := range i.refCounts {
+		items = append(items, setItem{key: k, count: v})
 	}
-	return storeSet(d, k, rcStore)
+	return items
 }
 
 func (i *indirectPin) Increment(k util.Key) {
@@ -0,0 +1,86 @@
+package pin
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/ipfs/go-ipfs/util"
+)
+
+// linkMetadata names the link from the pin set root to the metadata set.
+// It is only there when some pin has metadata.
+const linkMetadata = "metadata"
+
+// Metadata describes why a direct or recursive pin exists, and until
+// when.
+type Metadata struct {
+	Name   string            `json:",omitempty"`
+	Labels map[string]string `json:",omitempty"`
+	// Expires is when the pin should be removed, if ever.
+	Expires *time.Time `json:",omitempty"`
+}
+
+// IsEmpty returns whether m carries no name, labels or expiry.
+func (m Metadata) IsEmpty() bool {
+	return m.Name == "" && len(m.Labels) == 0 && m.Expires == nil
+}
+
+// Expired returns whether the pin has an expiry no later than now.
+func (m Metadata) Expired(now time.Time) bool {
+	return m.Expires != nil && !m.Expires.After(now)
+}
+
+// Matches returns whether m has the given name, unless name is empty, and
+// every label in labels.
+func (m Metadata) Matches(name string, labels map[string]string) bool {
+	if name != "" && m.Name != name {
+		return false
+	}
+	for l, v := range labels {
+		if mv, ok := m.Labels[l]; !ok || mv != v {
+			return false
+		}
+	}
+	return true
+}
+
+func (m Metadata) copy() Metadata {
+	out := Metadata{Name: m.Name}
+	if m.Expires != nil {
+		expires := *m.Expires
+		out.Expires = &expires
+	}
+	if len(m.Labels) > 0 {
+		out.Labels = make(map[string]string, len(m.Labels))
+		for l, v := range m.Labels {
+			out.Labels[l] = v
+		}
+	}
+	return out
+}
+
+// The metadata is stored as a data set of the pins that have any, holding
+// the JSON encoding of the metadata of each.
+
+func metadataItems(meta map[util.Key]Metadata) ([]setItem, error) {
+	items := make([]setItem, 0, len(meta))
+	for k, m := range meta {
+		data, err := json.Marshal(m)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, setItem{key: k, data: data})
+	}
+	return items, nil
+}
+
+func loadMetadata(items []setItem, meta map[util.Key]Metadata) error {
+	for _, it := range items {
+		var m Metadata
+		if err := json.Unmarshal(it.data, &m); err != nil {
+			return err
+		}
+		meta[it.key] = m
+	}
+	return nil
+}
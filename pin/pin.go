@@ -3,13 +3,13 @@
 package pin
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
 	"sync"
+	"time"
 
 	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
-	nsds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/namespace"
+	mh "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-multihash"
 	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
 	"github.com/ipfs/go-ipfs/blocks/set"
 	mdag "github.com/ipfs/go-ipfs/merkledag"
@@ -17,9 +17,17 @@ import (
 )
 
 var log = util.Logger("pin")
-var recursePinDatastoreKey = ds.NewKey("/local/pins/recursive/keys")
-var directPinDatastoreKey = ds.NewKey("/local/pins/direct/keys")
-var indirectPinDatastoreKey = ds.NewKey("/local/pins/indirect/keys")
+
+// DatastoreKey holds the key of the object recording the pin sets. The
+// object links to the sets under the names below, see set.go for their
+// format.
+var DatastoreKey = ds.NewKey("/local/pins")
+
+const (
+	linkDirect    = "direct"
+	linkRecursive = "recursive"
+	linkIndirect  = "indirect"
+)
 
 type PinMode int
 
@@ -34,11 +42,22 @@ type Pinner interface {
 	IsPinned(util.Key) bool
 	Pin(context.Context, *mdag.Node, bool) error
 	Unpin(context.Context, util.Key, bool) error
+	Update(ctx context.Context, from, to util.Key, unpin bool) error
 	Flush() error
 	GetManual() ManualPinner
 	DirectKeys() []util.Key
 	IndirectKeys() map[util.Key]int
 	RecursiveKeys() []util.Key
+	InternalKeys() []util.Key
+	// Metadata returns the metadata of the direct or recursive pin on
+	// the given key, if it has any.
+	Metadata(util.Key) (Metadata, bool)
+	// SetMetadata replaces the metadata of the direct or recursive pin
+	// on the given key. Empty metadata removes it.
+	SetMetadata(util.Key, Metadata) error
+	// Expired returns the keys of the direct and recursive pins that
+	// expired at the given time.
+	Expired(time.Time) []util.Key
 }
 
 // ManualPinner is for manually editing the pin structure
@@ -56,27 +75,32 @@ type pinner struct {
 	recursePin set.BlockSet
 	directPin  set.BlockSet
 	indirPin   *indirectPin
-	dserv      mdag.DAGService
-	dstore     ds.ThreadSafeDatastore
+	meta       map[util.Key]Metadata
+	// the objects holding the pin sets, as last flushed or loaded
+	internalPin map[util.Key]struct{}
+	// the trees the sets were last flushed or loaded as, by link name
+	stored map[string]*setTree
+	// the keys whose pins changed since the last flush, by set link name
+	changed map[string]map[util.Key]struct{}
+	// whether the sets changed since the last flush
+	dirty  bool
+	dserv  mdag.DAGService
+	dstore ds.ThreadSafeDatastore
 }
 
 // NewPinner creates a new pinner using the given datastore as a backend
 func NewPinner(dstore ds.ThreadSafeDatastore, serv mdag.DAGService) Pinner {
-
-	// Load set from given datastore...
-	rcds := nsds.Wrap(dstore, recursePinDatastoreKey)
-	rcset := set.NewDBWrapperSet(rcds, set.NewSimpleBlockSet())
-
-	dirds := nsds.Wrap(dstore, directPinDatastoreKey)
-	dirset := set.NewDBWrapperSet(dirds, set.NewSimpleBlockSet())
-
-	nsdstore := nsds.Wrap(dstore, indirectPinDatastoreKey)
 	return &pinner{
-		recursePin: rcset,
-		directPin:  dirset,
-		indirPin:   NewIndirectPin(nsdstore),
-		dserv:      serv,
-		dstore:     dstore,
+		recursePin:  set.NewSimpleBlockSet(),
+		directPin:   set.NewSimpleBlockSet(),
+		indirPin:    newIndirectPin(),
+		meta:        make(map[util.Key]Metadata),
+		internalPin: make(map[util.Key]struct{}),
+		stored:      make(map[string]*setTree),
+		changed:     make(map[string]map[util.Key]struct{}),
+		dirty:       true,
+		dserv:       serv,
+		dstore:      dstore,
 	}
 }
 
@@ -88,6 +112,7 @@ func (p *pinner) Pin(ctx context.Context, node *mdag.Node, recurse bool) error {
 	if err != nil {
 		return err
 	}
+	p.dirty = true
 
 	if recurse {
 		if p.recursePin.HasKey(k) {
@@ -96,6 +121,7 @@ func (p *pinner) Pin(ctx context.Context, node *mdag.Node, recurse bool) error {
 
 		if p.directPin.HasKey(k) {
 			p.directPin.RemoveBlock(k)
+			p.touch(linkDirect, k)
 		}
 
 		err := p.pinLinks(ctx, node)
@@ -104,6 +130,7 @@ func (p *pinner) Pin(ctx context.Context, node *mdag.Node, recurse bool) error {
 		}
 
 		p.recursePin.AddBlock(k)
+		p.touch(linkRecursive, k)
 	} else {
 		_, err := p.dserv.Get(ctx, k)
 		if err != nil {
@@ -115,6 +142,7 @@ func (p *pinner) Pin(ctx context.Context, node *mdag.Node, recurse bool) error {
 		}
 
 		p.directPin.AddBlock(k)
+		p.touch(linkDirect, k)
 	}
 	return nil
 }
@@ -123,9 +151,12 @@ func (p *pinner) Pin(ctx context.Context, node *mdag.Node, recurse bool) error {
 func (p *pinner) Unpin(ctx context.Context, k util.Key, recursive bool) error {
 	p.lock.Lock()
 	defer p.lock.Unlock()
+	p.dirty = true
 	if p.recursePin.HasKey(k) {
 		if recursive {
 			p.recursePin.RemoveBlock(k)
+			p.touch(linkRecursive, k)
+			p.deleteMetadata(k)
 			node, err := p.dserv.Get(ctx, k)
 			if err != nil {
 				return err
@@ -137,6 +168,8 @@ func (p *pinner) Unpin(ctx context.Context, k util.Key, recursive bool) error {
 		}
 	} else if p.directPin.HasKey(k) {
 		p.directPin.RemoveBlock(k)
+		p.touch(linkDirect, k)
+		p.deleteMetadata(k)
 		return nil
 	} else if p.indirPin.HasKey(k) {
 		return fmt.Errorf("%s is pinned indirectly. indirect pins cannot be removed directly", k)
@@ -145,6 +178,187 @@ func (p *pinner) Unpin(ctx context.Context, k util.Key, recursive bool) error {
 	}
 }
 
+// Update moves the recursive pin on from to to, keeping the pin on from
+// unless unpin is set. Subtrees shared by both DAGs are skipped, so only
+// the changed parts are fetched and have their indirect pins updated.
+// Nothing is changed if it fails.
+func (p *pinner) Update(ctx context.Context, from, to util.Key, unpin bool) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if !p.recursePin.HasKey(from) {
+		return fmt.Errorf("%s is not pinned recursively", from)
+	}
+	if from == to {
+		return nil
+	}
+
+	// changes to the indirect refcounts, applied once everything needed
+	// was fetched
+	refs := make(map[util.Key]int)
+	switch {
+	case p.recursePin.HasKey(to):
+		if unpin {
+			fromNode, err := p.dserv.Get(ctx, from)
+			if err != nil {
+				return err
+			}
+			if err := p.countLinks(ctx, fromNode, -1, refs); err != nil {
+				return err
+			}
+		}
+	case unpin:
+		fromNode, err := p.dserv.Get(ctx, from)
+		if err != nil {
+			return err
+		}
+		toNode, err := p.dserv.Get(ctx, to)
+		if err != nil {
+			return err
+		}
+		if err := p.diffLinks(ctx, fromNode, toNode, refs); err != nil {
+			return err
+		}
+	default:
+		toNode, err := p.dserv.Get(ctx, to)
+		if err != nil {
+			return err
+		}
+		if err := p.countLinks(ctx, toNode, 1, refs); err != nil {
+			return err
+		}
+	}
+
+	p.dirty = true
+	for k, delta := range refs {
+		p.indirPin.adjust(k, delta)
+		p.touch(linkIndirect, k)
+	}
+	if unpin {
+		p.recursePin.RemoveBlock(from)
+		p.touch(linkRecursive, from)
+		if m, ok := p.meta[from]; ok {
+			if _, ok := p.meta[to]; !ok {
+				p.meta[to] = m
+				p.touch(linkMetadata, to)
+			}
+			p.deleteMetadata(from)
+		}
+	}
+	p.directPin.RemoveBlock(to)
+	p.touch(linkDirect, to)
+	p.recursePin.AddBlock(to)
+	p.touch(linkRecursive, to)
+	return nil
+}
+
+// countLinks adds sign to refs for every descendant of node, once per path
+// to it, the way pinning node recursively counts them.
+func (p *pinner) countLinks(ctx context.Context, node *mdag.Node, sign int, refs map[util.Key]int) error {
+	for _, l := range node.Links {
+		refs[util.Key(l.Hash)] += sign
+		child, err := l.GetNode(ctx, p.dserv)
+		if err != nil {
+			return err
+		}
+		if err := p.countLinks(ctx, child, sign, refs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// diffLinks records in refs the refcount changes of replacing the
+// descendants of a with those of b. Links to the same object in both cancel
+// out. The remaining ones are paired up by name, then in order, and each
+// pair is diffed in turn; links left over are counted in full.
+func (p *pinner) diffLinks(ctx context.Context, a, b *mdag.Node, refs map[util.Key]int) error {
+	unmatched := make(map[util.Key]int)
+	for _, l := range b.Links {
+		unmatched[util.Key(l.Hash)]++
+	}
+	var removed, added []*mdag.Link
+	for _, l := range a.Links {
+		k := util.Key(l.Hash)
+		if unmatched[k] > 0 {
+			unmatched[k]--
+			continue
+		}
+		removed = append(removed, l)
+	}
+	for _, l := range b.Links {
+		k := util.Key(l.Hash)
+		if unmatched[k] > 0 {
+			unmatched[k]--
+			added = append(added, l)
+		}
+	}
+
+	byName := make(map[string]int)
+	for i, l := range added {
+		if _, ok := byName[l.Name]; !ok && l.Name != "" {
+			byName[l.Name] = i
+		}
+	}
+	paired := make([]*mdag.Link, len(removed))
+	used := make([]bool, len(added))
+	for i, l := range removed {
+		if j, ok := byName[l.Name]; ok && !used[j] {
+			paired[i] = added[j]
+			used[j] = true
+		}
+	}
+	next := 0
+	for i := range removed {
+		if paired[i] != nil {
+			continue
+		}
+		for next < len(added) && used[next] {
+			next++
+		}
+		if next < len(added) {
+			paired[i] = added[next]
+			used[next] = true
+		}
+	}
+
+	for i, l := range removed {
+		refs[util.Key(l.Hash)]--
+		an, err := l.GetNode(ctx, p.dserv)
+		if err != nil {
+			return err
+		}
+		if paired[i] == nil {
+			if err := p.countLinks(ctx, an, -1, refs); err != nil {
+				return err
+			}
+			continue
+		}
+		refs[util.Key(paired[i].Hash)]++
+		bn, err := paired[i].GetNode(ctx, p.dserv)
+		if err != nil {
+			return err
+		}
+		if err := p.diffLinks(ctx, an, bn, refs); err != nil {
+			return err
+		}
+	}
+	for j, l := range added {
+		if used[j] {
+			continue
+		}
+		refs[util.Key(l.Hash)]++
+		bn, err := l.GetNode(ctx, p.dserv)
+		if err != nil {
+			return err
+		}
+		if err := p.countLinks(ctx, bn, 1, refs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (p *pinner) unpinLinks(ctx context.Context, node *mdag.Node) error {
 	for _, l := range node.Links {
 		node, err := l.GetNode(ctx, p.dserv)
@@ -158,6 +372,7 @@ func (p *pinner) unpinLinks(ctx context.Context, node *mdag.Node) error {
 		}
 
 		p.indirPin.Decrement(k)
+		p.touch(linkIndirect, k)
 
 		err = p.unpinLinks(ctx, node)
 		if err != nil {
@@ -174,6 +389,7 @@ func (p *pinner) pinIndirectRecurse(ctx context.Context, node *mdag.Node) error
 	}
 
 	p.indirPin.Increment(k)
+	p.touch(linkIndirect, k)
 	return p.pinLinks(ctx, node)
 }
 
@@ -192,63 +408,100 @@ func (p *pinner) pinLinks(ctx context.Context, node *mdag.Node) error {
 	return nil
 }
 
-// IsPinned returns whether or not the given key is pinned
+// IsPinned returns whether or not the given key is pinned. The objects
+// holding the pin sets count as pinned.
 func (p *pinner) IsPinned(key util.Key) bool {
 	p.lock.RLock()
 	defer p.lock.RUnlock()
+	_, internal := p.internalPin[key]
 	return p.recursePin.HasKey(key) ||
 		p.directPin.HasKey(key) ||
-		p.indirPin.HasKey(key)
+		p.indirPin.HasKey(key) ||
+		internal
 }
 
 func (p *pinner) RemovePinWithMode(key util.Key, mode PinMode) {
 	p.lock.Lock()
 	defer p.lock.Unlock()
+	p.dirty = true
 	switch mode {
 	case Direct:
 		p.directPin.RemoveBlock(key)
+		p.touch(linkDirect, key)
 	case Indirect:
 		p.indirPin.Decrement(key)
+		p.touch(linkIndirect, key)
 	case Recursive:
 		p.recursePin.RemoveBlock(key)
+		p.touch(linkRecursive, key)
 	default:
 		// programmer error, panic OK
 		panic("unrecognized pin type")
 	}
+	if !p.recursePin.HasKey(key) && !p.directPin.HasKey(key) {
+		p.deleteMetadata(key)
+	}
 }
 
-// LoadPinner loads a pinner and its keysets from the given datastore
-func LoadPinner(d ds.ThreadSafeDatastore, dserv mdag.DAGService) (Pinner, error) {
-	p := new(pinner)
+// LoadPinner loads a pinner and its keysets from the given datastore. The
+// pin set objects are read through internal, with no deadline, so it
+// should only read local blocks; missing objects then fail right away.
+func LoadPinner(d ds.ThreadSafeDatastore, dserv, internal mdag.DAGService) (Pinner, error) {
+	p := NewPinner(d, dserv).(*pinner)
 
-	{ // load recursive set
-		var recurseKeys []util.Key
-		if err := loadSet(d, recursePinDatastoreKey, &recurseKeys); err != nil {
-			return nil, err
-		}
-		p.recursePin = set.SimpleSetFromKeys(recurseKeys)
+	v, err := d.Get(DatastoreKey)
+	if err != nil {
+		return nil, err
 	}
-
-	{ // load direct set
-		var directKeys []util.Key
-		if err := loadSet(d, directPinDatastoreKey, &directKeys); err != nil {
-			return nil, err
-		}
-		p.directPin = set.SimpleSetFromKeys(directKeys)
+	rootKey, ok := v.([]byte)
+	if !ok {
+		return nil, errors.New("invalid pin set root in datastore")
 	}
 
-	{ // load indirect set
-		var err error
-		p.indirPin, err = loadIndirPin(d, indirectPinDatastoreKey)
+	ctx := context.TODO()
+	root, err := internal.Get(ctx, util.Key(rootKey))
+	if err != nil {
+		return nil, fmt.Errorf("cannot find pin set root: %s", err)
+	}
+	p.internalPin[util.Key(rootKey)] = struct{}{}
+
+	var metaItems []setItem
+	sets := []struct {
+		name     string
+		kind     setKind
+		add      func(setItem)
+		optional bool
+	}{
+		{linkRecursive, keySet, func(it setItem) { p.recursePin.AddBlock(it.key) }, false},
+		{linkDirect, keySet, func(it setItem) { p.directPin.AddBlock(it.key) }, false},
+		{linkIndirect, countSet, func(it setItem) { p.indirPin.add(it.key, it.count) }, false},
+		{linkMetadata, dataSet, func(it setItem) { metaItems = append(metaItems, it) }, true},
+	}
+	for _, s := range sets {
+		l, err := root.GetNodeLink(s.name)
+		if err != nil {
+			if s.optional {
+				continue
+			}
+			return nil, fmt.Errorf("pin set root: %s", err)
+		}
+		k := util.Key(l.Hash)
+		nd, err := internal.Get(ctx, k)
+		if err != nil {
+			return nil, fmt.Errorf("cannot find %s pin set: %s", s.name, err)
+		}
+		t, err := loadSet(ctx, internal, nd, k, s.kind, s.add)
 		if err != nil {
 			return nil, err
 		}
+		t.walk(func(k util.Key) { p.internalPin[k] = struct{}{} })
+		p.stored[s.name] = t
+	}
+	if err := loadMetadata(metaItems, p.meta); err != nil {
+		return nil, fmt.Errorf("invalid pin metadata: %s", err)
 	}
 
-	// assign services
-	p.dserv = dserv
-	p.dstore = d
-
+	p.dirty = false
 	return p, nil
 }
 
@@ -267,49 +520,131 @@ func (p *pinner) RecursiveKeys() []util.Key {
 	return p.recursePin.GetKeys()
 }
 
-// Flush encodes and writes pinner keysets to the datastore
+// InternalKeys returns the keys of the objects holding the pin sets, as
+// of the last Flush
+func (p *pinner) InternalKeys() []util.Key {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	var out []util.Key
+	for k := range p.internalPin {
+		out = append(out, k)
+	}
+	return out
+}
+
+// Flush stores the pin sets as merkledag objects, and records the root of
+// those in the datastore. It does nothing if no pin changed. Only the set
+// nodes on the path to a changed key are stored again, the others are kept
+// from the last flush or load. All pins are still kept in memory, and
+// LoadPinner reads all of them.
 func (p *pinner) Flush() error {
 	p.lock.Lock()
 	defer p.lock.Unlock()
 
-	err := storeSet(p.dstore, directPinDatastoreKey, p.directPin.GetKeys())
-	if err != nil {
-		return err
+	if !p.dirty {
+		return nil
 	}
 
-	err = storeSet(p.dstore, recursePinDatastoreKey, p.recursePin.GetKeys())
+	type storedSet struct {
+		name  string
+		kind  setKind
+		items func() ([]setItem, error)
+	}
+	sets := []storedSet{
+		{linkRecursive, keySet, func() ([]setItem, error) { return keyItems(p.recursePin.GetKeys()), nil }},
+		{linkDirect, keySet, func() ([]setItem, error) { return keyItems(p.directPin.GetKeys()), nil }},
+		{linkIndirect, countSet, func() ([]setItem, error) { return p.indirPin.items(), nil }},
+	}
+	if len(p.meta) > 0 {
+		sets = append(sets, storedSet{linkMetadata, dataSet, func() ([]setItem, error) { return metadataItems(p.meta) }})
+	}
+
+	root := new(mdag.Node)
+	stored := make(map[string]*setTree)
+	for _, s := range sets {
+		t := p.stored[s.name]
+		if changed := p.changed[s.name]; t == nil || len(changed) > 0 {
+			items, err := s.items()
+			if err != nil {
+				return err
+			}
+			keys := make([]util.Key, 0, len(changed))
+			for k := range changed {
+				keys = append(keys, k)
+			}
+			t, err = storeSet(p.dserv, items, keys, t, s.kind, 0)
+			if err != nil {
+				return err
+			}
+		}
+		stored[s.name] = t
+		root.Links = append(root.Links, &mdag.Link{Name: s.name, Hash: mh.Multihash(t.key), Size: t.size})
+	}
+
+	k, err := p.dserv.Add(root)
 	if err != nil {
 		return err
 	}
 
-	err = storeIndirPin(p.dstore, indirectPinDatastoreKey, p.indirPin)
-	if err != nil {
+	if err := p.dstore.Put(DatastoreKey, []byte(k)); err != nil {
 		return err
 	}
+	internal := map[util.Key]struct{}{k: struct{}{}}
+	for _, t := range stored {
+		t.walk(func(k util.Key) { internal[k] = struct{}{} })
+	}
+	p.internalPin = internal
+	p.stored = stored
+	p.changed = make(map[string]map[util.Key]struct{})
+	p.dirty = false
 	return nil
 }
 
-// helpers to marshal / unmarshal a pin set
-func storeSet(d ds.Datastore, k ds.Key, val interface{}) error {
-	buf, err := json.Marshal(val)
-	if err != nil {
-		return err
-	}
+// Metadata returns the metadata of the direct or recursive pin on k
+func (p *pinner) Metadata(k util.Key) (Metadata, bool) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	m, ok := p.meta[k]
+	return m.copy(), ok
+}
 
-	return d.Put(k, buf)
+// SetMetadata replaces the metadata of the direct or recursive pin on k
+func (p *pinner) SetMetadata(k util.Key, m Metadata) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if !p.recursePin.HasKey(k) && !p.directPin.HasKey(k) {
+		return fmt.Errorf("%s is not pinned directly or recursively", k)
+	}
+	p.dirty = true
+	if m.IsEmpty() {
+		p.deleteMetadata(k)
+	} else {
+		p.meta[k] = m.copy()
+		p.touch(linkMetadata, k)
+	}
+	return nil
 }
 
-func loadSet(d ds.Datastore, k ds.Key, val interface{}) error {
-	buf, err := d.Get(k)
-	if err != nil {
-		return err
+// Expired returns the keys of the direct and recursive pins that expired
+// at now
+func (p *pinner) Expired(now time.Time) []util.Key {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	var out []util.Key
+	for k, m := range p.meta {
+		if m.Expired(now) {
+			out = append(out, k)
+		}
 	}
+	return out
+}
 
-	bf, ok := buf.([]byte)
-	if !ok {
-		return errors.New("invalid pin set value in datastore")
+func keyItems(keys []util.Key) []setItem {
+	items := make([]setItem, len(keys))
+	for i, k := range keys {
+		items[i] = setItem{key: k, count: 1}
 	}
-	return json.Unmarshal(bf, val)
+	return items
 }
 
 // PinWithMode is a method on ManualPinners, allowing the user to have fine
@@ -317,13 +652,36 @@ func loadSet(d ds.Datastore, k ds.Key, val interface{}) error {
 func (p *pinner) PinWithMode(k util.Key, mode PinMode) {
 	p.lock.Lock()
 	defer p.lock.Unlock()
+	p.dirty = true
 	switch mode {
 	case Recursive:
 		p.recursePin.AddBlock(k)
+		p.touch(linkRecursive, k)
 	case Direct:
 		p.directPin.AddBlock(k)
+		p.touch(linkDirect, k)
 	case Indirect:
 		p.indirPin.Increment(k)
+		p.touch(linkIndirect, k)
+	}
+}
+
+// touch records that the pin of k in the set linked as set changed, so
+// that the next Flush stores the bucket holding k again.
+func (p *pinner) touch(set string, k util.Key) {
+	keys, ok := p.changed[set]
+	if !ok {
+		keys = make(map[util.Key]struct{})
+		p.changed[set] = keys
+	}
+	keys[k] = struct{}{}
+}
+
+// deleteMetadata removes the metadata of the pin on k, if it has any.
+func (p *pinner) deleteMetadata(k util.Key) {
+	if _, ok := p.meta[k]; ok {
+		delete(p.meta, k)
+		p.touch(linkMetadata, k)
 	}
 }
 
@@ -137,7 +137,7 @@ func TestPinnerBasic(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	np, err := LoadPinner(dstore, dserv)
+	np, err := LoadPinner(dstore, dserv, dserv)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -236,3 +236,222 @@ func TestPinRecursiveFail(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestUpdate(t *testing.T) {
+	ctx := context.Background()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	bstore := blockstore.NewBlockstore(dstore)
+	bserv, err := bs.New(bstore, offline.Exchange(bstore))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dserv := mdag.NewDAGService(bserv)
+
+	// from{x, y{z}, y{z}} and to{x, y'{z, w}, v}
+	x, _ := randNode()
+	z, _ := randNode()
+	w, _ := randNode()
+	v, _ := randNode()
+	y, _ := randNode()
+	if err := y.AddNodeLink("z", z); err != nil {
+		t.Fatal(err)
+	}
+	y2 := y.Copy()
+	if err := y2.AddNodeLink("w", w); err != nil {
+		t.Fatal(err)
+	}
+	from, _ := randNode()
+	to, _ := randNode()
+	for _, l := range []struct {
+		parent *mdag.Node
+		name   string
+		child  *mdag.Node
+	}{
+		{from, "x", x}, {from, "y", y}, {from, "y-again", y},
+		{to, "x", x}, {to, "y", y2}, {to, "v", v},
+	} {
+		if err := l.parent.AddNodeLink(l.name, l.child); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := dserv.AddRecursive(from); err != nil {
+		t.Fatal(err)
+	}
+	if err := dserv.AddRecursive(to); err != nil {
+		t.Fatal(err)
+	}
+	fromKey, _ := from.Key()
+	toKey, _ := to.Key()
+
+	// the refcounts of pinning the given roots recursively
+	expected := func(roots ...*mdag.Node) map[util.Key]int {
+		p := NewPinner(dstore, dserv)
+		for _, r := range roots {
+			if err := p.Pin(ctx, r, true); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return p.IndirectKeys()
+	}
+	check := func(p Pinner, exp map[util.Key]int) {
+		refs := p.IndirectKeys()
+		if len(refs) != len(exp) {
+			t.Fatalf("expected %d indirect pins, got %d", len(exp), len(refs))
+		}
+		for k, c := range exp {
+			if refs[k] != c {
+				t.Fatalf("expected refcount %d for %s, got %d", c, k, refs[k])
+			}
+		}
+	}
+
+	p := NewPinner(dstore, dserv)
+	if err := p.Update(ctx, fromKey, toKey, true); err == nil {
+		t.Fatal("expected update of an unpinned object to fail")
+	}
+
+	if err := p.Pin(ctx, from, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Update(ctx, fromKey, toKey, true); err != nil {
+		t.Fatal(err)
+	}
+	check(p, expected(to))
+	if p.IsPinned(fromKey) || !p.IsPinned(toKey) {
+		t.Fatal("expected the pin to move from the old root to the new one")
+	}
+
+	p = NewPinner(dstore, dserv)
+	if err := p.Pin(ctx, from, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Update(ctx, fromKey, toKey, false); err != nil {
+		t.Fatal(err)
+	}
+	check(p, expected(from, to))
+	if !p.IsPinned(fromKey) || !p.IsPinned(toKey) {
+		t.Fatal("expected both roots to be pinned")
+	}
+
+	// and back, with the new root already pinned
+	if err := p.Update(ctx, toKey, fromKey, true); err != nil {
+		t.Fatal(err)
+	}
+	check(p, expected(from))
+}
+
+func TestMetadata(t *testing.T) {
+	ctx := context.Background()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	bstore := blockstore.NewBlockstore(dstore)
+	bserv, err := bs.New(bstore, offline.Exchange(bstore))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dserv := mdag.NewDAGService(bserv)
+	p := NewPinner(dstore, dserv)
+
+	a, ak := randNode()
+	b, bk := randNode()
+	if err := dserv.AddRecursive(a); err != nil {
+		t.Fatal(err)
+	}
+	if err := dserv.AddRecursive(b); err != nil {
+		t.Fatal(err)
+	}
+
+	meta := Metadata{Name: "site", Labels: map[string]string{"env": "prod"}}
+	if err := p.SetMetadata(ak, meta); err == nil {
+		t.Fatal("expected metadata on an unpinned key to fail")
+	}
+	if err := p.Pin(ctx, a, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetMetadata(ak, meta); err != nil {
+		t.Fatal(err)
+	}
+	meta.Labels["env"] = "changed"
+
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	np, err := LoadPinner(dstore, dserv, dserv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, ok := np.Metadata(ak)
+	if !ok || m.Name != "site" || m.Labels["env"] != "prod" {
+		t.Fatal("metadata was not kept:", m)
+	}
+	if !m.Matches("site", map[string]string{"env": "prod"}) || m.Matches("", map[string]string{"env": "dev"}) {
+		t.Fatal("metadata matching is wrong")
+	}
+
+	// the metadata follows the pin when it is moved
+	if err := np.Update(ctx, ak, bk, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := np.Metadata(ak); ok {
+		t.Fatal("old pin kept its metadata")
+	}
+	if m, ok := np.Metadata(bk); !ok || m.Name != "site" {
+		t.Fatal("metadata did not move to the new pin")
+	}
+
+	if err := np.Unpin(ctx, bk, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := np.Metadata(bk); ok {
+		t.Fatal("unpinning should drop the metadata")
+	}
+}
+
+func TestExpired(t *testing.T) {
+	ctx := context.Background()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	bstore := blockstore.NewBlockstore(dstore)
+	bserv, err := bs.New(bstore, offline.Exchange(bstore))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dserv := mdag.NewDAGService(bserv)
+	p := NewPinner(dstore, dserv)
+
+	now := time.Now()
+	past, future := now.Add(-time.Minute), now.Add(time.Hour)
+	a, ak := randNode()
+	b, bk := randNode()
+	for _, n := range []*mdag.Node{a, b} {
+		if _, err := dserv.Add(n); err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Pin(ctx, n, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.SetMetadata(ak, Metadata{Expires: &past}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetMetadata(bk, Metadata{Expires: &future}); err != nil {
+		t.Fatal(err)
+	}
+
+	// expiry survives reloading the pins
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	np, err := LoadPinner(dstore, dserv, dserv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired := np.Expired(now)
+	if len(expired) != 1 || expired[0] != ak {
+		t.Fatal("expected only the first pin to have expired, got", expired)
+	}
+	if m, _ := np.Metadata(bk); m.Expires == nil || !m.Expires.Equal(future) {
+		t.Fatal("expiry was not kept")
+	}
+	if len(np.Expired(future)) != 2 {
+		t.Fatal("expected both pins to have expired")
+	}
+}
@@ -0,0 +1,245 @@
+package pin
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+
+	mh "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-multihash"
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+	mdag "github.com/ipfs/go-ipfs/merkledag"
+	"github.com/ipfs/go-ipfs/util"
+)
+
+// A pin set is stored as a tree of merkledag nodes. A set of at most
+// maxItems keys is a single node linking to the keys, in key order. A
+// larger set is split in defaultFanout buckets by a hash of the keys; each
+// bucket is stored as a set of its own, and linked from the parent under
+// the number of the bucket. Empty buckets are left out.
+//
+// The data of a set node is the uvarint format version, then the uvarint
+// fanout, which is zero for nodes listing keys. Nodes of multisets list
+// the count of each key after that, as uvarints in the order of the links,
+// and nodes of data sets the data of each key, as a uvarint length followed
+// by the data. Sets holding more than maxData bytes of data are split too.
+const (
+	setVersion    = 1
+	defaultFanout = 256
+	maxItems      = 8192
+	maxData       = 256 * 1024
+)
+
+// setKind says what a set stores along with each key.
+type setKind int
+
+const (
+	keySet   setKind = iota // nothing
+	countSet                // a count, for multisets
+	dataSet                 // arbitrary data
+)
+
+var errInvalidSet = errors.New("pin: invalid pin set object")
+
+type setItem struct {
+	key   util.Key
+	count int    // of countSets
+	data  []byte // of dataSets
+}
+
+type itemsByKey []setItem
+
+func (s itemsByKey) Len() int           { return len(s) }
+func (s itemsByKey) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s itemsByKey) Less(i, j int) bool { return s[i].key < s[j].key }
+
+// bucket returns the bucket of k in a set node at the given depth. The
+// depth seeds the hash, so that keys sharing a bucket are spread out at
+// the next level.
+func bucket(depth int, k util.Key) int {
+	h := fnv.New32a()
+	var seed [4]byte
+	binary.BigEndian.PutUint32(seed[:], uint32(depth))
+	h.Write(seed[:])
+	h.Write([]byte(k))
+	return int(h.Sum32() % defaultFanout)
+}
+
+func setHeader(fanout int) []byte {
+	buf := make([]byte, 2*binary.MaxVarintLen64)
+	n := binary.PutUvarint(buf, setVersion)
+	n += binary.PutUvarint(buf[n:], uint64(fanout))
+	return buf[:n]
+}
+
+func dataSize(items []setItem) int {
+	size := 0
+	for _, it := range items {
+		size += len(it.data)
+	}
+	return size
+}
+
+// setTree records the nodes a set was stored as, so that storing it again
+// only has to store the buckets whose keys changed.
+type setTree struct {
+	key     util.Key
+	size    uint64
+	buckets map[int]*setTree // nil for nodes listing keys
+}
+
+// walk calls f with the key of every node of t.
+func (t *setTree) walk(f func(util.Key)) {
+	f(t.key)
+	for _, b := range t.buckets {
+		b.walk(f)
+	}
+}
+
+// storeSet adds the set nodes holding items to dserv, and returns their
+// tree. old is the tree the set was last stored as, or nil; the buckets of
+// it that hold none of the changed keys are kept as they are. What is
+// stored along with the keys depends on kind.
+func storeSet(dserv mdag.DAGService, items []setItem, changed []util.Key, old *setTree, kind setKind, depth int) (*setTree, error) {
+	if old != nil && len(changed) == 0 {
+		return old, nil
+	}
+
+	n := new(mdag.Node)
+	var buckets map[int]*setTree
+	if len(items) <= 1 || len(items) <= maxItems && dataSize(items) <= maxData {
+		sort.Sort(itemsByKey(items))
+		data := bytes.NewBuffer(setHeader(0))
+		var uvarint [binary.MaxVarintLen64]byte
+		for _, it := range items {
+			n.Links = append(n.Links, &mdag.Link{Hash: mh.Multihash(it.key)})
+			switch kind {
+			case countSet:
+				data.Write(uvarint[:binary.PutUvarint(uvarint[:], uint64(it.count))])
+			case dataSet:
+				data.Write(uvarint[:binary.PutUvarint(uvarint[:], uint64(len(it.data)))])
+				data.Write(it.data)
+			}
+		}
+		n.Data = data.Bytes()
+	} else {
+		itemBuckets := make([][]setItem, defaultFanout)
+		for _, it := range items {
+			b := bucket(depth, it.key)
+			itemBuckets[b] = append(itemBuckets[b], it)
+		}
+		changedBuckets := make([][]util.Key, defaultFanout)
+		for _, k := range changed {
+			b := bucket(depth, k)
+			changedBuckets[b] = append(changedBuckets[b], k)
+		}
+
+		n.Data = setHeader(defaultFanout)
+		buckets = make(map[int]*setTree)
+		for i, b := range itemBuckets {
+			if len(b) == 0 {
+				continue
+			}
+			var oldChild *setTree
+			if old != nil {
+				oldChild = old.buckets[i]
+			}
+			child, err := storeSet(dserv, b, changedBuckets[i], oldChild, kind, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			buckets[i] = child
+			n.Links = append(n.Links, &mdag.Link{
+				Name: strconv.Itoa(i),
+				Hash: mh.Multihash(child.key),
+				Size: child.size,
+			})
+		}
+	}
+
+	k, err := dserv.Add(n)
+	if err != nil {
+		return nil, err
+	}
+	size, err := n.Size()
+	if err != nil {
+		return nil, err
+	}
+	return &setTree{key: k, size: size, buckets: buckets}, nil
+}
+
+// loadSet walks the set rooted at n, whose key is k, calling add with every
+// item of the set, and returns the tree of its nodes. The count of items
+// is 1 unless kind is countSet.
+func loadSet(ctx context.Context, dserv mdag.DAGService, n *mdag.Node, k util.Key, kind setKind, add func(setItem)) (*setTree, error) {
+	size, err := n.Size()
+	if err != nil {
+		return nil, err
+	}
+	t := &setTree{key: k, size: size}
+
+	data := n.Data
+	version, l := binary.Uvarint(data)
+	if l <= 0 {
+		return nil, errInvalidSet
+	}
+	if version != setVersion {
+		return nil, fmt.Errorf("pin: unsupported pin set version %d", version)
+	}
+	data = data[l:]
+	fanout, l := binary.Uvarint(data)
+	if l <= 0 {
+		return nil, errInvalidSet
+	}
+	data = data[l:]
+
+	if fanout == 0 {
+		for _, link := range n.Links {
+			it := setItem{key: util.Key(link.Hash), count: 1}
+			if kind != keySet {
+				c, l := binary.Uvarint(data)
+				if l <= 0 {
+					return nil, errInvalidSet
+				}
+				data = data[l:]
+				if kind == countSet {
+					it.count = int(c)
+				} else {
+					if uint64(len(data)) < c {
+						return nil, errInvalidSet
+					}
+					it.data, data = data[:c], data[c:]
+				}
+			}
+			add(it)
+		}
+		return t, nil
+	}
+
+	// the buckets are only kept for storing again if they were chosen the
+	// way storeSet does
+	buckets := make(map[int]*setTree)
+	for _, link := range n.Links {
+		i, err := strconv.Atoi(link.Name)
+		if err != nil || i < 0 || uint64(i) >= fanout {
+			return nil, errInvalidSet
+		}
+		ck := util.Key(link.Hash)
+		child, err := dserv.Get(ctx, ck)
+		if err != nil {
+			return nil, err
+		}
+		ct, err := loadSet(ctx, dserv, child, ck, kind, add)
+		if err != nil {
+			return nil, err
+		}
+		buckets[i] = ct
+	}
+	if fanout == defaultFanout {
+		t.buckets = buckets
+	}
+	return t, nil
+}
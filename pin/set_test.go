@@ -0,0 +1,175 @@
+package pin
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+
+	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
+	dssync "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/sync"
+	"github.com/ipfs/go-ipfs/blocks/blockstore"
+	bs "github.com/ipfs/go-ipfs/blockservice"
+	"github.com/ipfs/go-ipfs/exchange/offline"
+	mdag "github.com/ipfs/go-ipfs/merkledag"
+	"github.com/ipfs/go-ipfs/util"
+)
+
+func TestLargeSetRoundTrip(t *testing.T) {
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	bstore := blockstore.NewBlockstore(dstore)
+	bserv, err := bs.New(bstore, offline.Exchange(bstore))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dserv := mdag.NewDAGService(bserv)
+
+	// more keys than fit in one set node, so the direct and metadata sets
+	// are split
+	p := NewPinner(dstore, dserv).GetManual()
+	n := maxItems + 100
+	for i := 0; i < n; i++ {
+		k := util.Key(util.Hash([]byte(strconv.Itoa(i))))
+		p.PinWithMode(k, Direct)
+		if err := p.SetMetadata(k, Metadata{Name: strconv.Itoa(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ind := util.Key(util.Hash([]byte("indirect")))
+	for i := 0; i < 3; i++ {
+		p.PinWithMode(ind, Indirect)
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	np, err := LoadPinner(dstore, dserv, dserv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(np.DirectKeys()) != n {
+		t.Fatalf("expected %d direct pins, got %d", n, len(np.DirectKeys()))
+	}
+	for i := 0; i < n; i++ {
+		k := util.Key(util.Hash([]byte(strconv.Itoa(i))))
+		if !np.IsPinned(k) {
+			t.Fatal("direct pin was lost:", i)
+		}
+		if m, ok := np.Metadata(k); !ok || m.Name != strconv.Itoa(i) {
+			t.Fatal("pin metadata was lost:", i)
+		}
+	}
+	if refs := np.IndirectKeys()[ind]; refs != 3 {
+		t.Fatalf("expected 3 indirect refs, got %d", refs)
+	}
+
+	// the root, the four sets, and the buckets of the direct and metadata
+	// sets
+	internal := np.InternalKeys()
+	if len(internal) <= 5+defaultFanout {
+		t.Fatal("expected the direct and metadata sets to be split, got", len(internal), "internal keys")
+	}
+	for _, k := range internal {
+		if !np.IsPinned(k) {
+			t.Fatal("internal key not pinned:", k)
+		}
+		if _, err := dserv.Get(context.Background(), k); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// countingDAG counts the nodes added through it.
+type countingDAG struct {
+	mdag.DAGService
+	adds int
+}
+
+func (d *countingDAG) Add(nd *mdag.Node) (util.Key, error) {
+	d.adds++
+	return d.DAGService.Add(nd)
+}
+
+func TestFlushStoresChangedBuckets(t *testing.T) {
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	bstore := blockstore.NewBlockstore(dstore)
+	bserv, err := bs.New(bstore, offline.Exchange(bstore))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dserv := mdag.NewDAGService(bserv)
+
+	p := NewPinner(dstore, dserv).GetManual()
+	for i := 0; i < maxItems+100; i++ {
+		p.PinWithMode(util.Key(util.Hash([]byte(strconv.Itoa(i)))), Direct)
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the loaded pinner keeps the buckets it read, too
+	counted := &countingDAG{DAGService: dserv}
+	np, err := LoadPinner(dstore, counted, counted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	np.GetManual().PinWithMode(util.Key(util.Hash([]byte("one more"))), Direct)
+	if err := np.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	// the root, the direct set, and the bucket of the new key
+	if counted.adds != 3 {
+		t.Fatalf("expected 3 set nodes to be stored, got %d", counted.adds)
+	}
+
+	np, err = LoadPinner(dstore, dserv, dserv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(np.DirectKeys()) != maxItems+101 {
+		t.Fatalf("expected %d direct pins, got %d", maxItems+101, len(np.DirectKeys()))
+	}
+}
+
+func TestDataSetSplitBySize(t *testing.T) {
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	bstore := blockstore.NewBlockstore(dstore)
+	bserv, err := bs.New(bstore, offline.Exchange(bstore))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dserv := mdag.NewDAGService(bserv)
+
+	// few items, but too much data for one set node
+	var items []setItem
+	for i := 0; i < 4; i++ {
+		k := util.Key(util.Hash([]byte(strconv.Itoa(i))))
+		items = append(items, setItem{key: k, data: bytes.Repeat([]byte{byte(i)}, maxData/2)})
+	}
+	tree, err := storeSet(dserv, items, nil, nil, dataSet, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tree.buckets) == 0 {
+		t.Fatal("expected the set to be split")
+	}
+
+	root, err := dserv.Get(context.Background(), tree.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := make(map[util.Key][]byte)
+	add := func(it setItem) { loaded[it.key] = it.data }
+	if _, err := loadSet(context.Background(), dserv, root, tree.key, dataSet, add); err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != len(items) {
+		t.Fatalf("expected %d items, got %d", len(items), len(loaded))
+	}
+	for _, it := range items {
+		if !bytes.Equal(loaded[it.key], it.data) {
+			t.Fatal("wrong data loaded for", it.key)
+		}
+	}
+}
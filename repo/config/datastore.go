@@ -1,5 +1,9 @@
 package config
 
+import (
+	humanize "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/dustin/go-humanize"
+)
+
 // DefaultDataStoreDirectory is the directory to store all the local IPFS data.
 const DefaultDataStoreDirectory = "datastore"
 
@@ -7,6 +11,106 @@ const DefaultDataStoreDirectory = "datastore"
 type Datastore struct {
 	Type string
 	Path string
+
+	StorageMax         string // in B, kB, MB, GB, ... an empty string disables the limit
+	StorageGCWatermark int64  // in percentage of StorageMax, gc runs above it
+	GCPeriod           string // in ns, us, ms, s, m, h
+
+	BloomFilterSize int // in bytes, zero disables the blockstore bloom filter
+	ReadCacheSize   int // in bytes, zero disables the blockstore read cache
+	NodeCacheSize   int // in bytes, zero disables the decoded node cache
+
+	// Mounts lays out the repo datastore: each key is stored by the
+	// backend of the mount with the longest matching prefix. Empty means
+	// DefaultDatastoreMounts.
+	Mounts []DatastoreMount `json:",omitempty"`
+
+	// KeyFile holds the secret the key of Encrypted mounts is derived
+	// from. A relative path is relative to the repo root. Empty means the
+	// secret is read from the IPFS_DATASTORE_PASSPHRASE environment
+	// variable.
+	KeyFile string `json:",omitempty"`
+}
+
+// Datastore backend types usable in a DatastoreMount.
+const (
+	FlatfsDatastore  = "flatfs"
+	LeveldbDatastore = "leveldb"
+	MemDatastore     = "mem"
+	S3Datastore      = "s3"
+	RedisDatastore   = "redis"
+)
+
+// DatastoreMount configures the backend storing the keys below Prefix.
+// Only the fields of the chosen Type are used.
+type DatastoreMount struct {
+	Prefix string // "/" holds every key not under another mount
+	Type   string
+
+	// Encrypted encrypts the stored values; keys are stored as they are.
+	Encrypted bool `json:",omitempty"`
+
+	// flatfs, leveldb: directory, relative to the repo root
+	Path string `json:",omitempty"`
+
+	// flatfs: number of key bytes used to pick a subdirectory
+	ShardPrefixLen int `json:",omitempty"`
+
+	// leveldb: "none" (the default) or "snappy"
+	Compression string `json:",omitempty"`
+
+	// s3: credentials are taken from the AWS_* environment variables
+	Region string `json:",omitempty"`
+	Bucket string `json:",omitempty"`
+
+	// redis: host:port of the server, and the expiry of stored keys in
+	// ns, us, ms, s, m, h (empty never expires them)
+	Addr string `json:",omitempty"`
+	TTL  string `json:",omitempty"`
+}
+
+// DefaultDatastoreMounts returns the layout of repos that do not configure
+// Datastore.Mounts: blocks in flatfs, everything else in leveldb.
+func DefaultDatastoreMounts() []DatastoreMount {
+	return []DatastoreMount{
+		{
+			Prefix: "/blocks",
+			Type:   FlatfsDatastore,
+			Path:   "blocks",
+			// 4TB of 256kB objects ~=17M objects, splitting that 256-way
+			// leads to ~66k objects per dir, splitting 256*256-way leads to
+			// only 256.
+			//
+			// The keys seen by the block store have predictable prefixes,
+			// including "/" from datastore.Key and 2 bytes from multihash. To
+			// reach a uniform 256-way split, we need approximately 4 bytes of
+			// prefix.
+			ShardPrefixLen: 4,
+		},
+		{
+			Prefix: "/",
+			Type:   LeveldbDatastore,
+			Path:   DefaultDataStoreDirectory,
+		},
+	}
+}
+
+// MountsOrDefault returns the configured mounts, or the default ones if
+// none are configured.
+func (d *Datastore) MountsOrDefault() []DatastoreMount {
+	if len(d.Mounts) == 0 {
+		return DefaultDatastoreMounts()
+	}
+	return d.Mounts
+}
+
+// StorageMaxBytes returns StorageMax in bytes, or zero if there is no
+// limit.
+func (d *Datastore) StorageMaxBytes() (uint64, error) {
+	if d.StorageMax == "" {
+		return 0, nil
+	}
+	return humanize.ParseBytes(d.StorageMax)
 }
 
 // DataStorePath returns the default data store path given a configuration root
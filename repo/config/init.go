@@ -82,8 +82,15 @@ func datastoreConfig() (*Datastore, error) {
 		return nil, err
 	}
 	return &Datastore{
-		Path: dspath,
-		Type: "leveldb",
+		Path:               dspath,
+		Type:               "leveldb",
+		StorageMax:         "",
+		StorageGCWatermark: 90,
+		GCPeriod:           "1h",
+		BloomFilterSize:    0,
+		ReadCacheSize:      0,
+		NodeCacheSize:      0,
+		Mounts:             DefaultDatastoreMounts(),
 	}, nil
 }
 
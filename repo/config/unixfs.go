@@ -0,0 +1,16 @@
+package config
+
+// Unixfs contains options for the unixfs files and directories the node
+// writes.
+type Unixfs struct {
+	// ShardingThreshold is the number of entries above which a directory
+	// is sharded. Zero, the default, or a negative number never shards,
+	// so that directories keep the hashes they always had.
+	ShardingThreshold int
+}
+
+// ShardThreshold returns the number of entries above which a directory is
+// sharded, or a number below 1 if directories are never sharded.
+func (u Unixfs) ShardThreshold() int {
+	return u.ShardingThreshold
+}
@@ -0,0 +1,86 @@
+package fsrepo
+
+import (
+	"strings"
+
+	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
+	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/mount"
+	ds2 "github.com/ipfs/go-ipfs/util/datastore2"
+)
+
+// batchWorkers is the number of puts of a batch written to a mount at the
+// same time. None of the backends batch writes natively, flatfs syncs each
+// one, so they are written concurrently instead.
+const batchWorkers = 16
+
+// repoDatastore is the datastore of the repo: the mounts, with batches
+// split across the batches of the mounted datastores.
+type repoDatastore struct {
+	ds2.ClaimThreadSafe
+
+	mounts []mount.Mount // longest prefix first, like mount looks them up
+}
+
+var _ ds2.Batching = (*repoDatastore)(nil)
+
+func newRepoDatastore(mounts []mount.Mount) *repoDatastore {
+	return &repoDatastore{
+		ClaimThreadSafe: ds2.ClaimThreadSafe{Datastore: mount.New(mounts)},
+		mounts:          mounts,
+	}
+}
+
+func (d *repoDatastore) Batch() (ds2.Batch, error) {
+	return &mountBatch{d: d, batches: make(map[int]ds2.Batch)}, nil
+}
+
+type mountBatch struct {
+	d       *repoDatastore
+	batches map[int]ds2.Batch // by index in d.mounts
+}
+
+// lookup returns the batch of the mount key belongs to, and the key within
+// that mount.
+func (b *mountBatch) lookup(key ds.Key) (ds2.Batch, ds.Key, error) {
+	for i, m := range b.d.mounts {
+		if !m.Prefix.Equal(key) && !m.Prefix.IsAncestorOf(key) {
+			continue
+		}
+		rest := ds.NewKey(strings.TrimPrefix(key.String(), m.Prefix.String()))
+		if t, ok := b.batches[i]; ok {
+			return t, rest, nil
+		}
+		t, err := ds2.BatchFor(m.Datastore)
+		if err != nil {
+			return nil, rest, err
+		}
+		b.batches[i] = t
+		return t, rest, nil
+	}
+	return nil, key, mount.ErrNoMount
+}
+
+func (b *mountBatch) Put(key ds.Key, val interface{}) error {
+	t, rest, err := b.lookup(key)
+	if err != nil {
+		return err
+	}
+	return t.Put(rest, val)
+}
+
+func (b *mountBatch) Delete(key ds.Key) error {
+	t, rest, err := b.lookup(key)
+	if err != nil {
+		return err
+	}
+	return t.Delete(rest)
+}
+
+func (b *mountBatch) Commit() error {
+	for _, t := range b.batches {
+		if err := t.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
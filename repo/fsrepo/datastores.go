@@ -0,0 +1,413 @@
+package fsrepo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/crowdmob/goamz/aws"
+	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/crowdmob/goamz/s3"
+	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/fzzy/radix/redis"
+	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
+	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/flatfs"
+	levelds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/leveldb"
+	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/measure"
+	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/mount"
+	syncds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/sync"
+	ldbopts "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/syndtr/goleveldb/leveldb/opt"
+	config "github.com/ipfs/go-ipfs/repo/config"
+	cryptds "github.com/ipfs/go-ipfs/thirdparty/crypt-datastore"
+	redisds "github.com/ipfs/go-ipfs/thirdparty/redis-datastore"
+	s3ds "github.com/ipfs/go-ipfs/thirdparty/s3-datastore"
+	ds2 "github.com/ipfs/go-ipfs/util/datastore2"
+)
+
+// specFilename is the file recording the datastore layout a repo was
+// created with.
+const specFilename = "datastore_spec"
+
+var errSpecMismatch = `datastore configuration does not match the repo on disk.
+Repo was created with: %s
+Config asks for:       %s
+Restore Datastore.Mounts in the config, or move the data to the new layout first.`
+
+// mountedDatastore is the backend of one DatastoreMount, as opened by the
+// repo.
+type mountedDatastore struct {
+	name    string // used in metrics and stats
+	prefix  ds.Key
+	dir     string // where it keeps its data, empty if outside the repo
+	backend ds.Datastore
+	metrics measure.DatastoreCloser
+}
+
+// diskSpec is the part of a DatastoreMount that decides where and how
+// existing data was written. Changing any of it makes that data
+// unreachable.
+type diskSpec struct {
+	Prefix         string
+	Type           string
+	Path           string `json:",omitempty"`
+	ShardPrefixLen int    `json:",omitempty"`
+	Encrypted      bool   `json:",omitempty"`
+}
+
+// mountName names a mount after its prefix. The catch-all "/" mount is
+// named after its backend instead, e.g. "leveldb".
+func mountName(m config.DatastoreMount) string {
+	name := strings.Trim(m.Prefix, "/")
+	if name == "" {
+		return m.Type
+	}
+	return strings.Replace(name, "/", ".", -1)
+}
+
+func mountDir(repoPath string, m config.DatastoreMount) string {
+	switch m.Type {
+	case config.FlatfsDatastore, config.LeveldbDatastore:
+		if filepath.IsAbs(m.Path) {
+			return m.Path
+		}
+		return path.Join(repoPath, m.Path)
+	default:
+		return ""
+	}
+}
+
+// checkMounts returns an error if mounts do not describe a usable
+// datastore.
+func checkMounts(mounts []config.DatastoreMount) error {
+	seen := make(map[string]bool)
+	for _, m := range mounts {
+		prefix := ds.NewKey(m.Prefix).String()
+		if seen[prefix] {
+			return fmt.Errorf("datastore: more than one mount at %s", prefix)
+		}
+		seen[prefix] = true
+
+		switch m.Type {
+		case config.FlatfsDatastore, config.LeveldbDatastore:
+			if m.Path == "" {
+				return fmt.Errorf("datastore: %s mount at %s has no Path", m.Type, prefix)
+			}
+		case config.MemDatastore:
+		case config.S3Datastore:
+			if m.Bucket == "" {
+				return fmt.Errorf("datastore: s3 mount at %s has no Bucket", prefix)
+			}
+			if _, ok := aws.Regions[m.Region]; !ok {
+				return fmt.Errorf("datastore: s3 mount at %s has unknown Region %q", prefix, m.Region)
+			}
+		case config.RedisDatastore:
+			if m.Addr == "" {
+				return fmt.Errorf("datastore: redis mount at %s has no Addr", prefix)
+			}
+		default:
+			return fmt.Errorf("datastore: unknown type %q for mount at %s", m.Type, prefix)
+		}
+	}
+	if !seen["/"] {
+		return errors.New("datastore: no mount at /")
+	}
+	return nil
+}
+
+func specOf(mounts []config.DatastoreMount) []diskSpec {
+	spec := make([]diskSpec, len(mounts))
+	for i, m := range mounts {
+		spec[i] = diskSpec{
+			Prefix:    ds.NewKey(m.Prefix).String(),
+			Type:      m.Type,
+			Encrypted: m.Encrypted,
+		}
+		switch m.Type {
+		case config.FlatfsDatastore:
+			spec[i].Path = m.Path
+			spec[i].ShardPrefixLen = m.ShardPrefixLen
+		case config.LeveldbDatastore:
+			spec[i].Path = m.Path
+		}
+	}
+	sort.Sort(specsByPrefix(spec))
+	return spec
+}
+
+type specsByPrefix []diskSpec
+
+func (s specsByPrefix) Len() int           { return len(s) }
+func (s specsByPrefix) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s specsByPrefix) Less(i, j int) bool { return s[i].Prefix < s[j].Prefix }
+
+func writeSpec(repoPath string, spec []diskSpec) error {
+	b, err := json.Marshal(spec)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path.Join(repoPath, specFilename), b, 0644)
+}
+
+// checkSpec compares mounts with the layout recorded in the repo. Repos
+// created before the layout was recorded get it written down, once the
+// data on disk was checked to match it.
+func checkSpec(repoPath string, mounts []config.DatastoreMount) error {
+	want := specOf(mounts)
+	b, err := ioutil.ReadFile(path.Join(repoPath, specFilename))
+	if os.IsNotExist(err) {
+		if err := checkLegacyLayout(repoPath, mounts); err != nil {
+			return err
+		}
+		return writeSpec(repoPath, want)
+	}
+	if err != nil {
+		return err
+	}
+
+	var have []diskSpec
+	if err := json.Unmarshal(b, &have); err != nil {
+		return fmt.Errorf("datastore: invalid %s: %s", specFilename, err)
+	}
+	if !reflect.DeepEqual(have, want) {
+		wb, _ := json.Marshal(want)
+		return fmt.Errorf(errSpecMismatch, b, wb)
+	}
+	return nil
+}
+
+// checkLegacyLayout returns an error unless mounts describe the layout of
+// repos created before the layout was recorded, which was always the
+// default one, and the data on disk is laid out that way.
+func checkLegacyLayout(repoPath string, mounts []config.DatastoreMount) error {
+	legacy := config.DefaultDatastoreMounts()
+	want, have := specOf(mounts), specOf(legacy)
+	if !reflect.DeepEqual(have, want) {
+		hb, _ := json.Marshal(have)
+		wb, _ := json.Marshal(want)
+		return fmt.Errorf(errSpecMismatch, hb, wb)
+	}
+
+	for _, m := range legacy {
+		dir := mountDir(repoPath, m)
+		entries, err := ioutil.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := checkBackendDir(m, entries); err != nil {
+			return fmt.Errorf("datastore: %s does not hold the %s datastore expected there (%s), refusing to upgrade the repo", dir, m.Type, err)
+		}
+	}
+	return nil
+}
+
+// checkBackendDir returns an error if the entries of the directory of mount
+// m were not written by its backend.
+func checkBackendDir(m config.DatastoreMount, entries []os.FileInfo) error {
+	switch m.Type {
+	case config.FlatfsDatastore:
+		// every value is in a directory named after the first
+		// ShardPrefixLen bytes of its key, hex encoded
+		for _, fi := range entries {
+			if !fi.IsDir() || len(fi.Name()) != 2*m.ShardPrefixLen {
+				return fmt.Errorf("unexpected entry %s", fi.Name())
+			}
+		}
+	case config.LeveldbDatastore:
+		if len(entries) == 0 {
+			return nil
+		}
+		for _, fi := range entries {
+			if fi.Name() == "CURRENT" {
+				return nil
+			}
+		}
+		return errors.New("no leveldb CURRENT file")
+	}
+	return nil
+}
+
+// openBackend opens the datastore configured by m.
+func openBackend(repoPath string, m config.DatastoreMount) (ds.Datastore, error) {
+	switch m.Type {
+	case config.FlatfsDatastore:
+		d, err := flatfs.New(mountDir(repoPath, m), m.ShardPrefixLen)
+		if err != nil {
+			return nil, fmt.Errorf("unable to open flatfs datastore: %s", err)
+		}
+		return d, nil
+
+	case config.LeveldbDatastore:
+		opts := &levelds.Options{Compression: ldbopts.NoCompression}
+		switch m.Compression {
+		case "", "none":
+		case "snappy":
+			opts.Compression = ldbopts.SnappyCompression
+		default:
+			return nil, fmt.Errorf("unknown leveldb compression %q", m.Compression)
+		}
+		d, err := levelds.NewDatastore(mountDir(repoPath, m), opts)
+		if err != nil {
+			return nil, errors.New("unable to open leveldb datastore")
+		}
+		return d, nil
+
+	case config.MemDatastore:
+		return syncds.MutexWrap(ds.NewMapDatastore()), nil
+
+	case config.S3Datastore:
+		auth, err := aws.EnvAuth()
+		if err != nil {
+			return nil, err
+		}
+		return &s3ds.S3Datastore{
+			Client: s3.New(auth, aws.Regions[m.Region]),
+			Bucket: m.Bucket,
+		}, nil
+
+	case config.RedisDatastore:
+		client, err := redis.Dial("tcp", m.Addr)
+		if err != nil {
+			return nil, fmt.Errorf("could not connect to redis: %s", err)
+		}
+		if m.TTL == "" {
+			return redisds.NewDatastore(client)
+		}
+		ttl, err := time.ParseDuration(m.TTL)
+		if err != nil {
+			return nil, err
+		}
+		return redisds.NewExpiringDatastore(client, ttl)
+
+	default:
+		return nil, fmt.Errorf("unknown datastore type %q", m.Type)
+	}
+}
+
+// openDatastore opens the datastore laid out by the config. It returns an
+// error if the layout is not the one the repo was created with.
+func (r *FSRepo) openDatastore() error {
+	mounts := r.config.Datastore.MountsOrDefault()
+	if err := checkMounts(mounts); err != nil {
+		return err
+	}
+	if err := checkSpec(r.path, mounts); err != nil {
+		return err
+	}
+
+	// Add our PeerID to metrics paths to keep them unique
+	//
+	// As some tests just pass a zero-value Config to fsrepo.Init,
+	// cope with missing PeerID.
+	id := r.config.Identity.PeerID
+	if id == "" {
+		// the tests pass in a zero Config; cope with it
+		id = fmt.Sprintf("uninitialized_%p", r)
+	}
+	prefix := "fsrepo." + id + ".datastore."
+
+	var key []byte
+	if anyEncrypted(mounts) {
+		var err error
+		if key, err = datastoreKey(r.path, &r.config.Datastore); err != nil {
+			return err
+		}
+	}
+
+	for _, m := range mounts {
+		d, err := openBackend(r.path, m)
+		if err != nil {
+			r.closeDatastores()
+			return err
+		}
+		if m.Encrypted {
+			cd, err := cryptds.New(d, key)
+			if err != nil {
+				if c, ok := d.(io.Closer); ok {
+					c.Close()
+				}
+				r.closeDatastores()
+				return err
+			}
+			d = cd
+		}
+		name := mountName(m)
+		r.mounts = append(r.mounts, &mountedDatastore{
+			name:    name,
+			prefix:  ds.NewKey(m.Prefix),
+			dir:     mountDir(r.path, m),
+			backend: d,
+			metrics: measure.New(prefix+name, d),
+		})
+	}
+
+	// the datastores in the repo directory count towards StorageMax. An
+	// invalid StorageMax is not enforced here, corerepo reports it.
+	r.usage = &storageUsage{}
+	if max, err := r.config.Datastore.StorageMaxBytes(); err == nil {
+		r.usage.setMax(max)
+	}
+
+	// mount uses the first matching prefix, so the longest have to go
+	// first.
+	sort.Sort(mountsByPrefixLen(r.mounts))
+	var dsMounts []mount.Mount
+	for _, m := range r.mounts {
+		var d ds.Datastore = m.metrics
+		if m.dir != "" {
+			r.usage.dirs = append(r.usage.dirs, m.dir)
+			d = &usageDatastore{Datastore: d, usage: r.usage}
+		}
+		dsMounts = append(dsMounts, mount.Mount{
+			Prefix: m.prefix,
+			Datastore: ds2.ParallelBatching{
+				ThreadSafeDatastore: ds2.ClaimThreadSafe{Datastore: d},
+				Workers:             batchWorkers,
+			},
+		})
+	}
+
+	// Make sure it's ok to claim the virtual datastore from mount, and
+	// the wrappers above, as threadsafe. There's no clean way to make
+	// mount itself provide this information without copy-pasting the
+	// code into two variants. All of the backends opened above are
+	// threadsafe.
+	r.ds = newRepoDatastore(dsMounts)
+	return nil
+}
+
+// closeDatastores closes the metrics and the backends of every mount,
+// returning the first error.
+func (r *FSRepo) closeDatastores() error {
+	var firstErr error
+	for _, m := range r.mounts {
+		if err := m.metrics.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if c, ok := m.backend.(io.Closer); ok {
+			if err := c.Close(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	r.mounts = nil
+	r.usage = nil
+	return firstErr
+}
+
+type mountsByPrefixLen []*mountedDatastore
+
+func (s mountsByPrefixLen) Len() int      { return len(s) }
+func (s mountsByPrefixLen) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s mountsByPrefixLen) Less(i, j int) bool {
+	return len(s[i].prefix.String()) > len(s[j].prefix.String())
+}
@@ -0,0 +1,115 @@
+package fsrepo
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+
+	config "github.com/ipfs/go-ipfs/repo/config"
+	cryptds "github.com/ipfs/go-ipfs/thirdparty/crypt-datastore"
+)
+
+// PassphraseEnv names the environment variable holding the passphrase of
+// encrypted datastore mounts, when the config sets no Datastore.KeyFile.
+const PassphraseEnv = "IPFS_DATASTORE_PASSPHRASE"
+
+// keyFilename is the file holding the salt of the datastore key, and a
+// check value to recognize the key by.
+const keyFilename = "datastore_key"
+
+var (
+	ErrNoDatastoreKey    = errors.New("repo datastore is encrypted: set " + PassphraseEnv + " or Datastore.KeyFile")
+	ErrWrongDatastoreKey = errors.New("wrong key for the encrypted repo datastore")
+)
+
+type keyParams struct {
+	Salt  []byte
+	Check []byte
+}
+
+func anyEncrypted(mounts []config.DatastoreMount) bool {
+	for _, m := range mounts {
+		if m.Encrypted {
+			return true
+		}
+	}
+	return false
+}
+
+// datastoreSecret reads the secret the datastore key is derived from.
+func datastoreSecret(repoPath string, cfg *config.Datastore) ([]byte, error) {
+	if cfg.KeyFile == "" {
+		pass := os.Getenv(PassphraseEnv)
+		if pass == "" {
+			return nil, ErrNoDatastoreKey
+		}
+		return []byte(pass), nil
+	}
+
+	p := cfg.KeyFile
+	if !filepath.IsAbs(p) {
+		p = path.Join(repoPath, p)
+	}
+	secret, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, fmt.Errorf("reading datastore key file: %s", err)
+	}
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("datastore key file %s is empty", p)
+	}
+	return secret, nil
+}
+
+// initDatastoreKey picks the salt of a new repo's datastore key, and
+// records it along with the key check.
+func initDatastoreKey(repoPath string, cfg *config.Datastore) error {
+	secret, err := datastoreSecret(repoPath, cfg)
+	if err != nil {
+		return err
+	}
+
+	salt := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return err
+	}
+	kp := keyParams{
+		Salt:  salt,
+		Check: cryptds.KeyCheck(cryptds.DeriveKey(secret, salt)),
+	}
+	b, err := json.Marshal(kp)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path.Join(repoPath, keyFilename), b, 0600)
+}
+
+// datastoreKey returns the key of the repo's encrypted mounts. It returns
+// an error if the secret is missing, or does not give the key the repo
+// was created with.
+func datastoreKey(repoPath string, cfg *config.Datastore) ([]byte, error) {
+	b, err := ioutil.ReadFile(path.Join(repoPath, keyFilename))
+	if err != nil {
+		return nil, fmt.Errorf("datastore: cannot read %s: %s", keyFilename, err)
+	}
+	var kp keyParams
+	if err := json.Unmarshal(b, &kp); err != nil {
+		return nil, fmt.Errorf("datastore: invalid %s: %s", keyFilename, err)
+	}
+
+	secret, err := datastoreSecret(repoPath, cfg)
+	if err != nil {
+		return nil, err
+	}
+	key := cryptds.DeriveKey(secret, kp.Salt)
+	if !hmac.Equal(cryptds.KeyCheck(key), kp.Check) {
+		return nil, ErrWrongDatastoreKey
+	}
+	return key, nil
+}
@@ -11,11 +11,6 @@ import (
 	"sync"
 
 	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
-	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/flatfs"
-	levelds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/leveldb"
-	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/measure"
-	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/mount"
-	ldbopts "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/syndtr/goleveldb/leveldb/opt"
 	repo "github.com/ipfs/go-ipfs/repo"
 	"github.com/ipfs/go-ipfs/repo/common"
 	config "github.com/ipfs/go-ipfs/repo/config"
@@ -26,19 +21,17 @@ import (
 	"github.com/ipfs/go-ipfs/thirdparty/eventlog"
 	u "github.com/ipfs/go-ipfs/util"
 	util "github.com/ipfs/go-ipfs/util"
-	ds2 "github.com/ipfs/go-ipfs/util/datastore2"
 )
 
 // version number that we are currently expecting to see
-var RepoVersion = "2"
+var RepoVersion = "3"
 
-var migrationInstructions = `See https://github.com/ipfs/fs-repo-migrations/blob/master/run.md
-Sorry for the inconvenience. In the future, these will run automatically.`
+var migrationInstructions = `Migrations that 'ipfs repo migrate' does not know about are run with the
+tools described at https://github.com/ipfs/fs-repo-migrations/blob/master/run.md`
 
 var errIncorrectRepoFmt = `Repo has incorrect version: %s
 Program version is: %s
-Please run the ipfs migration tool before continuing.
-` + migrationInstructions
+Please run 'ipfs repo migrate' before continuing.`
 
 var (
 	ErrNoVersion = errors.New("no version file found, please run 0-to-1 migration tool.\n" + migrationInstructions)
@@ -55,11 +48,6 @@ func (err NoRepoError) Error() string {
 	return fmt.Sprintf("no ipfs repo found in '%s'. please run: ipfs init ", err.Path)
 }
 
-const (
-	leveldbDirectory = "datastore"
-	flatfsDirectory  = "blocks"
-)
-
 var (
 
 	// packageLock must be held to while performing any operation that modifies an
@@ -93,10 +81,11 @@ type FSRepo struct {
 	lockfile io.Closer
 	config   *config.Config
 	ds       ds.ThreadSafeDatastore
-	// tracked separately for use in Close; do not use directly.
-	leveldbDS      levelds.Datastore
-	metricsBlocks  measure.DatastoreCloser
-	metricsLevelDB measure.DatastoreCloser
+	// the backends behind ds, tracked for Close and stats; do not use
+	// directly.
+	mounts []*mountedDatastore
+	// usage tracks the size of the mounts in the repo directory
+	usage *storageUsage
 }
 
 var _ repo.Repo = (*FSRepo)(nil)
@@ -236,7 +225,7 @@ func initConfig(path string, conf *config.Config) error {
 }
 
 // Init initializes a new FSRepo at the given path with the provided config.
-// TODO add support for custom datastores.
+// The datastore is laid out as configured in conf.Datastore.Mounts.
 func Init(repoPath string, conf *config.Config) error {
 
 	// packageLock must be held to ensure that the repo is not initialized more
@@ -248,20 +237,39 @@ func Init(repoPath string, conf *config.Config) error {
 		return nil
 	}
 
+	mounts := conf.Datastore.MountsOrDefault()
+	if err := checkMounts(mounts); err != nil {
+		return err
+	}
+	if anyEncrypted(mounts) {
+		// fail before anything is written
+		if _, err := datastoreSecret(repoPath, &conf.Datastore); err != nil {
+			return err
+		}
+	}
+
 	if err := initConfig(repoPath, conf); err != nil {
 		return err
 	}
 
 	// The actual datastore contents are initialized lazily when Opened.
-	// During Init, we merely check that the directory is writeable.
-	leveldbPath := path.Join(repoPath, leveldbDirectory)
-	if err := dir.Writable(leveldbPath); err != nil {
-		return fmt.Errorf("datastore: %s", err)
+	// During Init, we merely check that the directories are writeable.
+	for _, m := range mounts {
+		if p := mountDir(repoPath, m); p != "" {
+			if err := dir.Writable(p); err != nil {
+				return fmt.Errorf("datastore: %s", err)
+			}
+		}
 	}
 
-	flatfsPath := path.Join(repoPath, flatfsDirectory)
-	if err := dir.Writable(flatfsPath); err != nil {
-		return fmt.Errorf("datastore: %s", err)
+	if err := writeSpec(repoPath, specOf(mounts)); err != nil {
+		return err
+	}
+
+	if anyEncrypted(mounts) {
+		if err := initDatastoreKey(repoPath, &conf.Datastore); err != nil {
+			return err
+		}
 	}
 
 	if err := dir.Writable(path.Join(repoPath, "logs")); err != nil {
@@ -307,64 +315,6 @@ func (r *FSRepo) openConfig() error {
 	return nil
 }
 
-// openDatastore returns an error if the config file is not present.
-func (r *FSRepo) openDatastore() error {
-	leveldbPath := path.Join(r.path, leveldbDirectory)
-	var err error
-	// save leveldb reference so it can be neatly closed afterward
-	r.leveldbDS, err = levelds.NewDatastore(leveldbPath, &levelds.Options{
-		Compression: ldbopts.NoCompression,
-	})
-	if err != nil {
-		return errors.New("unable to open leveldb datastore")
-	}
-
-	// 4TB of 256kB objects ~=17M objects, splitting that 256-way
-	// leads to ~66k objects per dir, splitting 256*256-way leads to
-	// only 256.
-	//
-	// The keys seen by the block store have predictable prefixes,
-	// including "/" from datastore.Key and 2 bytes from multihash. To
-	// reach a uniform 256-way split, we need approximately 4 bytes of
-	// prefix.
-	blocksDS, err := flatfs.New(path.Join(r.path, flatfsDirectory), 4)
-	if err != nil {
-		return errors.New("unable to open flatfs datastore")
-	}
-
-	// Add our PeerID to metrics paths to keep them unique
-	//
-	// As some tests just pass a zero-value Config to fsrepo.Init,
-	// cope with missing PeerID.
-	id := r.config.Identity.PeerID
-	if id == "" {
-		// the tests pass in a zero Config; cope with it
-		id = fmt.Sprintf("uninitialized_%p", r)
-	}
-	prefix := "fsrepo." + id + ".datastore."
-	r.metricsBlocks = measure.New(prefix+"blocks", blocksDS)
-	r.metricsLevelDB = measure.New(prefix+"leveldb", r.leveldbDS)
-	mountDS := mount.New([]mount.Mount{
-		{
-			Prefix:    ds.NewKey("/blocks"),
-			Datastore: r.metricsBlocks,
-		},
-		{
-			Prefix:    ds.NewKey("/"),
-			Datastore: r.metricsLevelDB,
-		},
-	})
-	// Make sure it's ok to claim the virtual datastore from mount as
-	// threadsafe. There's no clean way to make mount itself provide
-	// this information without copy-pasting the code into two
-	// variants. This is the same dilemma as the `[].byte` attempt at
-	// introducing const types to Go.
-	var _ ds.ThreadSafeDatastore = blocksDS
-	var _ ds.ThreadSafeDatastore = r.leveldbDS
-	r.ds = ds2.ClaimThreadSafe{mountDS}
-	return nil
-}
-
 func configureEventLoggerAtRepoPath(c *config.Config, repoPath string) {
 	eventlog.Configure(eventlog.LevelInfo)
 	eventlog.Configure(eventlog.LdJSONFormatter)
@@ -386,13 +336,7 @@ func (r *FSRepo) Close() error {
 		return errors.New("repo is closed")
 	}
 
-	if err := r.metricsBlocks.Close(); err != nil {
-		return err
-	}
-	if err := r.metricsLevelDB.Close(); err != nil {
-		return err
-	}
-	if err := r.leveldbDS.Close(); err != nil {
+	if err := r.closeDatastores(); err != nil {
 		return err
 	}
 
@@ -455,6 +399,11 @@ func (r *FSRepo) setConfigUnsynced(updated *config.Config) error {
 		return err
 	}
 	*r.config = *updated // copy so caller cannot modify this private config
+	if r.usage != nil {
+		if max, err := updated.Datastore.StorageMaxBytes(); err == nil {
+			r.usage.setMax(max)
+		}
+	}
 	return nil
 }
 
@@ -554,7 +503,10 @@ func isInitializedUnsynced(repoPath string) bool {
 	if !configIsInitialized(repoPath) {
 		return false
 	}
-	if !util.FileExists(path.Join(repoPath, leveldbDirectory)) {
+	// repos created before the datastore layout was recorded have no
+	// spec file, but always have the leveldb directory.
+	if !util.FileExists(path.Join(repoPath, specFilename)) &&
+		!util.FileExists(path.Join(repoPath, config.DefaultDataStoreDirectory)) {
 		return false
 	}
 	return true
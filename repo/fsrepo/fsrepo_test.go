@@ -2,12 +2,24 @@ package fsrepo
 
 import (
 	"bytes"
+	"fmt"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
 	datastore "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
+	"github.com/ipfs/go-ipfs/blocks"
+	bstore "github.com/ipfs/go-ipfs/blocks/blockstore"
+	"github.com/ipfs/go-ipfs/filestore"
+	mdag "github.com/ipfs/go-ipfs/merkledag"
+	"github.com/ipfs/go-ipfs/pin"
+	"github.com/ipfs/go-ipfs/repo"
 	"github.com/ipfs/go-ipfs/repo/config"
+	mfsr "github.com/ipfs/go-ipfs/repo/fsrepo/migrations"
 	"github.com/ipfs/go-ipfs/thirdparty/assert"
+	u "github.com/ipfs/go-ipfs/util"
+	ds2 "github.com/ipfs/go-ipfs/util/datastore2"
 )
 
 // swap arg order
@@ -116,3 +128,321 @@ func TestOpenMoreThanOnceInSameProcess(t *testing.T) {
 	assert.Nil(r1.Close(), t)
 	assert.Nil(r2.Close(), t)
 }
+
+func TestDatastoreStats(t *testing.T) {
+	t.Parallel()
+	path := testRepoPath("stat", t)
+	assert.Nil(Init(path, &config.Config{}), t)
+
+	r, err := Open(path)
+	assert.Nil(err, t)
+	blk := blocks.NewBlock([]byte("block data"))
+	assert.Nil(r.Datastore().Put(bstore.BlockPrefix.Child(blk.Key().DsKey()), blk.Data), t)
+	assert.Nil(r.Datastore().Put(datastore.NewKey("/local/bar"), []byte("leveldb data")), t)
+	fblk := blocks.NewBlock([]byte("filestore data"))
+	assert.Nil(r.Datastore().Put(filestore.Prefix.Child(fblk.Key().DsKey()), []byte("filestore entry")), t)
+
+	stats, err := r.DatastoreStats()
+	assert.Nil(err, t)
+	assert.True(len(stats) == 2, t, "should report two datastores")
+	var objects, blocks uint64
+	for _, s := range stats {
+		objects += s.NumObjects
+		blocks += s.NumBlocks
+		assert.True(s.NumBlocks == 1, t, s.Name, "should contain one block")
+		assert.True(s.Size > 0, t, s.Name, "should use some disk space")
+	}
+	assert.True(objects == 3, t, "should contain three objects")
+	assert.True(blocks == 2, t, "should count the filestore entry as a block")
+	assert.Nil(r.Close(), t)
+}
+
+func TestStorageMaxEnforcedOnWrites(t *testing.T) {
+	t.Parallel()
+	path := testRepoPath("storagemax", t)
+	assert.Nil(Init(path, &config.Config{}), t)
+
+	r, err := Open(path)
+	assert.Nil(err, t)
+	usage, err := r.GetStorageUsage()
+	assert.Nil(err, t)
+
+	conf := *r.Config()
+	conf.Datastore.StorageMax = fmt.Sprintf("%dB", usage+1000)
+	assert.Nil(r.SetConfig(&conf), t)
+
+	assert.Nil(r.Datastore().Put(datastore.NewKey("/local/small"), make([]byte, 600)), t)
+	after, err := r.GetStorageUsage()
+	assert.Nil(err, t)
+	assert.True(after == usage+600, t, "should count the written bytes")
+
+	err = r.Datastore().Put(datastore.NewKey("/local/big"), make([]byte, 600))
+	assert.True(err == repo.ErrMaxStorageExceeded, t, "should refuse writes above StorageMax")
+	_, err = r.Datastore().Get(datastore.NewKey("/local/big"))
+	assert.True(err == datastore.ErrNotFound, t, "should not store refused writes")
+
+	assert.Nil(r.Datastore().Put(datastore.NewKey("/local/small"), make([]byte, 900)), t)
+	after, err = r.GetStorageUsage()
+	assert.Nil(err, t)
+	assert.True(after == usage+900, t, "should count an overwritten value once")
+
+	assert.Nil(r.Datastore().Delete(datastore.NewKey("/local/small")), t)
+	after, err = r.GetStorageUsage()
+	assert.Nil(err, t)
+	assert.True(after == usage, t, "should take deleted values away")
+	assert.Nil(r.Close(), t)
+}
+
+func TestDatastoreBatchSpansMounts(t *testing.T) {
+	t.Parallel()
+	path := testRepoPath("batch", t)
+	assert.Nil(Init(path, &config.Config{}), t)
+
+	r, err := Open(path)
+	assert.Nil(err, t)
+	d, ok := r.Datastore().(ds2.Batching)
+	assert.True(ok, t, "repo datastore should batch writes")
+
+	blk := blocks.NewBlock([]byte("batched block"))
+	blockKey := bstore.BlockPrefix.Child(blk.Key().DsKey())
+	localKey := datastore.NewKey("/local/batched")
+	b, err := d.Batch()
+	assert.Nil(err, t)
+	assert.Nil(b.Put(blockKey, blk.Data), t)
+	assert.Nil(b.Put(localKey, []byte("leveldb data")), t)
+	_, err = d.Get(localKey)
+	assert.True(err == datastore.ErrNotFound, t, "should not write before Commit")
+	assert.Nil(b.Commit(), t)
+
+	for _, k := range []datastore.Key{blockKey, localKey} {
+		_, err := d.Get(k)
+		assert.Nil(err, t, k.String(), "should be written by Commit")
+	}
+	stats, err := r.DatastoreStats()
+	assert.Nil(err, t)
+	for _, s := range stats {
+		assert.True(s.NumObjects == 1, t, s.Name, "should hold one of the batched values")
+	}
+	assert.Nil(r.Close(), t)
+}
+
+func TestDatastoreMounts(t *testing.T) {
+	t.Parallel()
+	path := testRepoPath("mounts", t)
+	conf := &config.Config{}
+	conf.Datastore.Mounts = []config.DatastoreMount{
+		{Prefix: "/blocks", Type: config.FlatfsDatastore, Path: "blocks", ShardPrefixLen: 2},
+		{Prefix: "/", Type: config.MemDatastore},
+	}
+	assert.Nil(Init(path, conf), t)
+
+	r, err := Open(path)
+	assert.Nil(err, t)
+	k := datastore.NewKey("/blocks/foo")
+	assert.Nil(r.Datastore().Put(k, []byte("block data")), t)
+	has, err := r.Datastore().Has(k)
+	assert.Nil(err, t)
+	assert.True(has, t, "block should be stored in flatfs")
+
+	stats, err := r.DatastoreStats()
+	assert.Nil(err, t)
+	assert.True(len(stats) == 2, t, "should report both datastores")
+	for _, s := range stats {
+		assert.True((s.Path == "") == (s.Name != "blocks"), t, s.Name, "only the flatfs datastore should have a path")
+	}
+
+	// change the shard length under the existing data
+	updated := *r.Config()
+	updated.Datastore.Mounts = []config.DatastoreMount{
+		{Prefix: "/blocks", Type: config.FlatfsDatastore, Path: "blocks", ShardPrefixLen: 4},
+		{Prefix: "/", Type: config.MemDatastore},
+	}
+	assert.Nil(r.SetConfig(&updated), t)
+	assert.Nil(r.Close(), t)
+
+	_, err = Open(path)
+	assert.Err(err, t, "should not open a repo whose datastore spec changed")
+}
+
+func TestLegacyRepoLayoutChecked(t *testing.T) {
+	t.Parallel()
+	path := testRepoPath("legacy", t)
+	assert.Nil(Init(path, &config.Config{}), t)
+	r, err := Open(path)
+	assert.Nil(err, t)
+	blk := blocks.NewBlock([]byte("legacy block"))
+	assert.Nil(r.Datastore().Put(bstore.BlockPrefix.Child(blk.Key().DsKey()), blk.Data), t)
+	assert.Nil(r.Close(), t)
+
+	// repos from before the spec was recorded have none
+	specPath := filepath.Join(path, specFilename)
+	assert.Nil(os.Remove(specPath), t)
+	shortShard := filepath.Join(path, "blocks", "1220")
+	assert.Nil(os.Mkdir(shortShard, 0777), t)
+	_, err = Open(path)
+	assert.Err(err, t, "should not upgrade a repo laid out differently on disk")
+	_, err = os.Stat(specPath)
+	assert.True(os.IsNotExist(err), t, "should not record a spec for a refused upgrade")
+
+	assert.Nil(os.Remove(shortShard), t)
+	r, err = Open(path)
+	assert.Nil(err, t)
+	_, err = os.Stat(specPath)
+	assert.Nil(err, t, "should record the spec of an upgraded repo")
+
+	updated := *r.Config()
+	updated.Datastore.Mounts = []config.DatastoreMount{
+		{Prefix: "/blocks", Type: config.FlatfsDatastore, Path: "blocks", ShardPrefixLen: 2},
+		{Prefix: "/", Type: config.LeveldbDatastore, Path: "datastore"},
+	}
+	assert.Nil(r.SetConfig(&updated), t)
+	assert.Nil(r.Close(), t)
+	assert.Nil(os.Remove(specPath), t)
+	_, err = Open(path)
+	assert.Err(err, t, "should not upgrade a repo configured for another layout")
+}
+
+func TestInitRejectsBadMounts(t *testing.T) {
+	t.Parallel()
+	path := testRepoPath("badmounts", t)
+	conf := &config.Config{}
+	conf.Datastore.Mounts = []config.DatastoreMount{
+		{Prefix: "/blocks", Type: config.FlatfsDatastore, Path: "blocks", ShardPrefixLen: 4},
+	}
+	assert.Err(Init(path, conf), t, "should require a mount at /")
+}
+
+func TestMigrate(t *testing.T) {
+	t.Parallel()
+	path := testRepoPath("migrate", t)
+	assert.Nil(Init(path, &config.Config{}), t)
+
+	var ran []string
+	ms := make(mfsr.Migrations)
+	ms.Add(&mfsr.Migration{
+		Version:     3,
+		Description: "test",
+		Up: func(mfsr.RepoPath) error {
+			ran = append(ran, "up")
+			return nil
+		},
+		Down: func(mfsr.RepoPath) error {
+			ran = append(ran, "down")
+			return nil
+		},
+	})
+
+	steps, err := migrate(path, "4", MigrateOptions{DryRun: true}, ms)
+	assert.Nil(err, t)
+	assert.True(len(steps) == 1 && len(ran) == 0, t, "dry run should not run migrations")
+
+	steps, err = migrate(path, "4", MigrateOptions{Backup: true}, ms)
+	assert.Nil(err, t)
+	assert.True(len(ran) == 1 && ran[0] == "up", t, "should have migrated up")
+	ver, err := mfsr.RepoPath(path).Version()
+	assert.Nil(err, t)
+	assert.True(ver == "4", t, "version should be 4")
+	backup, err := mfsr.RepoPath(BackupPath(path, 3)).Version()
+	assert.Nil(err, t)
+	assert.True(backup == "3", t, "backup should keep version 3")
+
+	_, err = migrate(path, "5", MigrateOptions{}, ms)
+	assert.Err(err, t, "there is no migration from 4 to 5")
+
+	_, err = migrate(path, "3", MigrateOptions{}, ms)
+	assert.Nil(err, t)
+	assert.True(len(ran) == 2 && ran[1] == "down", t, "should have migrated down")
+	r, err := Open(path)
+	assert.Nil(err, t)
+	assert.Nil(r.Close(), t)
+}
+
+func TestMigratePins(t *testing.T) {
+	t.Parallel()
+	path := testRepoPath("migratepins", t)
+	assert.Nil(Init(path, &config.Config{}), t)
+
+	rec, dir, ind := u.Key(u.Hash([]byte("r"))), u.Key(u.Hash([]byte("d"))), u.Key(u.Hash([]byte("i")))
+	r, err := Open(path)
+	assert.Nil(err, t)
+	d := r.Datastore()
+	assert.Nil(storeJSONPins(d, v2RecursivePinsKey, []u.Key{rec}), t)
+	assert.Nil(storeJSONPins(d, v2DirectPinsKey, []u.Key{dir}), t)
+	assert.Nil(storeJSONPins(d, v2IndirectPinsKey, map[string]int{ind.B58String(): 2}), t)
+	assert.Nil(d.Put(v2RecursivePinsKey.ChildString(rec.B58String()), []byte{}), t)
+	assert.Nil(r.Close(), t)
+	assert.Nil(mfsr.RepoPath(path).WriteVersion("2"), t)
+
+	_, err = migrate(path, "3", MigrateOptions{}, mfsr.Registry)
+	assert.Nil(err, t)
+
+	err = withPinner(mfsr.RepoPath(path), func(d datastore.ThreadSafeDatastore, dserv mdag.DAGService) error {
+		has, err := d.Has(v2RecursivePinsKey)
+		assert.Nil(err, t)
+		assert.False(has, t, "old pin sets should be removed")
+		has, err = d.Has(v2RecursivePinsKey.ChildString(rec.B58String()))
+		assert.Nil(err, t)
+		assert.False(has, t, "old pin entries should be removed")
+
+		p, err := pin.LoadPinner(d, dserv, dserv)
+		assert.Nil(err, t)
+		assert.True(len(p.RecursiveKeys()) == 1 && p.RecursiveKeys()[0] == rec, t, "recursive pin should be kept")
+		assert.True(len(p.DirectKeys()) == 1 && p.DirectKeys()[0] == dir, t, "direct pin should be kept")
+		assert.True(p.IndirectKeys()[ind] == 2, t, "indirect refcount should be kept")
+		return nil
+	})
+	assert.Nil(err, t)
+
+	_, err = migrate(path, "2", MigrateOptions{}, mfsr.Registry)
+	assert.Nil(err, t)
+	err = withPinner(mfsr.RepoPath(path), func(d datastore.ThreadSafeDatastore, _ mdag.DAGService) error {
+		var recursive []u.Key
+		var indirect map[string]int
+		assert.Nil(loadJSONPins(d, v2RecursivePinsKey, &recursive), t)
+		assert.Nil(loadJSONPins(d, v2IndirectPinsKey, &indirect), t)
+		assert.True(len(recursive) == 1 && recursive[0] == rec, t, "recursive pin should be restored")
+		assert.True(indirect[ind.B58String()] == 2, t, "indirect refcount should be restored")
+		return nil
+	})
+	assert.Nil(err, t)
+}
+
+func TestEncryptedDatastore(t *testing.T) {
+	t.Parallel()
+	path := testRepoPath("encrypted", t)
+	keyfile := path + "-key"
+	assert.Nil(ioutil.WriteFile(keyfile, []byte("correct horse"), 0600), t)
+
+	conf := &config.Config{}
+	conf.Datastore.KeyFile = keyfile
+	conf.Datastore.Mounts = []config.DatastoreMount{
+		{Prefix: "/blocks", Type: config.FlatfsDatastore, Path: "blocks", ShardPrefixLen: 2, Encrypted: true},
+		{Prefix: "/", Type: config.MemDatastore},
+	}
+	assert.Nil(Init(path, conf), t)
+
+	r, err := Open(path)
+	assert.Nil(err, t)
+	k := datastore.NewKey("/blocks/foo")
+	data := []byte("some secret block data")
+	assert.Nil(r.Datastore().Put(k, data), t)
+	v, err := r.Datastore().Get(k)
+	assert.Nil(err, t)
+	assert.True(bytes.Equal(v.([]byte), data), t, "should read back the stored value")
+	assert.Nil(r.Close(), t)
+
+	files, err := filepath.Glob(filepath.Join(path, "blocks", "*", "*.data"))
+	assert.Nil(err, t)
+	assert.True(len(files) == 1, t, "block should be stored in flatfs")
+	raw, err := ioutil.ReadFile(files[0])
+	assert.Nil(err, t)
+	assert.False(bytes.Contains(raw, data), t, "value should be encrypted on disk")
+
+	assert.Nil(ioutil.WriteFile(keyfile, []byte("wrong horse"), 0600), t)
+	_, err = Open(path)
+	assert.True(err == ErrWrongDatastoreKey, t, "should not open with the wrong key")
+
+	assert.Nil(os.Remove(keyfile), t)
+	_, err = Open(path)
+	assert.Err(err, t, "should not open without the key")
+}
@@ -0,0 +1,175 @@
+package fsrepo
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+
+	lockfile "github.com/ipfs/go-ipfs/repo/fsrepo/lock"
+	mfsr "github.com/ipfs/go-ipfs/repo/fsrepo/migrations"
+	u "github.com/ipfs/go-ipfs/util"
+)
+
+// MigrateOptions controls how Migrate runs.
+type MigrateOptions struct {
+	// DryRun only works out the steps, without running them.
+	DryRun bool
+
+	// Backup copies the repo to BackupPath before the first step runs.
+	Backup bool
+}
+
+// BackupPath is where Migrate copies the repo at repoPath, at version
+// version, when asked for a backup.
+func BackupPath(repoPath string, version int) string {
+	return fmt.Sprintf("%s-v%d-backup", path.Clean(repoPath), version)
+}
+
+// Migrate moves the repo at repoPath to version to, running the migrations
+// in mfsr.Registry. It returns the steps it ran, or would run when
+// opts.DryRun is set. The repo must not be open.
+func Migrate(repoPath, to string, opts MigrateOptions) ([]mfsr.Step, error) {
+	return migrate(repoPath, to, opts, mfsr.Registry)
+}
+
+// PendingMigrations returns the steps needed to bring the repo at repoPath
+// to RepoVersion. It returns no steps when the repo is up to date, or when
+// it can not be migrated; Open reports the problem in that case.
+func PendingMigrations(repoPath string) ([]mfsr.Step, error) {
+	packageLock.Lock()
+	defer packageLock.Unlock()
+
+	repoPath, err := u.TildeExpansion(path.Clean(repoPath))
+	if err != nil {
+		return nil, err
+	}
+	if !isInitializedUnsynced(repoPath) {
+		return nil, nil
+	}
+	from, to, err := migrationRange(repoPath, RepoVersion)
+	if err != nil {
+		return nil, nil
+	}
+	steps, err := mfsr.Registry.Plan(from, to)
+	if err != nil {
+		return nil, nil
+	}
+	return steps, nil
+}
+
+func migrate(repoPath, to string, opts MigrateOptions, ms mfsr.Migrations) ([]mfsr.Step, error) {
+	packageLock.Lock()
+	defer packageLock.Unlock()
+
+	repoPath, err := u.TildeExpansion(path.Clean(repoPath))
+	if err != nil {
+		return nil, err
+	}
+	if err := checkInitialized(repoPath); err != nil {
+		return nil, err
+	}
+
+	lk, err := lockfile.Lock(repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot lock the repo, is the daemon running? %s", err)
+	}
+	defer lk.Close()
+
+	from, toV, err := migrationRange(repoPath, to)
+	if err != nil {
+		return nil, err
+	}
+	steps, err := ms.Plan(from, toV)
+	if err != nil {
+		return nil, fmt.Errorf("%s\n%s", err, migrationInstructions)
+	}
+	if opts.DryRun || len(steps) == 0 {
+		return steps, nil
+	}
+
+	if opts.Backup {
+		if err := copyRepo(repoPath, BackupPath(repoPath, from)); err != nil {
+			return nil, fmt.Errorf("backing up repo: %s", err)
+		}
+	}
+
+	rp := mfsr.RepoPath(repoPath)
+	for _, s := range steps {
+		if err := s.Run(rp); err != nil {
+			return nil, err
+		}
+	}
+	return steps, nil
+}
+
+// migrationRange returns the current version of the repo at repoPath, and
+// version to, as numbers.
+func migrationRange(repoPath, to string) (int, int, error) {
+	ver, err := mfsr.RepoPath(repoPath).Version()
+	if _, ok := err.(mfsr.VersionFileNotFound); ok {
+		return 0, 0, ErrNoVersion
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	from, err := strconv.Atoi(ver)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid repo version %q", ver)
+	}
+	toV, err := strconv.Atoi(to)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid repo version %q", to)
+	}
+	return from, toV, nil
+}
+
+// copyRepo copies the repo at src to dst, which must not exist yet. The
+// repo lock is left behind.
+func copyRepo(src, dst string) error {
+	if _, err := os.Stat(dst); err == nil {
+		return fmt.Errorf("%s already exists", dst)
+	}
+	return filepath.Walk(src, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		if rel == lockfile.LockFile {
+			return nil
+		}
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case fi.IsDir():
+			return os.MkdirAll(target, fi.Mode().Perm())
+		case fi.Mode().IsRegular():
+			return copyFile(p, target, fi.Mode().Perm())
+		default:
+			return nil
+		}
+	})
+}
+
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,166 @@
+package fsrepo
+
+import (
+	"encoding/json"
+	"errors"
+	"path"
+
+	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
+	dsq "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/query"
+	"github.com/ipfs/go-ipfs/blocks/blockstore"
+	bserv "github.com/ipfs/go-ipfs/blockservice"
+	"github.com/ipfs/go-ipfs/exchange/offline"
+	mdag "github.com/ipfs/go-ipfs/merkledag"
+	"github.com/ipfs/go-ipfs/pin"
+	mfsr "github.com/ipfs/go-ipfs/repo/fsrepo/migrations"
+	u "github.com/ipfs/go-ipfs/util"
+)
+
+// Up to repo version 2, the pin sets were JSON values under these keys.
+// Version 3 stores them as merkledag objects, see package pin.
+var (
+	v2RecursivePinsKey = ds.NewKey("/local/pins/recursive/keys")
+	v2DirectPinsKey    = ds.NewKey("/local/pins/direct/keys")
+	v2IndirectPinsKey  = ds.NewKey("/local/pins/indirect/keys")
+)
+
+func init() {
+	mfsr.Register(&mfsr.Migration{
+		Version:     2,
+		Description: "store the pin sets as merkledag objects",
+		Up:          migratePinsToDAG,
+		Down:        migratePinsToJSON,
+	})
+}
+
+// withPinner opens the datastore of the repo at rp, whatever its version,
+// and calls f with it and a DAG service reading the local blocks only.
+func withPinner(rp mfsr.RepoPath, f func(ds.ThreadSafeDatastore, mdag.DAGService) error) error {
+	r := &FSRepo{path: path.Clean(string(rp))}
+	if err := r.openConfig(); err != nil {
+		return err
+	}
+	if err := r.openDatastore(); err != nil {
+		return err
+	}
+	defer r.closeDatastores()
+
+	bs := blockstore.NewBlockstore(r.ds)
+	blocks, err := bserv.New(bs, offline.Exchange(bs))
+	if err != nil {
+		return err
+	}
+	defer blocks.Close()
+
+	return f(r.ds, mdag.NewDAGService(blocks))
+}
+
+func migratePinsToDAG(rp mfsr.RepoPath) error {
+	return withPinner(rp, func(d ds.ThreadSafeDatastore, dserv mdag.DAGService) error {
+		var recursive, direct []u.Key
+		var indirect map[string]int
+		if err := loadJSONPins(d, v2RecursivePinsKey, &recursive); err != nil {
+			return err
+		}
+		if err := loadJSONPins(d, v2DirectPinsKey, &direct); err != nil {
+			return err
+		}
+		if err := loadJSONPins(d, v2IndirectPinsKey, &indirect); err != nil {
+			return err
+		}
+
+		p := pin.NewPinner(d, dserv).GetManual()
+		for _, k := range recursive {
+			p.PinWithMode(k, pin.Recursive)
+		}
+		for _, k := range direct {
+			p.PinWithMode(k, pin.Direct)
+		}
+		for enc, refs := range indirect {
+			k := u.B58KeyDecode(enc)
+			for i := 0; i < refs; i++ {
+				p.PinWithMode(k, pin.Indirect)
+			}
+		}
+		if err := p.Flush(); err != nil {
+			return err
+		}
+
+		// the JSON values, and the entry per key that version 2 also
+		// wrote below them
+		return deleteBelow(d, pin.DatastoreKey)
+	})
+}
+
+// migratePinsToJSON drops the pin metadata, which version 2 cannot store.
+func migratePinsToJSON(rp mfsr.RepoPath) error {
+	return withPinner(rp, func(d ds.ThreadSafeDatastore, dserv mdag.DAGService) error {
+		p, err := pin.LoadPinner(d, dserv, dserv)
+		if err == ds.ErrNotFound {
+			return nil // no pins
+		}
+		if err != nil {
+			return err
+		}
+
+		indirect := make(map[string]int)
+		for k, refs := range p.IndirectKeys() {
+			indirect[u.B58KeyEncode(k)] = refs
+		}
+		if err := storeJSONPins(d, v2RecursivePinsKey, p.RecursiveKeys()); err != nil {
+			return err
+		}
+		if err := storeJSONPins(d, v2DirectPinsKey, p.DirectKeys()); err != nil {
+			return err
+		}
+		if err := storeJSONPins(d, v2IndirectPinsKey, indirect); err != nil {
+			return err
+		}
+		return d.Delete(pin.DatastoreKey)
+	})
+}
+
+func loadJSONPins(d ds.Datastore, k ds.Key, val interface{}) error {
+	v, err := d.Get(k)
+	if err == ds.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	b, ok := v.([]byte)
+	if !ok {
+		return errors.New("invalid pin set value in datastore")
+	}
+	return json.Unmarshal(b, val)
+}
+
+func storeJSONPins(d ds.Datastore, k ds.Key, val interface{}) error {
+	b, err := json.Marshal(val)
+	if err != nil {
+		return err
+	}
+	return d.Put(k, b)
+}
+
+// deleteBelow deletes every key of d below prefix.
+func deleteBelow(d ds.Datastore, prefix ds.Key) error {
+	res, err := d.Query(dsq.Query{Prefix: prefix.String(), KeysOnly: true})
+	if err != nil {
+		return err
+	}
+	entries, err := res.Rest()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		k := ds.NewKey(e.Key)
+		if !prefix.IsAncestorOf(k) {
+			continue
+		}
+		if err := d.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package mfsr
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Migration moves a repo from Version to Version+1 (Up), and back (Down).
+// Down may be nil for migrations that can not be undone.
+type Migration struct {
+	Version     int
+	Description string
+
+	Up   func(RepoPath) error
+	Down func(RepoPath) error
+}
+
+// Migrations holds migrations by the version they start from.
+type Migrations map[int]*Migration
+
+// Registry holds the migrations of every change made to the repo format.
+// Code changing the format registers its migration with Register, and bumps
+// fsrepo.RepoVersion.
+var Registry = make(Migrations)
+
+// Register adds m to the Registry. It panics if a migration from the same
+// version is already registered.
+func Register(m *Migration) {
+	Registry.Add(m)
+}
+
+// Add adds m to ms. It panics if a migration from the same version is
+// already in ms.
+func (ms Migrations) Add(m *Migration) {
+	if _, ok := ms[m.Version]; ok {
+		panic(fmt.Sprintf("migration from repo version %d registered twice", m.Version))
+	}
+	ms[m.Version] = m
+}
+
+// Step is a single migration, in the direction it has to run.
+type Step struct {
+	From        int
+	To          int
+	Description string
+
+	run func(RepoPath) error
+}
+
+// Run applies the step to the repo at rp, and records the new version.
+func (s Step) Run(rp RepoPath) error {
+	if err := s.run(rp); err != nil {
+		return fmt.Errorf("migrating repo from version %d to %d: %s", s.From, s.To, err)
+	}
+	return rp.WriteVersion(strconv.Itoa(s.To))
+}
+
+// NoMigrationError is returned by Plan when no migration is known between
+// two adjacent versions.
+type NoMigrationError struct {
+	From int
+	To   int
+}
+
+func (e NoMigrationError) Error() string {
+	return fmt.Sprintf("no migration from repo version %d to %d", e.From, e.To)
+}
+
+// Plan returns the steps taking a repo from version from to version to, in
+// the order they have to run.
+func (ms Migrations) Plan(from, to int) ([]Step, error) {
+	var steps []Step
+	for v := from; v < to; v++ {
+		m, ok := ms[v]
+		if !ok {
+			return nil, NoMigrationError{From: v, To: v + 1}
+		}
+		steps = append(steps, Step{From: v, To: v + 1, Description: m.Description, run: m.Up})
+	}
+	for v := from; v > to; v-- {
+		m, ok := ms[v-1]
+		if !ok || m.Down == nil {
+			return nil, NoMigrationError{From: v, To: v - 1}
+		}
+		steps = append(steps, Step{From: v, To: v - 1, Description: m.Description, run: m.Down})
+	}
+	return steps, nil
+}
@@ -0,0 +1,112 @@
+package fsrepo
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
+	dsq "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/query"
+	mh "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-multihash"
+	bstore "github.com/ipfs/go-ipfs/blocks/blockstore"
+	"github.com/ipfs/go-ipfs/filestore"
+	repo "github.com/ipfs/go-ipfs/repo"
+	u "github.com/ipfs/go-ipfs/util"
+)
+
+// DatastoreStats reports the number of objects and the size on disk of the
+// datastores of the repo. Datastores kept outside the repo directory have
+// no Path and a zero Size. Objects are counted through the metrics
+// wrappers, so the queries show up in the datastore metrics as well.
+func (r *FSRepo) DatastoreStats() ([]repo.DatastoreStat, error) {
+	packageLock.Lock()
+	mounts := r.mounts
+	packageLock.Unlock()
+
+	var stats []repo.DatastoreStat
+	for _, m := range mounts {
+		n, blocks, err := countObjects(m)
+		if err != nil {
+			return nil, err
+		}
+		var size uint64
+		if m.dir != "" {
+			size, err = dirSize(m.dir)
+			if err != nil {
+				return nil, err
+			}
+		}
+		stats = append(stats, repo.DatastoreStat{
+			Name:       m.name,
+			Path:       m.dir,
+			NumObjects: n,
+			NumBlocks:  blocks,
+			Size:       size,
+		})
+	}
+	return stats, nil
+}
+
+// GetStorageUsage returns the combined size on disk of the datastores kept
+// in the repo directory. The directories are only walked the first time,
+// after that the size of the values written and deleted is accounted for.
+func (r *FSRepo) GetStorageUsage() (uint64, error) {
+	packageLock.Lock()
+	usage := r.usage
+	packageLock.Unlock()
+
+	if usage == nil {
+		return 0, errors.New("repo is closed")
+	}
+	return usage.get()
+}
+
+// countObjects returns the number of keys in m, and how many of them are
+// blocks of the blockstore or the filestore. Like
+// Blockstore.AllKeysChan, it skips block keys that are not multihashes.
+func countObjects(m *mountedDatastore) (n, blocks uint64, err error) {
+	res, err := m.metrics.Query(dsq.Query{KeysOnly: true})
+	if err != nil {
+		return 0, 0, err
+	}
+	defer res.Close()
+
+	blockPrefixes := []string{
+		bstore.BlockPrefix.String() + "/",
+		filestore.Prefix.String() + "/",
+	}
+	for e := range res.Next() {
+		if e.Error != nil {
+			return 0, 0, e.Error
+		}
+		n++
+		k := m.prefix.Child(ds.NewKey(e.Key)).String()
+		for _, p := range blockPrefixes {
+			if !strings.HasPrefix(k, p) {
+				continue
+			}
+			bk := u.KeyFromDsKey(ds.NewKey(k[len(p)-1:]))
+			if _, err := mh.Cast([]byte(bk)); err == nil {
+				blocks++
+			}
+			break
+		}
+	}
+	return n, blocks, nil
+}
+
+// dirSize returns the total size of the regular files below dir.
+func dirSize(dir string) (uint64, error) {
+	var size uint64
+	err := filepath.Walk(dir, func(_ string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.Mode().IsRegular() {
+			size += uint64(fi.Size())
+		}
+		return nil
+	})
+	return size, err
+}
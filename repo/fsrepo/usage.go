@@ -0,0 +1,155 @@
+package fsrepo
+
+import (
+	"sync"
+
+	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
+	repo "github.com/ipfs/go-ipfs/repo"
+)
+
+// storageUsage tracks the size of the datastores kept in the repo
+// directory, so that it is not walked every time it is asked for. The
+// directories are measured once, and then the size of every value written
+// is added, and the size of every value deleted or overwritten is taken
+// away.
+type storageUsage struct {
+	dirs []string
+
+	lk    sync.Mutex
+	known bool // bytes is up to date
+	bytes uint64
+	max   uint64 // writes above it are refused, zero for no limit
+}
+
+func (s *storageUsage) setMax(max uint64) {
+	s.lk.Lock()
+	s.max = max
+	s.lk.Unlock()
+}
+
+// get returns the size of the datastores.
+func (s *storageUsage) get() (uint64, error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	if err := s.measure(); err != nil {
+		return 0, err
+	}
+	return s.bytes, nil
+}
+
+// measure walks the directories if the size is not known. Caller must hold
+// lk.
+func (s *storageUsage) measure() error {
+	if s.known {
+		return nil
+	}
+	var total uint64
+	for _, dir := range s.dirs {
+		size, err := dirSize(dir)
+		if err != nil {
+			return err
+		}
+		total += size
+	}
+	s.bytes = total
+	s.known = true
+	return nil
+}
+
+// reserve accounts for n bytes about to be written. It returns
+// repo.ErrMaxStorageExceeded if they would take the size above max.
+func (s *storageUsage) reserve(n uint64) error {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	if s.max == 0 {
+		s.bytes += n
+		return nil
+	}
+	if err := s.measure(); err != nil {
+		return err
+	}
+	if s.bytes+n > s.max {
+		return repo.ErrMaxStorageExceeded
+	}
+	s.bytes += n
+	return nil
+}
+
+// release gives back n bytes, reserved for a write that failed or freed by
+// a delete.
+func (s *storageUsage) release(n uint64) {
+	s.lk.Lock()
+	if n > s.bytes {
+		n = s.bytes
+	}
+	s.bytes -= n
+	s.lk.Unlock()
+}
+
+func valueSize(val interface{}) uint64 {
+	if b, ok := val.([]byte); ok {
+		return uint64(len(b))
+	}
+	return 0
+}
+
+// usageDatastore updates a storageUsage with the writes to the datastore it
+// wraps.
+type usageDatastore struct {
+	ds.Datastore
+
+	usage *storageUsage
+}
+
+// storedSize returns the size of the value stored under key, zero if there
+// is none.
+func (d *usageDatastore) storedSize(key ds.Key) (uint64, error) {
+	has, err := d.Datastore.Has(key)
+	if err != nil || !has {
+		return 0, err
+	}
+	val, err := d.Datastore.Get(key)
+	switch err {
+	case nil:
+		return valueSize(val), nil
+	case ds.ErrNotFound:
+		return 0, nil
+	default:
+		return 0, err
+	}
+}
+
+func (d *usageDatastore) Put(key ds.Key, val interface{}) error {
+	old, err := d.storedSize(key)
+	if err != nil {
+		return err
+	}
+	n := valueSize(val)
+	var grow uint64
+	if n > old {
+		grow = n - old
+	}
+	if err := d.usage.reserve(grow); err != nil {
+		return err
+	}
+	if err := d.Datastore.Put(key, val); err != nil {
+		d.usage.release(grow)
+		return err
+	}
+	if old > n {
+		d.usage.release(old - n)
+	}
+	return nil
+}
+
+func (d *usageDatastore) Delete(key ds.Key) error {
+	n, err := d.storedSize(key)
+	if err != nil {
+		return err
+	}
+	if err := d.Datastore.Delete(key); err != nil {
+		return err
+	}
+	d.usage.release(n)
+	return nil
+}
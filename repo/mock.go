@@ -34,4 +34,8 @@ func (m *Mock) GetConfigKey(key string) (interface{}, error) {
 
 func (m *Mock) Datastore() ds.ThreadSafeDatastore { return m.D }
 
+func (m *Mock) DatastoreStats() ([]DatastoreStat, error) { return nil, errTODO }
+
+func (m *Mock) GetStorageUsage() (uint64, error) { return 0, errTODO }
+
 func (m *Mock) Close() error { return errTODO }
@@ -1,12 +1,17 @@
 package repo
 
 import (
+	"errors"
 	"io"
 
 	datastore "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
 	config "github.com/ipfs/go-ipfs/repo/config"
 )
 
+// ErrMaxStorageExceeded is returned when storing data would take the repo
+// above Datastore.StorageMax.
+var ErrMaxStorageExceeded = errors.New("Maximum storage limit exceeded. Maybe unpin some files?")
+
 type Repo interface {
 	Config() *config.Config
 	SetConfig(*config.Config) error
@@ -16,5 +21,23 @@ type Repo interface {
 
 	Datastore() datastore.ThreadSafeDatastore
 
+	// DatastoreStats reports the contents of each of the datastores that
+	// make up the repo.
+	DatastoreStats() ([]DatastoreStat, error)
+
+	// GetStorageUsage returns the number of bytes the repo's datastores
+	// use on disk. It may be an estimate kept up to date as data is
+	// written, rather than measured on every call.
+	GetStorageUsage() (uint64, error)
+
 	io.Closer
 }
+
+// DatastoreStat describes the storage used by one datastore of a repo.
+type DatastoreStat struct {
+	Name       string
+	Path       string // empty for datastores outside the repo
+	NumObjects uint64
+	NumBlocks  uint64 // blocks of the blockstore and the filestore
+	Size       uint64
+}
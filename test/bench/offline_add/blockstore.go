@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/flatfs"
+	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-random"
+	"github.com/ipfs/go-ipfs/blocks"
+	"github.com/ipfs/go-ipfs/blocks/blockstore"
+	"github.com/ipfs/go-ipfs/importer/chunk"
+	ds2 "github.com/ipfs/go-ipfs/util/datastore2"
+)
+
+// compareBlockstoreWrites compares storing blocks one Put at a time with
+// storing them in a single PutMany, on a flatfs datastore like the one
+// `ipfs add` writes to.
+func compareBlockstoreWrites() error {
+	put := func(bs blockstore.Blockstore, bks []*blocks.Block) error {
+		for _, b := range bks {
+			if err := bs.Put(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	putMany := func(bs blockstore.Blockstore, bks []*blocks.Block) error {
+		return bs.PutMany(bks)
+	}
+
+	for _, count := range []int{10, 100, 1000} {
+		single, err := benchmarkBlockstore(count, put)
+		if err != nil {
+			return err
+		}
+		batched, err := benchmarkBlockstore(count, putMany)
+		if err != nil {
+			return err
+		}
+		log.Println(count, "blocks\tPut:", single)
+		log.Println(count, "blocks\tPutMany:", batched)
+	}
+	return nil
+}
+
+func benchmarkBlockstore(count int, write func(blockstore.Blockstore, []*blocks.Block) error) (*testing.BenchmarkResult, error) {
+	results := testing.Benchmark(func(b *testing.B) {
+		b.SetBytes(int64(count * chunk.DefaultBlockSize))
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			writeOnce(b, count, write)
+		}
+	})
+	return &results, nil
+}
+
+// writeOnce times one write of count blocks into a new flatfs datastore,
+// which it removes before returning.
+func writeOnce(b *testing.B, count int, write func(blockstore.Blockstore, []*blocks.Block) error) {
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	fs, err := flatfs.New(tmpDir, 4)
+	if err != nil {
+		b.Fatal(err)
+	}
+	// batched like the repo batches its flatfs mount
+	bs := blockstore.NewBlockstore(ds2.ParallelBatching{
+		ThreadSafeDatastore: fs,
+		Workers:             16,
+	})
+
+	bks := make([]*blocks.Block, count)
+	for j := range bks {
+		var buf bytes.Buffer
+		if err := random.WritePseudoRandomBytes(int64(chunk.DefaultBlockSize), &buf, int64(j)); err != nil {
+			b.Fatal(err)
+		}
+		bks[j] = blocks.NewBlock(buf.Bytes())
+	}
+
+	b.StartTimer()
+	if err := write(bs, bks); err != nil {
+		b.Fatal(err)
+	}
+	b.StopTimer()
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -14,8 +15,15 @@ import (
 	"github.com/ipfs/go-ipfs/thirdparty/unit"
 )
 
+var blockstoreOnly = flag.Bool("blockstore", false, "compare Put and PutMany on a flatfs blockstore instead of running ipfs add")
+
 func main() {
-	if err := compareResults(); err != nil {
+	flag.Parse()
+	run := compareResults
+	if *blockstoreOnly {
+		run = compareBlockstoreWrites
+	}
+	if err := run(); err != nil {
 		log.Fatal(err)
 	}
 }
@@ -37,43 +45,49 @@ func benchmarkAdd(amount int64) (*testing.BenchmarkResult, error) {
 		b.SetBytes(amount)
 		for i := 0; i < b.N; i++ {
 			b.StopTimer()
-			tmpDir, err := ioutil.TempDir("", "")
-			if err != nil {
-				b.Fatal(err)
-			}
-			defer os.RemoveAll(tmpDir)
+			addOnce(b, amount)
+		}
+	})
+	return &results, nil
+}
 
-			env := append(os.Environ(), fmt.Sprintf("%s=%s", config.EnvDir, path.Join(tmpDir, config.DefaultPathName)))
-			setupCmd := func(cmd *exec.Cmd) {
-				cmd.Env = env
-			}
+// addOnce times one 'ipfs add' of amount bytes into a new repo, which it
+// removes before returning.
+func addOnce(b *testing.B, amount int64) {
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
 
-			cmd := exec.Command("ipfs", "init", "-f", "-b=1024")
-			setupCmd(cmd)
-			if err := cmd.Run(); err != nil {
-				b.Fatal(err)
-			}
+	env := append(os.Environ(), fmt.Sprintf("%s=%s", config.EnvDir, path.Join(tmpDir, config.DefaultPathName)))
+	setupCmd := func(cmd *exec.Cmd) {
+		cmd.Env = env
+	}
 
-			const seed = 1
-			f, err := ioutil.TempFile("", "")
-			if err != nil {
-				b.Fatal(err)
-			}
-			defer os.Remove(f.Name())
+	cmd := exec.Command("ipfs", "init", "-f", "-b=1024")
+	setupCmd(cmd)
+	if err := cmd.Run(); err != nil {
+		b.Fatal(err)
+	}
 
-			random.WritePseudoRandomBytes(amount, f, seed)
-			if err := f.Close(); err != nil {
-				b.Fatal(err)
-			}
+	const seed = 1
+	f, err := ioutil.TempFile("", "")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.Remove(f.Name())
 
-			b.StartTimer()
-			cmd = exec.Command("ipfs", "add", f.Name())
-			setupCmd(cmd)
-			if err := cmd.Run(); err != nil {
-				b.Fatal(err)
-			}
-			b.StopTimer()
-		}
-	})
-	return &results, nil
+	random.WritePseudoRandomBytes(amount, f, seed)
+	if err := f.Close(); err != nil {
+		b.Fatal(err)
+	}
+
+	b.StartTimer()
+	cmd = exec.Command("ipfs", "add", f.Name())
+	setupCmd(cmd)
+	if err := cmd.Run(); err != nil {
+		b.Fatal(err)
+	}
+	b.StopTimer()
 }
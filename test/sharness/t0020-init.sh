@@ -65,6 +65,36 @@ test_expect_success "clean up ipfs dir" '
 	rm -rf "$IPFS_PATH"
 '
 
+test_expect_success "ipfs init --encrypt needs a passphrase" '
+	rm -rf "$IPFS_PATH" &&
+	test_must_fail ipfs init -b 1024 --encrypt 2>encrypt_err &&
+	grep "repo datastore is encrypted" encrypt_err &&
+	test_must_fail test -f "$IPFS_PATH/datastore_spec"
+'
+
+test_expect_success "ipfs init --encrypt succeeds with a passphrase" '
+	IPFS_DATASTORE_PASSPHRASE=hunter2 ipfs init -b 1024 --encrypt >/dev/null &&
+	echo "encrypted content" >encrypted &&
+	ENCHASH=`IPFS_DATASTORE_PASSPHRASE=hunter2 ipfs add -q encrypted` &&
+	IPFS_DATASTORE_PASSPHRASE=hunter2 ipfs cat $ENCHASH >encrypted_actual &&
+	test_cmp encrypted encrypted_actual
+'
+
+test_expect_success "encrypted blocks are not stored in the clear" '
+	test_must_fail grep -r "encrypted content" "$IPFS_PATH/blocks"
+'
+
+test_expect_success "encrypted repo does not open without the right passphrase" '
+	test_must_fail ipfs cat $ENCHASH 2>nokey_err &&
+	grep "repo datastore is encrypted" nokey_err &&
+	test_must_fail env IPFS_DATASTORE_PASSPHRASE=wrong ipfs cat $ENCHASH 2>wrongkey_err &&
+	grep "wrong key for the encrypted repo datastore" wrongkey_err
+'
+
+test_expect_success "clean up encrypted ipfs dir" '
+	rm -rf "$IPFS_PATH"
+'
+
 test_init_ipfs
 
 test_launch_ipfs_daemon
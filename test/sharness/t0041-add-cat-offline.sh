@@ -31,4 +31,23 @@ test_expect_success "output looks good" '
 	test_cmp afile out_2
 '
 
+test_expect_success "ipfs add --hash succeeds" '
+	HASH512=$(ipfs add -q --hash sha2-512 afile) &&
+	HASHB2=$(ipfs add -q --hash blake2b afile)
+'
+
+test_expect_success "ipfs add --hash output looks good" '
+	test "$HASH512" = "8VukubAA5XpcY9RyuYXU9WsgKU2C7heqLLq7RTVr86SjUKtiNYMMRhaon86b8jTPZAQnRuiDEbA5gH8meq9TtpX51C" &&
+	test "$HASHB2" = "S2VCXdJN1YRRNK5bu84nyrxTuytyspEtt9zKkfRhGNZFjzDJuuLP3xn6b2ZgdkSe6Hkkpgif1HSumRfZZnYEx6WvDj"
+'
+
+test_expect_success "ipfs cat file added with --hash succeeds" '
+	ipfs cat $HASHB2 > out_3 &&
+	test_cmp afile out_3
+'
+
+test_expect_success "ipfs add --hash with unknown function fails" '
+	test_must_fail ipfs add --hash md5 afile
+'
+
 test_done
@@ -0,0 +1,48 @@
+#!/bin/sh
+#
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="Test add --nocopy and filestore commands"
+
+. lib/test-lib.sh
+
+test_init_ipfs
+
+test_expect_success "ipfs add --nocopy succeeds" '
+	random 1000000 42 >bigfile &&
+	HASH=$(ipfs add -q --nocopy "$(pwd)/bigfile")
+'
+
+test_expect_success "ipfs cat --nocopy file succeeds" '
+	ipfs cat $HASH >out &&
+	test_cmp bigfile out
+'
+
+test_expect_success "ipfs filestore ls lists the file" '
+	ipfs filestore ls >ls_out &&
+	grep "$(pwd)/bigfile" ls_out
+'
+
+test_expect_success "ipfs filestore verify succeeds" '
+	ipfs filestore verify -q >verify_out &&
+	test_must_be_empty verify_out
+'
+
+test_expect_success "ipfs filestore verify reports changed file" '
+	random 1000000 43 >bigfile &&
+	ipfs filestore verify -q >verify_out &&
+	grep "^changed .*bigfile" verify_out
+'
+
+test_expect_success "ipfs filestore verify reports missing file" '
+	rm bigfile &&
+	ipfs filestore verify -q >verify_out &&
+	grep "file is missing" verify_out
+'
+
+test_expect_success "ipfs add --nocopy from stdin fails" '
+	echo "stdin" | test_must_fail ipfs add --nocopy
+'
+
+test_done
@@ -0,0 +1,70 @@
+#!/bin/sh
+#
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="Test sharded directories"
+
+. lib/test-lib.sh
+
+test_init_ipfs
+
+test_expect_success "make a directory with 20 files" '
+	mkdir testdir &&
+	for i in `seq 20`; do
+		echo "file $i" >testdir/file$i || return 1
+	done &&
+	ls testdir | sort >expected_ls
+'
+
+test_expect_success "'ipfs add -r' does not shard by default" '
+	PLAIN=`ipfs add -r -q testdir | tail -n1` &&
+	ipfs object links $PLAIN | awk "NR > 1 {print \$3}" | sort >plain_links &&
+	test_cmp expected_ls plain_links
+'
+
+test_expect_success "set a sharding threshold of 5 entries" '
+	ipfs config --json Unixfs.ShardingThreshold 5
+'
+
+test_expect_success "'ipfs add -r' shards the directory" '
+	SHARDED=`ipfs add -r -q testdir | tail -n1` &&
+	test "$SHARDED" != "$PLAIN" &&
+	ipfs object links $SHARDED | awk "NR > 1 {print \$3}" | sort >sharded_links &&
+	test_must_fail test_cmp expected_ls sharded_links
+'
+
+test_expect_success "'ipfs ls' lists the entries of the sharded directory" '
+	ipfs ls $SHARDED | awk "{print \$3}" | sort >ls_actual &&
+	test_cmp expected_ls ls_actual
+'
+
+test_expect_success "'ipfs cat' resolves paths through the sharded directory" '
+	echo "file 17" >expected_cat &&
+	ipfs cat $SHARDED/file17 >actual_cat &&
+	test_cmp expected_cat actual_cat
+'
+
+test_expect_success "'ipfs cat' of a missing entry fails" '
+	test_must_fail ipfs cat $SHARDED/file21
+'
+
+test_expect_success "'ipfs get' writes the sharded directory" '
+	ipfs get -o gotdir $SHARDED >/dev/null &&
+	ls gotdir | sort >get_ls &&
+	test_cmp expected_ls get_ls &&
+	test_cmp testdir/file3 gotdir/file3
+'
+
+test_expect_success "'ipfs add -r' shards the same directory the same way" '
+	AGAIN=`ipfs add -r -q testdir | tail -n1` &&
+	test "$AGAIN" = "$SHARDED"
+'
+
+test_expect_success "a negative threshold disables sharding" '
+	ipfs config --json Unixfs.ShardingThreshold -- -1 &&
+	UNSHARDED=`ipfs add -r -q testdir | tail -n1` &&
+	test "$UNSHARDED" = "$PLAIN"
+'
+
+test_done
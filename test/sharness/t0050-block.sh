@@ -39,4 +39,19 @@ test_expect_success "'ipfs block get' output looks good" '
   test_cmp expected_stat actual_stat
 '
 
+test_expect_success "'ipfs block put --hash' succeeds" '
+	ipfs block put --hash blake2b <expected_in >actual_out
+'
+
+test_expect_success "'ipfs block put --hash' output looks good" '
+	HASHB2="S2YsypX1DAQTKSu6bgd5KfdoD2xz1uN8fDzroyjkaxdFiGG7exzLvk7dSZqJSBwY9sYiU4VyrmEd5RobhK3CQzoT1v" &&
+	echo "$HASHB2" >expected_out &&
+	test_cmp expected_out actual_out
+'
+
+test_expect_success "'ipfs block get' of a blake2b block succeeds" '
+	ipfs block get $HASHB2 >actual_in &&
+	test_cmp expected_in actual_in
+'
+
 test_done
@@ -84,6 +84,16 @@ test_object_cmd() {
 		test_cmp expected_putStdinOut actual_putPbStdinOut
 	'
 	
+	test_expect_success "'ipfs object put --hash' succeeds" '
+		ipfs object put --hash sha3 ../t0051-object-data/testPut.json > actual_putOut
+	'
+
+	test_expect_success "'ipfs object put --hash' output looks good" '
+		HASH="8tVQ9cP9y3mAS1eFt3WnwFBKSNLWQFNh9Caha3ABQTtNG424CsmKLy77UWgNBdKuNXrpZ2anxYcj24ywa1XNQfc1d1" &&
+		printf "added $HASH" > expected_putOut &&
+		test_cmp expected_putOut actual_putOut
+	'
+
 	test_expect_success "'ipfs object put broken.json' should fail" '
 		test_expect_code 1 ipfs object put ../t0051-object-data/brokenPut.json 2>actual_putBrokenErr >actual_putBroken
 	'
@@ -94,6 +104,79 @@ test_object_cmd() {
 		test_cmp expected_putBroken actual_putBroken &&
 		test_cmp expected_putBrokenErr actual_putBrokenErr
 	'
+
+	test_expect_success "'ipfs object diff' setup" '
+		rm -rf diff_v1 diff_v2 &&
+		mkdir -p diff_v1/sub diff_v2/sub &&
+		echo same >diff_v1/same && echo same >diff_v2/same &&
+		echo old >diff_v1/sub/changed && echo new >diff_v2/sub/changed &&
+		echo gone >diff_v1/sub/gone &&
+		echo added >diff_v2/added &&
+		V1=`ipfs add -r -q diff_v1 | tail -n1` &&
+		V2=`ipfs add -r -q diff_v2 | tail -n1`
+	'
+
+	test_expect_success "'ipfs object diff' succeeds" '
+		ipfs object diff $V1 $V2 >actual_diff
+	'
+
+	test_expect_success "'ipfs object diff' output looks good" '
+		echo "added added" >expected_diff &&
+		echo "modified sub/changed" >>expected_diff &&
+		echo "removed sub/gone" >>expected_diff &&
+		test_cmp expected_diff actual_diff
+	'
+
+	test_expect_success "'ipfs object diff' of the same object is empty" '
+		ipfs object diff $V1 /ipfs/$V1 >actual_diff_same &&
+		test_must_be_empty actual_diff_same
+	'
+
+	test_expect_success "'ipfs object new' succeeds" '
+		echo QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n >expected_new &&
+		ipfs object new >actual_new &&
+		test_cmp expected_new actual_new
+	'
+
+	test_expect_success "'ipfs object new unixfs-dir' succeeds" '
+		EMPTY_DIR=`ipfs object new unixfs-dir` &&
+		test "$EMPTY_DIR" = QmUNLLsPACCz1vLxQVkXqqLX5R1X345qqfHbsf67hvA3Nn &&
+		ipfs ls $EMPTY_DIR >actual_new_ls &&
+		test_must_be_empty actual_new_ls
+	'
+
+	test_expect_success "'ipfs object new' fails with an unknown template" '
+		test_must_fail ipfs object new beep 2>new_err &&
+		grep "unknown template \"beep\"" new_err
+	'
+
+	test_expect_success "'ipfs object patch add-link' needs --create for missing directories" '
+		echo "patched file" >patchme &&
+		PATCHFILE=`ipfs add -q patchme` &&
+		test_must_fail ipfs object patch add-link $EMPTY_DIR a/b/file $PATCHFILE
+	'
+
+	test_expect_success "'ipfs object patch add-link --create' succeeds" '
+		PATCHED=`ipfs object patch add-link --create $EMPTY_DIR a/b/file $PATCHFILE` &&
+		ipfs cat $PATCHED/a/b/file >patched_cat &&
+		test_cmp patchme patched_cat
+	'
+
+	test_expect_success "'ipfs object patch rm-link' succeeds" '
+		RMPATCHED=`ipfs object patch rm-link $PATCHED a/b/file` &&
+		echo "removed a/b/file" >expected_rm_diff &&
+		ipfs object diff $PATCHED $RMPATCHED >actual_rm_diff &&
+		test_cmp expected_rm_diff actual_rm_diff
+	'
+
+	test_expect_success "'ipfs object patch set-data' and 'append-data' succeed" '
+		printf "beep" >patch_data &&
+		DATAPATCHED=`ipfs object patch set-data $PATCHED patch_data` &&
+		DATAPATCHED=`printf " boop" | ipfs object patch append-data $DATAPATCHED` &&
+		printf "beep boop" >expected_patch_data &&
+		ipfs object data $DATAPATCHED >actual_patch_data &&
+		test_cmp expected_patch_data actual_patch_data
+	'
 }
 
 # should work offline
@@ -0,0 +1,54 @@
+#!/bin/sh
+#
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="Test dag export and import"
+
+. lib/test-lib.sh
+
+test_init_ipfs
+
+test_expect_success "add a DAG to export" '
+	random 1000000 42 >bigfile &&
+	BIGFILE=`ipfs add -q bigfile` &&
+	EMPTY_DIR=`ipfs object new unixfs-dir` &&
+	DAGROOT=`ipfs object patch add-link --create $EMPTY_DIR a/bigfile $BIGFILE`
+'
+
+test_expect_success "'ipfs dag export' succeeds" '
+	ipfs dag export $DAGROOT >data.dag
+'
+
+test_expect_success "import into a second repo" '
+	export IPFS_PATH="$(pwd)/.ipfs-import" &&
+	ipfs init -b 1024 >/dev/null
+'
+
+test_expect_success "'ipfs dag import' of a truncated archive fails" '
+	head -c 1000 data.dag >truncated.dag &&
+	test_must_fail ipfs dag import truncated.dag 2>import_err &&
+	grep "dag archive is truncated" import_err
+'
+
+test_expect_success "'ipfs dag import' of a corrupt archive fails" '
+	cp data.dag corrupt.dag &&
+	printf "X" | dd of=corrupt.dag bs=1 seek=5000 conv=notrunc 2>/dev/null &&
+	test_must_fail ipfs dag import corrupt.dag 2>import_err &&
+	grep "Data did not match given hash" import_err
+'
+
+test_expect_success "'ipfs dag import --pin' succeeds" '
+	echo "imported 7 blocks" >import_expected &&
+	echo "pinned root $DAGROOT" >>import_expected &&
+	ipfs dag import --pin <data.dag >import_actual &&
+	test_cmp import_expected import_actual
+'
+
+test_expect_success "imported DAG has the same hashes" '
+	ipfs cat $DAGROOT/a/bigfile >bigfile_actual &&
+	test_cmp bigfile bigfile_actual &&
+	ipfs pin ls --type=recursive | grep "$DAGROOT"
+'
+
+test_done
@@ -147,6 +147,154 @@ test_expect_success "'ipfs pin ls --type=all --quiet' is correct" '
 	test_sort_cmp allpins_uniq_hashes actual_allpins
 '
 
+test_expect_success "'ipfs repo stat' succeeds" '
+	ipfs repo stat >stat_out
+'
+
+test_expect_success "'ipfs repo stat' output looks good" '
+	grep "^blocks:" stat_out &&
+	grep "^leveldb:" stat_out &&
+	grep "^NumObjects" stat_out &&
+	grep "^RepoSize" stat_out &&
+	grep "^RepoPath" stat_out &&
+	grep "^Version" stat_out
+'
+
+test_expect_success "'ipfs repo stat' reports the blockstore caches" '
+	ipfs config --json Datastore.BloomFilterSize 1024 &&
+	ipfs config --json Datastore.ReadCacheSize 1048576 &&
+	ipfs repo stat >stat_out &&
+	ipfs config --json Datastore.BloomFilterSize 0 &&
+	ipfs config --json Datastore.ReadCacheSize 0 &&
+	grep "^bloom filter:" stat_out &&
+	grep "^read cache:" stat_out &&
+	grep "Hits" stat_out &&
+	grep "Misses" stat_out
+'
+
+test_expect_success "set a tiny storage limit" '
+	ipfs config Datastore.StorageMax 1kB
+'
+
+test_expect_success "'ipfs add' fails above the storage limit" '
+	echo "this will not fit" >nofit &&
+	echo "Error: Maximum storage limit exceeded. Maybe unpin some files?" >quota_expected &&
+	test_must_fail ipfs add nofit 2>quota_actual &&
+	test_cmp quota_expected quota_actual
+'
+
+test_expect_success "remove the storage limit" '
+	ipfs config Datastore.StorageMax "" &&
+	ipfs add -q nofit
+'
+
+test_expect_success "'ipfs repo verify' succeeds" '
+	ipfs repo verify --quiet >verify_actual
+'
+
+test_expect_success "'ipfs repo verify' looks good (empty)" '
+	true >empty &&
+	test_cmp empty verify_actual
+'
+
+test_expect_success "corrupt a stored block" '
+	echo "this block will be corrupted" >corruptme &&
+	CORRUPTHASH=`ipfs add -q corruptme` &&
+	BLOCKFILE=`grep -rl "this block will be corrupted" "$IPFS_PATH/blocks"` &&
+	echo "garbage" >"$BLOCKFILE"
+'
+
+test_expect_success "'ipfs pin verify' reports the broken pin" '
+	echo "broken $CORRUPTHASH" >pin_verify_expected &&
+	echo "  corrupt $CORRUPTHASH" >>pin_verify_expected &&
+	ipfs pin verify --quiet >pin_verify_actual &&
+	test_cmp pin_verify_expected pin_verify_actual
+'
+
+test_expect_success "'ipfs repo verify' reports the corrupt block" '
+	echo "corrupt $CORRUPTHASH" >verify_expected &&
+	ipfs repo verify --quiet >verify_actual &&
+	test_cmp verify_expected verify_actual
+'
+
+test_expect_success "corrupt block was moved out of the blockstore" '
+	ipfs repo verify --quiet >verify_actual2 &&
+	test_cmp empty verify_actual2
+'
+
 test_kill_ipfs_daemon
 
+test_expect_success "repo records its datastore layout" '
+	grep "\"ShardPrefixLen\":4" "$IPFS_PATH/datastore_spec"
+'
+
+test_expect_success "changing the flatfs shard length is refused" '
+	cp "$IPFS_PATH/config" config_backup &&
+	ipfs config --json Datastore.Mounts "[{\"Prefix\":\"/blocks\",\"Type\":\"flatfs\",\"Path\":\"blocks\",\"ShardPrefixLen\":2},{\"Prefix\":\"/\",\"Type\":\"leveldb\",\"Path\":\"datastore\"}]" &&
+	test_must_fail ipfs repo stat 2>spec_err &&
+	grep "datastore configuration does not match the repo on disk" spec_err
+'
+
+test_expect_success "restoring the layout makes the repo usable again" '
+	cp config_backup "$IPFS_PATH/config" &&
+	ipfs repo stat
+'
+
+test_expect_success "a repo without a recorded layout is only upgraded if it matches" '
+	ipfs config --json Datastore.Mounts "[{\"Prefix\":\"/blocks\",\"Type\":\"flatfs\",\"Path\":\"blocks\",\"ShardPrefixLen\":2},{\"Prefix\":\"/\",\"Type\":\"leveldb\",\"Path\":\"datastore\"}]" &&
+	mv "$IPFS_PATH/datastore_spec" spec_backup &&
+	test_must_fail ipfs repo stat 2>legacy_err &&
+	grep "datastore configuration does not match the repo on disk" legacy_err &&
+	test_must_fail test -f "$IPFS_PATH/datastore_spec" &&
+	cp config_backup "$IPFS_PATH/config" &&
+	ipfs repo stat &&
+	test_cmp spec_backup "$IPFS_PATH/datastore_spec"
+'
+
+test_expect_success "'ipfs repo migrate' on a current repo does nothing" '
+	echo "repo is already at the requested version" >migrate_expected &&
+	ipfs repo migrate >migrate_actual &&
+	test_cmp migrate_expected migrate_actual
+'
+
+test_expect_success "'ipfs repo migrate' fails without a known migration" '
+	test_must_fail ipfs repo migrate --dry-run --to 1 2>migrate_err &&
+	grep "no migration from repo version" migrate_err &&
+	ipfs repo stat
+'
+
+test_expect_success "'ipfs repo export' writes an archive" '
+	echo "exported content" >exportme &&
+	EXPORTHASH=`ipfs add -q exportme` &&
+	ipfs repo export repo.tar &&
+	tar tf repo.tar >export_files &&
+	head -n 1 export_files | grep "^ipfs-repo-export$" &&
+	grep "^blocks/$EXPORTHASH$" export_files
+'
+
+test_expect_success "exported config has no private key" '
+	tar xOf repo.tar config >export_config &&
+	test_must_fail grep "\"PrivKey\": \"[^\"]" export_config
+'
+
+test_expect_success "'ipfs repo import' restores blocks and pins in a new repo" '
+	IPFS_PATH="$(pwd)/.ipfs-import" &&
+	export IPFS_PATH &&
+	ipfs init -b 1024 >/dev/null &&
+	ipfs repo import repo.tar >import_out &&
+	grep "keeping the local identity" import_out &&
+	ipfs cat $EXPORTHASH >import_cat &&
+	test_cmp exportme import_cat &&
+	ipfs pin ls --type=recursive | grep $EXPORTHASH
+'
+
+test_expect_success "'ipfs repo import' rejects a block that does not match its hash" '
+	mkdir badexport &&
+	(cd badexport && tar xf ../repo.tar) &&
+	echo "garbage" >"badexport/blocks/$EXPORTHASH" &&
+	(cd badexport && tar cf ../bad.tar ipfs-repo-export blocks datastore config) &&
+	test_must_fail ipfs repo import bad.tar 2>import_err &&
+	grep "does not match its hash" import_err
+'
+
 test_done
@@ -246,6 +246,131 @@ test_expect_success "recursive pin fails without objects" '
 	grep "context deadline exceeded" err_expected8
 '
 
-# test_kill_ipfs_daemon
+test_expect_success "pin sets survive 'ipfs repo gc'" '
+	ipfs pin ls --type=all >pins_before &&
+	ipfs repo gc &&
+	ipfs pin ls --type=all >pins_after &&
+	test_sort_cmp pins_before pins_after
+'
+
+test_expect_success "pin sets survive migrating to the JSON pin format and back" '
+	ipfs repo migrate --to 2 &&
+	ipfs repo migrate &&
+	ipfs pin ls --type=all >pins_migrated &&
+	test_sort_cmp pins_before pins_migrated
+'
+
+test_expect_success "'ipfs pin update' moves a recursive pin" '
+	mkdir update_dir &&
+	echo "unchanged" >update_dir/same &&
+	echo "before" >update_dir/changed &&
+	HASH_UPDATE_OLD=`ipfs add -r -q update_dir | tail -n1` &&
+	echo "after" >update_dir/changed &&
+	HASH_UPDATE_NEW=`ipfs add -r -q update_dir | tail -n1` &&
+	ipfs pin rm -r "$HASH_UPDATE_NEW" &&
+	echo "updated $HASH_UPDATE_OLD to $HASH_UPDATE_NEW" >update_expected &&
+	ipfs pin update "$HASH_UPDATE_OLD" "$HASH_UPDATE_NEW" >update_actual &&
+	test_cmp update_expected update_actual &&
+	ipfs pin ls --type=recursive >update_pins &&
+	grep "$HASH_UPDATE_NEW" update_pins &&
+	test_must_fail grep "$HASH_UPDATE_OLD" update_pins
+'
+
+test_expect_success "'ipfs pin update --keep-old' keeps the old pin" '
+	ipfs pin update --keep-old "$HASH_UPDATE_NEW" "$HASH_UPDATE_OLD" &&
+	ipfs pin ls --type=recursive >update_pins &&
+	grep "$HASH_UPDATE_NEW" update_pins &&
+	grep "$HASH_UPDATE_OLD" update_pins
+'
+
+test_expect_success "'ipfs pin update' fails if the old object is not pinned recursively" '
+	ipfs pin rm -r "$HASH_UPDATE_OLD" &&
+	test_must_fail ipfs pin update "$HASH_UPDATE_OLD" "$HASH_UPDATE_NEW" 2>update_err &&
+	grep "is not pinned recursively" update_err
+'
+
+test_expect_success "'ipfs pin add --name --labels' records pin metadata" '
+	echo "named content" >named &&
+	HASH_NAMED=`ipfs add -q named` &&
+	ipfs pin add -r --name=site --labels=env=prod,team=web "$HASH_NAMED" &&
+	echo "$HASH_NAMED recursive \"site\" env=prod team=web" >named_expected &&
+	ipfs pin ls --name=site >named_actual &&
+	test_cmp named_expected named_actual
+'
+
+test_expect_success "'ipfs pin ls' filters pins by label" '
+	ipfs pin ls --labels=env=prod,team=web >label_actual &&
+	test_cmp named_expected label_actual &&
+	ipfs pin ls --labels=env=dev >label_none &&
+	test_must_be_empty label_none
+'
+
+test_expect_success "'ipfs pin ls' rejects invalid labels" '
+	test_must_fail ipfs pin ls --labels=env 2>label_err &&
+	grep "labels must be key=value" label_err
+'
+
+test_expect_success "'ipfs pin add --ttl' shows the remaining lifetime" '
+	echo "cached content" >cached &&
+	HASH_CACHED=`ipfs add -q cached` &&
+	ipfs pin add -r --ttl=72h "$HASH_CACHED" &&
+	ipfs pin ls --type=recursive >ttl_pins &&
+	grep "$HASH_CACHED recursive (expires in 71h59m" ttl_pins
+'
+
+test_expect_success "'ipfs repo gc' removes expired pins first" '
+	echo "short lived" >short &&
+	HASH_SHORT=`ipfs add -q short` &&
+	ipfs pin add -r --ttl=1s "$HASH_SHORT" &&
+	sleep 2 &&
+	ipfs repo gc >gc_ttl &&
+	grep "removed $HASH_SHORT" gc_ttl &&
+	ipfs pin ls --type=all >ttl_pins &&
+	test_must_fail grep "$HASH_SHORT" ttl_pins &&
+	grep "$HASH_CACHED" ttl_pins
+'
+
+test_expect_success "'ipfs pin add --ttl' rejects invalid durations" '
+	test_must_fail ipfs pin add -r --ttl=-1h "$HASH_CACHED" 2>ttl_err &&
+	grep "must be a positive duration" ttl_err
+'
+
+test_expect_success "'ipfs pin add --progress' reports fetched nodes" '
+	random 1000000 81 >progress_file &&
+	HASH_PROGRESS=`ipfs add -q progress_file` &&
+	ipfs pin rm -r "$HASH_PROGRESS" &&
+	ipfs pin add -r --progress "$HASH_PROGRESS" >progress_out 2>progress_err &&
+	echo "pinned $HASH_PROGRESS recursively" >progress_expected &&
+	test_cmp progress_expected progress_out &&
+	grep "fetched 5 nodes" progress_err
+'
+
+test_expect_success "'ipfs pin add --background' needs the daemon" '
+	test_must_fail ipfs pin add -r --background "$HASH_PROGRESS" 2>bg_err &&
+	grep "background pinning needs a running daemon" bg_err
+'
+
+test_launch_ipfs_daemon
+
+test_expect_success "'ipfs pin add --background' pins in the daemon" '
+	ipfs pin rm -r "$HASH_PROGRESS" &&
+	ipfs pin add -r --background "$HASH_PROGRESS" >bg_out &&
+	echo "pinning $HASH_PROGRESS recursively in the background" >bg_expected &&
+	test_cmp bg_expected bg_out
+'
+
+test_expect_success "'ipfs pin status' shows the background pin done" '
+	for i in 1 2 3 4 5; do
+		ipfs pin status "$HASH_PROGRESS" >status_out &&
+		grep "^$HASH_PROGRESS pinned$" status_out && break
+		sleep 1
+	done &&
+	echo "$HASH_PROGRESS pinned" >status_expected &&
+	test_cmp status_expected status_out &&
+	ipfs pin status >status_all &&
+	test_must_be_empty status_all
+'
+
+test_kill_ipfs_daemon
 
 test_done
@@ -0,0 +1,35 @@
+package blake2b
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestSum512(t *testing.T) {
+	// test vectors from RFC 7693 and the BLAKE2 reference implementation
+	long := make([]byte, 255)
+	for i := range long {
+		long[i] = byte(i)
+	}
+	cases := []struct {
+		in  []byte
+		out string
+	}{
+		{[]byte(""), "786a02f742015903c6c6fd852552d272912f4740e15847618a86e217f71f5419d25e1031afee585313896444934eb04b903a685b1448b755d56f701afe9be2ce"},
+		{[]byte("abc"), "ba80a53f981c4d0d6a2797b69f12f6e94c212f14685ac4b74b12bb6fdbffa2d17d87c5392aab792dc252d5de4533cc9518d38aa8dbf1925ab92386edd4009923"},
+		{[]byte("The quick brown fox jumps over the lazy dog"), "a8add4bdddfd93e4877d2746e62817b116364a1fa7bc148d95090bc7333b3673f82401cf7aa2e4cb1ecd90296e3f14cb5413f8ed77be73045b13914cdcd6a918"},
+	}
+	for _, c := range cases {
+		sum := Sum512(c.in)
+		if got := hex.EncodeToString(sum[:]); got != c.out {
+			t.Errorf("Sum512(%q) = %s, want %s", c.in, got, c.out)
+		}
+	}
+
+	// multiple blocks, with a partial last one
+	a := Sum512(long)
+	b := Sum512(long[:128])
+	if a == b {
+		t.Error("digests of different inputs should differ")
+	}
+}
@@ -0,0 +1,226 @@
+// Package cryptds encrypts the values of a datastore at rest. Keys are
+// stored as they are, so that content addressed keys keep working.
+package cryptds
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	datastore "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
+	query "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/query"
+	ds2 "github.com/ipfs/go-ipfs/util/datastore2"
+)
+
+var _ ds2.Batching = &Datastore{}
+
+var (
+	ErrInvalidType = errors.New("crypt datastore: invalid type error. this datastore only supports []byte values")
+	ErrKeySize     = errors.New("crypt datastore: key must be 32 bytes")
+
+	// ErrDecrypt is returned when a stored value was not encrypted with
+	// this key, or was changed since.
+	ErrDecrypt = errors.New("crypt datastore: cannot decrypt value, wrong key or corrupt data")
+)
+
+// KeySize is the size of the keys used by New, in bytes.
+const KeySize = 32
+
+// Number of PBKDF2 rounds used by DeriveKey.
+const deriveRounds = 65536
+
+// Datastore encrypts values with AES-256-GCM before handing them to its
+// child. Each value is sealed with a random nonce, and bound to its key so
+// that values can not be swapped between keys. It is safe for concurrent
+// use if its child is.
+type Datastore struct {
+	child datastore.Datastore
+	aead  cipher.AEAD
+}
+
+// New wraps child, encrypting values with key, which must be KeySize bytes.
+func New(child datastore.Datastore, key []byte) (*Datastore, error) {
+	if len(key) != KeySize {
+		return nil, ErrKeySize
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Datastore{child: child, aead: aead}, nil
+}
+
+// DeriveKey turns a secret, like a passphrase or the contents of a key
+// file, into a key for New, using PBKDF2-HMAC-SHA256 with salt.
+func DeriveKey(secret, salt []byte) []byte {
+	prf := hmac.New(sha256.New, secret)
+	prf.Write(salt)
+	prf.Write([]byte{0, 0, 0, 1})
+	u := prf.Sum(nil)
+
+	key := make([]byte, len(u))
+	copy(key, u)
+	for i := 1; i < deriveRounds; i++ {
+		prf.Reset()
+		prf.Write(u)
+		u = prf.Sum(u[:0])
+		for j := range key {
+			key[j] ^= u[j]
+		}
+	}
+	return key
+}
+
+// KeyCheck returns a value that identifies key without revealing it. Store
+// it next to the data to tell a wrong key from corrupt data.
+func KeyCheck(key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("crypt datastore key check"))
+	return mac.Sum(nil)
+}
+
+// Children implements datastore.Shim
+func (d *Datastore) Children() []datastore.Datastore {
+	return []datastore.Datastore{d.child}
+}
+
+func (d *Datastore) seal(key datastore.Key, value interface{}) ([]byte, error) {
+	data, ok := value.([]byte)
+	if !ok {
+		return nil, ErrInvalidType
+	}
+
+	ns := d.aead.NonceSize()
+	out := make([]byte, ns, ns+len(data)+d.aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, out); err != nil {
+		return nil, err
+	}
+	return d.aead.Seal(out, out, data, additionalData(key)), nil
+}
+
+func (d *Datastore) open(key datastore.Key, value interface{}) ([]byte, error) {
+	data, ok := value.([]byte)
+	if !ok {
+		return nil, ErrInvalidType
+	}
+
+	ns := d.aead.NonceSize()
+	if len(data) < ns {
+		return nil, ErrDecrypt
+	}
+	out, err := d.aead.Open(nil, data[:ns], data[ns:], additionalData(key))
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return out, nil
+}
+
+// additionalData binds a value to key.
+func additionalData(key datastore.Key) []byte {
+	k := key.Bytes()
+	ad := make([]byte, 8, 8+len(k))
+	binary.BigEndian.PutUint64(ad, uint64(len(k)))
+	return append(ad, k...)
+}
+
+func (d *Datastore) Put(key datastore.Key, value interface{}) error {
+	data, err := d.seal(key, value)
+	if err != nil {
+		return err
+	}
+	return d.child.Put(key, data)
+}
+
+func (d *Datastore) Get(key datastore.Key) (interface{}, error) {
+	v, err := d.child.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return d.open(key, v)
+}
+
+func (d *Datastore) Has(key datastore.Key) (bool, error) {
+	return d.child.Has(key)
+}
+
+func (d *Datastore) Delete(key datastore.Key) error {
+	return d.child.Delete(key)
+}
+
+// Query runs key only queries on the child. Other queries are decrypted
+// here, and filtered and ordered once decrypted.
+func (d *Datastore) Query(q query.Query) (query.Results, error) {
+	if q.KeysOnly {
+		return d.child.Query(q)
+	}
+
+	qr, err := d.child.Query(query.Query{Prefix: q.Prefix})
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan query.Result)
+	go func() {
+		defer close(ch)
+		defer qr.Close()
+
+		for r := range qr.Next() {
+			if r.Error == nil {
+				r.Entry.Value, r.Error = d.open(datastore.NewKey(r.Entry.Key), r.Entry.Value)
+			}
+			ch <- r
+		}
+	}()
+
+	res := query.ResultsReplaceQuery(query.DerivedResults(qr, ch), q)
+	q.Prefix = ""
+	return query.NaiveQueryApply(q, res), nil
+}
+
+// Batch returns a batch of the child datastore, encrypting values on the
+// way in.
+func (d *Datastore) Batch() (ds2.Batch, error) {
+	b, err := ds2.BatchFor(d.child)
+	if err != nil {
+		return nil, err
+	}
+	return &cryptBatch{dst: b, d: d}, nil
+}
+
+// Close closes the child datastore, if it can be closed.
+func (d *Datastore) Close() error {
+	if c, ok := d.child.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+type cryptBatch struct {
+	dst ds2.Batch
+	d   *Datastore
+}
+
+func (b *cryptBatch) Put(key datastore.Key, value interface{}) error {
+	data, err := b.d.seal(key, value)
+	if err != nil {
+		return err
+	}
+	return b.dst.Put(key, data)
+}
+
+func (b *cryptBatch) Delete(key datastore.Key) error {
+	return b.dst.Delete(key)
+}
+
+func (b *cryptBatch) Commit() error {
+	return b.dst.Commit()
+}
@@ -0,0 +1,89 @@
+package cryptds
+
+import (
+	"bytes"
+	"testing"
+
+	datastore "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
+	query "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/query"
+	"github.com/ipfs/go-ipfs/thirdparty/assert"
+)
+
+func newTestDatastore(t *testing.T, secret string) (*Datastore, datastore.Datastore) {
+	child := datastore.NewMapDatastore()
+	d, err := New(child, DeriveKey([]byte(secret), []byte("salt")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d, child
+}
+
+func TestPutGetEncrypts(t *testing.T) {
+	d, child := newTestDatastore(t, "secret")
+	key, val := datastore.NewKey("foo"), []byte("bar")
+	assert.Nil(d.Put(key, val), t)
+
+	raw, err := child.Get(key)
+	assert.Nil(err, t)
+	assert.False(bytes.Contains(raw.([]byte), val), t, "child should not see the plain value")
+
+	v, err := d.Get(key)
+	assert.Nil(err, t)
+	assert.True(bytes.Equal(v.([]byte), val), t, "should read back the plain value")
+}
+
+func TestWrongKeyFails(t *testing.T) {
+	d, child := newTestDatastore(t, "secret")
+	key := datastore.NewKey("foo")
+	assert.Nil(d.Put(key, []byte("bar")), t)
+
+	other, err := New(child, DeriveKey([]byte("other"), []byte("salt")))
+	assert.Nil(err, t)
+	_, err = other.Get(key)
+	assert.True(err == ErrDecrypt, t, "should not decrypt with another key")
+}
+
+func TestValuesAreBoundToKeys(t *testing.T) {
+	d, child := newTestDatastore(t, "secret")
+	a, b := datastore.NewKey("a"), datastore.NewKey("b")
+	assert.Nil(d.Put(a, []byte("value of a")), t)
+
+	raw, err := child.Get(a)
+	assert.Nil(err, t)
+	assert.Nil(child.Put(b, raw), t)
+	_, err = d.Get(b)
+	assert.True(err == ErrDecrypt, t, "value moved to another key should not decrypt")
+}
+
+func TestQueryDecrypts(t *testing.T) {
+	d, _ := newTestDatastore(t, "secret")
+	assert.Nil(d.Put(datastore.NewKey("/a/1"), []byte("one")), t)
+	assert.Nil(d.Put(datastore.NewKey("/a/2"), []byte("two")), t)
+	assert.Nil(d.Put(datastore.NewKey("/b/3"), []byte("three")), t)
+
+	res, err := d.Query(query.Query{Prefix: "/a"})
+	assert.Nil(err, t)
+	entries, err := res.Rest()
+	assert.Nil(err, t)
+	assert.True(len(entries) == 2, t, "should only return keys under /a")
+	for _, e := range entries {
+		v := string(e.Value.([]byte))
+		assert.True(v == "one" || v == "two", t, "should return plain values")
+	}
+}
+
+func TestBatchEncrypts(t *testing.T) {
+	d, child := newTestDatastore(t, "secret")
+	b, err := d.Batch()
+	assert.Nil(err, t)
+	key, val := datastore.NewKey("foo"), []byte("bar")
+	assert.Nil(b.Put(key, val), t)
+	assert.Nil(b.Commit(), t)
+
+	raw, err := child.Get(key)
+	assert.Nil(err, t)
+	assert.False(bytes.Equal(raw.([]byte), val), t, "batch should encrypt values")
+	v, err := d.Get(key)
+	assert.Nil(err, t)
+	assert.True(bytes.Equal(v.([]byte), val), t, "should read back the batched value")
+}
@@ -14,6 +14,7 @@ const (
 	TFile      = pb.Data_File
 	TDirectory = pb.Data_Directory
 	TMetadata  = pb.Data_Metadata
+	THAMTShard = pb.Data_HAMTShard
 )
 
 var ErrMalformedFileFormat = errors.New("malformed data in file format")
@@ -62,6 +63,23 @@ func FolderPBData() []byte {
 	return data
 }
 
+// HAMTShardPBData returns the data of a node of a sharded directory with
+// the given bitfield of used buckets.
+func HAMTShardPBData(bitfield []byte, hashType, fanout uint64) []byte {
+	pbfile := new(pb.Data)
+	typ := pb.Data_HAMTShard
+	pbfile.Type = &typ
+	pbfile.Data = bitfield
+	pbfile.HashType = proto.Uint64(hashType)
+	pbfile.Fanout = proto.Uint64(fanout)
+
+	data, err := proto.Marshal(pbfile)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
 func WrapData(b []byte) []byte {
 	pbdata := new(pb.Data)
 	typ := pb.Data_Raw
@@ -94,7 +112,7 @@ func DataSize(data []byte) (uint64, error) {
 	}
 
 	switch pbdata.GetType() {
-	case pb.Data_Directory:
+	case pb.Data_Directory, pb.Data_HAMTShard:
 		return 0, errors.New("Cant get data size of directory!")
 	case pb.Data_File:
 		return pbdata.GetFilesize(), nil
@@ -0,0 +1,424 @@
+// Package hamt implements sharded unixfs directories.
+//
+// A sharded directory is a hash array mapped trie of shards. A shard has
+// fanout buckets, and an entry goes in the bucket picked by the bits of
+// the hash of its name at the depth of the shard. Each used bucket holds
+// either one entry or a child shard with the entries that share it, so a
+// directory has the same shape whatever order its entries were added in.
+//
+// The data of a shard node is a unixfs HAMTShard holding the bitfield of
+// its used buckets. Its links follow the buckets, and are named by the
+// bucket index in uppercase hex, padded to the width of the largest one:
+// the index alone for a child shard, the index followed by the name of the
+// entry for an entry.
+package hamt
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+
+	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+
+	mdag "github.com/ipfs/go-ipfs/merkledag"
+	ft "github.com/ipfs/go-ipfs/unixfs"
+)
+
+const (
+	// DefaultFanout is the number of buckets of the shards made by
+	// NewShard.
+	DefaultFanout = 256
+
+	// HashFnv1a64 identifies 64-bit FNV-1a as the hash function of a
+	// shard. The bits past the first 64 come from the hashes of the name
+	// followed by a byte counting the extra hashes.
+	HashFnv1a64 = 1
+)
+
+var (
+	ErrInvalidFanout = errors.New("hamt: fanout must be 2, 4, 16 or 256")
+	ErrNotShard      = errors.New("hamt: node is not a directory shard")
+)
+
+// Shard is a shard of a sharded directory. Child shards are loaded from
+// the DAGService as they are needed.
+type Shard struct {
+	dserv mdag.DAGService
+
+	fanout int
+	bits   uint // of the hash used at each depth
+	padLen int
+	depth  int
+
+	bitfield []byte
+	// children has one child for each set bit of bitfield, in bucket
+	// order
+	children []*child
+
+	// hashFunc is the multihash function of the shard nodes
+	hashFunc int
+
+	// nd is the node of the shard, nil once it changed
+	nd *mdag.Node
+}
+
+// child is an entry, named name, or a child shard, which is loaded from
+// link when shard is nil.
+type child struct {
+	name  string
+	link  *mdag.Link
+	shard *Shard
+	isDir bool
+}
+
+// NewShard returns an empty sharded directory with fanout buckets in each
+// shard.
+func NewShard(dserv mdag.DAGService, fanout int) (*Shard, error) {
+	bits, err := fanoutBits(fanout)
+	if err != nil {
+		return nil, err
+	}
+	return newShard(dserv, fanout, bits, 0), nil
+}
+
+func newShard(dserv mdag.DAGService, fanout int, bits uint, depth int) *Shard {
+	return &Shard{
+		dserv:    dserv,
+		fanout:   fanout,
+		bits:     bits,
+		padLen:   len(fmt.Sprintf("%X", fanout-1)),
+		depth:    depth,
+		bitfield: make([]byte, (fanout+7)/8),
+	}
+}
+
+// NewShardFromNode returns the sharded directory with root nd.
+func NewShardFromNode(dserv mdag.DAGService, nd *mdag.Node) (*Shard, error) {
+	return loadShard(dserv, nd, 0)
+}
+
+// IsShard returns whether nd is a node of a sharded directory.
+func IsShard(nd *mdag.Node) bool {
+	pbd, err := ft.FromBytes(nd.Data)
+	return err == nil && pbd.GetType() == ft.THAMTShard
+}
+
+func loadShard(dserv mdag.DAGService, nd *mdag.Node, depth int) (*Shard, error) {
+	pbd, err := ft.FromBytes(nd.Data)
+	if err != nil || pbd.GetType() != ft.THAMTShard {
+		return nil, ErrNotShard
+	}
+	if pbd.GetHashType() != HashFnv1a64 {
+		return nil, fmt.Errorf("hamt: unsupported hash function %d", pbd.GetHashType())
+	}
+	fanout := int(pbd.GetFanout())
+	bits, err := fanoutBits(fanout)
+	if err != nil {
+		return nil, err
+	}
+
+	s := newShard(dserv, fanout, bits, depth)
+	if len(pbd.GetData()) != len(s.bitfield) {
+		return nil, errors.New("hamt: invalid shard bitfield")
+	}
+	copy(s.bitfield, pbd.GetData())
+	s.hashFunc = nd.HashFunc()
+
+	last := -1
+	for _, l := range nd.Links {
+		if len(l.Name) < s.padLen {
+			return nil, fmt.Errorf("hamt: invalid shard link name %q", l.Name)
+		}
+		idx, err := strconv.ParseUint(l.Name[:s.padLen], 16, 32)
+		if err != nil || int(idx) >= fanout || int(idx) <= last || !s.isSet(int(idx)) {
+			return nil, fmt.Errorf("hamt: invalid shard link name %q", l.Name)
+		}
+		last = int(idx)
+
+		c := &child{link: l}
+		if len(l.Name) == s.padLen {
+			c.isDir = true
+		} else {
+			c.name = l.Name[s.padLen:]
+		}
+		s.children = append(s.children, c)
+	}
+	if len(s.children) != s.popCount(fanout) {
+		return nil, errors.New("hamt: shard links do not match its bitfield")
+	}
+
+	s.nd = nd
+	return s, nil
+}
+
+func fanoutBits(fanout int) (uint, error) {
+	switch fanout {
+	case 2:
+		return 1, nil
+	case 4:
+		return 2, nil
+	case 16:
+		return 4, nil
+	case 256:
+		return 8, nil
+	}
+	return 0, ErrInvalidFanout
+}
+
+// SetHashFunc sets the multihash function the shard nodes are hashed with.
+func (s *Shard) SetHashFunc(code int) {
+	s.hashFunc = code
+	s.nd = nil
+}
+
+// Set links the entry name to nd, replacing any entry of that name.
+func (s *Shard) Set(ctx context.Context, name string, nd *mdag.Node) error {
+	lnk, err := mdag.MakeLink(nd)
+	if err != nil {
+		return err
+	}
+	return s.SetLink(ctx, name, lnk)
+}
+
+// SetLink is Set for a node known by its link.
+func (s *Shard) SetLink(ctx context.Context, name string, lnk *mdag.Link) error {
+	if name == "" {
+		return errors.New("hamt: empty entry name")
+	}
+	return s.setLink(ctx, name, &mdag.Link{Name: name, Size: lnk.Size, Hash: lnk.Hash})
+}
+
+func (s *Shard) setLink(ctx context.Context, name string, lnk *mdag.Link) error {
+	idx := s.bucket(name)
+	if !s.isSet(idx) {
+		s.insertChild(idx, &child{name: name, link: lnk})
+		s.nd = nil
+		return nil
+	}
+
+	c := s.children[s.childIndex(idx)]
+	switch {
+	case c.isDir:
+		sub, err := s.loadChild(ctx, c)
+		if err != nil {
+			return err
+		}
+		if err := sub.setLink(ctx, name, lnk); err != nil {
+			return err
+		}
+	case c.name == name:
+		c.link = lnk
+	default:
+		// two entries in one bucket, move both to a child shard
+		sub := newShard(s.dserv, s.fanout, s.bits, s.depth+1)
+		sub.hashFunc = s.hashFunc
+		if err := sub.setLink(ctx, c.name, c.link); err != nil {
+			return err
+		}
+		if err := sub.setLink(ctx, name, lnk); err != nil {
+			return err
+		}
+		*c = child{shard: sub, isDir: true}
+	}
+	s.nd = nil
+	return nil
+}
+
+// Remove removes the entry name, or returns merkledag.ErrNotFound.
+func (s *Shard) Remove(ctx context.Context, name string) error {
+	idx := s.bucket(name)
+	if !s.isSet(idx) {
+		return mdag.ErrNotFound
+	}
+
+	ci := s.childIndex(idx)
+	c := s.children[ci]
+	if !c.isDir {
+		if c.name != name {
+			return mdag.ErrNotFound
+		}
+		s.clearBit(idx)
+		s.children = append(s.children[:ci], s.children[ci+1:]...)
+		s.nd = nil
+		return nil
+	}
+
+	sub, err := s.loadChild(ctx, c)
+	if err != nil {
+		return err
+	}
+	if err := sub.Remove(ctx, name); err != nil {
+		return err
+	}
+	// a child shard keeps at least two entries
+	if len(sub.children) == 1 && !sub.children[0].isDir {
+		*c = *sub.children[0]
+	}
+	s.nd = nil
+	return nil
+}
+
+// Find returns the link to the entry name, or merkledag.ErrNotFound.
+func (s *Shard) Find(ctx context.Context, name string) (*mdag.Link, error) {
+	idx := s.bucket(name)
+	if !s.isSet(idx) {
+		return nil, mdag.ErrNotFound
+	}
+
+	c := s.children[s.childIndex(idx)]
+	if !c.isDir {
+		if c.name != name {
+			return nil, mdag.ErrNotFound
+		}
+		return copyLink(c.link, name), nil
+	}
+	sub, err := s.loadChild(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	return sub.Find(ctx, name)
+}
+
+// ForEachLink calls f with the link to each entry, named by the entry, in
+// the order of the shards.
+func (s *Shard) ForEachLink(ctx context.Context, f func(*mdag.Link) error) error {
+	for _, c := range s.children {
+		if !c.isDir {
+			if err := f(copyLink(c.link, c.name)); err != nil {
+				return err
+			}
+			continue
+		}
+		sub, err := s.loadChild(ctx, c)
+		if err != nil {
+			return err
+		}
+		if err := sub.ForEachLink(ctx, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Links returns the links to every entry, named by the entries.
+func (s *Shard) Links(ctx context.Context) ([]*mdag.Link, error) {
+	var links []*mdag.Link
+	err := s.ForEachLink(ctx, func(l *mdag.Link) error {
+		links = append(links, l)
+		return nil
+	})
+	return links, err
+}
+
+// Node returns the root node of the directory. The child shards that
+// changed since they were loaded are added to the DAGService, the root is
+// left to the caller. The node must not be modified.
+func (s *Shard) Node() (*mdag.Node, error) {
+	if s.nd != nil {
+		return s.nd, nil
+	}
+
+	nd := &mdag.Node{Data: ft.HAMTShardPBData(s.bitfield, HashFnv1a64, uint64(s.fanout))}
+	nd.SetHashFunc(s.hashFunc)
+
+	ci := 0
+	for idx := 0; idx < s.fanout; idx++ {
+		if !s.isSet(idx) {
+			continue
+		}
+		c := s.children[ci]
+		ci++
+
+		prefix := fmt.Sprintf("%0*X", s.padLen, idx)
+		if !c.isDir {
+			nd.Links = append(nd.Links, copyLink(c.link, prefix+c.name))
+			continue
+		}
+		if c.shard != nil && c.shard.nd == nil {
+			cnd, err := c.shard.Node()
+			if err != nil {
+				return nil, err
+			}
+			if _, err := s.dserv.Add(cnd); err != nil {
+				return nil, err
+			}
+			c.link, err = mdag.MakeLink(cnd)
+			if err != nil {
+				return nil, err
+			}
+		}
+		nd.Links = append(nd.Links, copyLink(c.link, prefix))
+	}
+
+	s.nd = nd
+	return nd, nil
+}
+
+func (s *Shard) loadChild(ctx context.Context, c *child) (*Shard, error) {
+	if c.shard != nil {
+		return c.shard, nil
+	}
+	nd, err := c.link.GetNode(ctx, s.dserv)
+	if err != nil {
+		return nil, err
+	}
+	sub, err := loadShard(s.dserv, nd, s.depth+1)
+	if err != nil {
+		return nil, err
+	}
+	if sub.fanout != s.fanout {
+		return nil, errors.New("hamt: child shard has another fanout")
+	}
+	c.shard = sub
+	return sub, nil
+}
+
+// bucket returns the bucket of name in s, from the bits of its hash at
+// the depth of s.
+func (s *Shard) bucket(name string) int {
+	offset := uint(s.depth) * s.bits
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	if extra := offset / 64; extra > 0 {
+		h.Write([]byte{byte(extra)})
+	}
+	shift := 64 - s.bits - offset%64
+	return int(h.Sum64()>>shift) & (s.fanout - 1)
+}
+
+func (s *Shard) isSet(idx int) bool {
+	return s.bitfield[idx/8]&(1<<uint(idx%8)) != 0
+}
+
+func (s *Shard) clearBit(idx int) {
+	s.bitfield[idx/8] &^= 1 << uint(idx%8)
+}
+
+// popCount returns the number of set bits below idx.
+func (s *Shard) popCount(idx int) int {
+	n := 0
+	for i := 0; i < idx; i++ {
+		if s.isSet(i) {
+			n++
+		}
+	}
+	return n
+}
+
+// childIndex returns the index in children of the child in bucket idx.
+func (s *Shard) childIndex(idx int) int {
+	return s.popCount(idx)
+}
+
+func (s *Shard) insertChild(idx int, c *child) {
+	ci := s.childIndex(idx)
+	s.bitfield[idx/8] |= 1 << uint(idx%8)
+	s.children = append(s.children, nil)
+	copy(s.children[ci+1:], s.children[ci:])
+	s.children[ci] = c
+}
+
+func copyLink(l *mdag.Link, name string) *mdag.Link {
+	return &mdag.Link{Name: name, Size: l.Size, Hash: l.Hash}
+}
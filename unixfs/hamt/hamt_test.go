@@ -0,0 +1,203 @@
+package hamt
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+
+	mdag "github.com/ipfs/go-ipfs/merkledag"
+	mdtest "github.com/ipfs/go-ipfs/merkledag/test"
+)
+
+func entryNames(n int) []string {
+	var names []string
+	for i := 0; i < n; i++ {
+		names = append(names, fmt.Sprintf("entry%d", i))
+	}
+	return names
+}
+
+func entryNode(name string) *mdag.Node {
+	return &mdag.Node{Data: []byte(name)}
+}
+
+func makeShard(t *testing.T, ds mdag.DAGService, fanout int, names []string) *Shard {
+	ctx := context.Background()
+	s, err := NewShard(ds, fanout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range names {
+		if err := s.Set(ctx, name, entryNode(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+func shardKey(t *testing.T, s *Shard) string {
+	nd, err := s.Node()
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := nd.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k.B58String()
+}
+
+func checkEntries(t *testing.T, s *Shard, names []string) {
+	ctx := context.Background()
+	for _, name := range names {
+		l, err := s.Find(ctx, name)
+		if err != nil {
+			t.Fatalf("finding %s: %s", name, err)
+		}
+		k, _ := entryNode(name).Key()
+		if l.Name != name || l.Hash.B58String() != k.B58String() {
+			t.Fatalf("wrong link for %s: %s %s", name, l.Name, l.Hash.B58String())
+		}
+	}
+
+	links, err := s.Links(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found []string
+	for _, l := range links {
+		found = append(found, l.Name)
+	}
+	sort.Strings(found)
+	expected := append([]string(nil), names...)
+	sort.Strings(expected)
+	if fmt.Sprint(found) != fmt.Sprint(expected) {
+		t.Fatalf("expected entries %v, got %v", expected, found)
+	}
+}
+
+func TestShardSetFind(t *testing.T) {
+	for _, fanout := range []int{2, 16, 256} {
+		ds := mdtest.Mock(t)
+		names := entryNames(500)
+		s := makeShard(t, ds, fanout, names)
+		checkEntries(t, s, names)
+
+		if _, err := s.Find(context.Background(), "missing"); err != mdag.ErrNotFound {
+			t.Fatal("expected ErrNotFound, got", err)
+		}
+	}
+
+	if _, err := NewShard(mdtest.Mock(t), 3); err != ErrInvalidFanout {
+		t.Fatal("expected ErrInvalidFanout, got", err)
+	}
+}
+
+func TestShardSetReplaces(t *testing.T) {
+	ctx := context.Background()
+	s := makeShard(t, mdtest.Mock(t), 16, entryNames(50))
+
+	other := &mdag.Node{Data: []byte("other")}
+	if err := s.Set(ctx, "entry7", other); err != nil {
+		t.Fatal(err)
+	}
+	l, err := s.Find(ctx, "entry7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, _ := other.Key()
+	if l.Hash.B58String() != k.B58String() {
+		t.Fatal("entry was not replaced")
+	}
+	links, err := s.Links(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(links) != 50 {
+		t.Fatalf("expected 50 entries, got %d", len(links))
+	}
+}
+
+func TestShardShapeIsCanonical(t *testing.T) {
+	names := entryNames(300)
+	a := makeShard(t, mdtest.Mock(t), 16, names)
+
+	shuffled := append([]string(nil), names...)
+	for i, j := range rand.Perm(len(shuffled)) {
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	}
+	// a hundred extra entries, removed again
+	extra := entryNames(400)[300:]
+	b := makeShard(t, mdtest.Mock(t), 16, append(shuffled, extra...))
+	for _, name := range extra {
+		if err := b.Remove(context.Background(), name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if shardKey(t, a) != shardKey(t, b) {
+		t.Fatal("shards with the same entries have different hashes")
+	}
+}
+
+func TestShardRemove(t *testing.T) {
+	ctx := context.Background()
+	names := entryNames(200)
+	s := makeShard(t, mdtest.Mock(t), 16, names)
+
+	for _, name := range names[:150] {
+		if err := s.Remove(ctx, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Remove(ctx, names[0]); err != mdag.ErrNotFound {
+		t.Fatal("expected ErrNotFound, got", err)
+	}
+	checkEntries(t, s, names[150:])
+}
+
+func TestShardFromNode(t *testing.T) {
+	ctx := context.Background()
+	ds := mdtest.Mock(t)
+	names := entryNames(300)
+	s := makeShard(t, ds, 16, names)
+	nd, err := s.Node()
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := ds.Add(nd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nd, err = ds.Get(ctx, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsShard(nd) {
+		t.Fatal("expected a shard node")
+	}
+	loaded, err := NewShardFromNode(ds, nd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEntries(t, loaded, names)
+
+	// modifying a loaded shard only rebuilds the changed shards
+	if err := loaded.Remove(ctx, "entry3"); err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.Set(ctx, "entry3", entryNode("entry3")); err != nil {
+		t.Fatal(err)
+	}
+	if shardKey(t, loaded) != k.B58String() {
+		t.Fatal("shard changed after removing and setting an entry")
+	}
+
+	if IsShard(&mdag.Node{Data: []byte("not unixfs")}) {
+		t.Fatal("expected a non-unixfs node not to be a shard")
+	}
+}
@@ -1,18 +1,36 @@
 package io
 
 import (
+	"errors"
+	"sort"
 	"time"
 
 	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
 
 	mdag "github.com/ipfs/go-ipfs/merkledag"
+	dagutils "github.com/ipfs/go-ipfs/merkledag/utils"
 	format "github.com/ipfs/go-ipfs/unixfs"
+	hamt "github.com/ipfs/go-ipfs/unixfs/hamt"
 	u "github.com/ipfs/go-ipfs/util"
 )
 
-type directoryBuilder struct {
-	dserv   mdag.DAGService
+var ErrNotDirectory = errors.New("this dag node is not a directory")
+
+// Directory builds or modifies a unixfs directory. A directory with more
+// entries than its shard threshold is turned into a sharded directory.
+type Directory struct {
+	dserv mdag.DAGService
+
+	// dirnode is the plain directory node, nil once the directory is
+	// sharded
 	dirnode *mdag.Node
+	shard   *hamt.Shard
+	// entries is the number of entries of a sharded directory, -1 until
+	// they are counted
+	entries int
+
+	threshold int
+	hashFunc  int
 }
 
 // NewEmptyDirectory returns an empty merkledag Node with a folder Data chunk
@@ -20,16 +38,64 @@ func NewEmptyDirectory() *mdag.Node {
 	return &mdag.Node{Data: format.FolderPBData()}
 }
 
-// NewDirectory returns a directoryBuilder. It needs a DAGService to add the Children
-func NewDirectory(dserv mdag.DAGService) *directoryBuilder {
-	db := new(directoryBuilder)
+func init() {
+	if err := dagutils.RegisterTemplate("unixfs-dir", NewEmptyDirectory); err != nil {
+		panic(err)
+	}
+}
+
+// NewDirectory returns a Directory. It needs a DAGService to add the Children
+func NewDirectory(dserv mdag.DAGService) *Directory {
+	db := new(Directory)
 	db.dserv = dserv
 	db.dirnode = NewEmptyDirectory()
 	return db
 }
 
+// NewDirectoryFromNode returns a Directory that modifies the plain or
+// sharded directory nd.
+func NewDirectoryFromNode(dserv mdag.DAGService, nd *mdag.Node) (*Directory, error) {
+	db := &Directory{dserv: dserv, hashFunc: nd.HashFunc()}
+	if hamt.IsShard(nd) {
+		shard, err := hamt.NewShardFromNode(dserv, nd)
+		if err != nil {
+			return nil, err
+		}
+		db.shard = shard
+		db.entries = -1
+		return db, nil
+	}
+
+	pbd, err := format.FromBytes(nd.Data)
+	if err != nil {
+		return nil, err
+	}
+	if pbd.GetType() != format.TDirectory {
+		return nil, ErrNotDirectory
+	}
+	db.dirnode = nd.Copy()
+	return db, nil
+}
+
+// SetShardThreshold makes the directory sharded once it has more than n
+// entries, and plain again once it has n or fewer. A Directory is never
+// sharded with n below 1, the default.
+func (d *Directory) SetShardThreshold(n int) {
+	d.threshold = n
+}
+
+// SetHashFunc sets the multihash function of the directory nodes.
+func (d *Directory) SetHashFunc(code int) {
+	d.hashFunc = code
+	if d.shard != nil {
+		d.shard.SetHashFunc(code)
+	} else {
+		d.dirnode.SetHashFunc(code)
+	}
+}
+
 // AddChild adds a (name, key)-pair to the root node.
-func (d *directoryBuilder) AddChild(name string, k u.Key) error {
+func (d *Directory) AddChild(name string, k u.Key) error {
 	// TODO(cryptix): consolidate context managment
 	ctx, cancel := context.WithTimeout(context.TODO(), time.Minute)
 	defer cancel()
@@ -39,15 +105,167 @@ func (d *directoryBuilder) AddChild(name string, k u.Key) error {
 		return err
 	}
 
-	err = d.dirnode.AddNodeLinkClean(name, cnode)
+	return d.AddNode(ctx, name, cnode)
+}
+
+// AddNode links the entry name to nd, replacing any entry of that name.
+func (d *Directory) AddNode(ctx context.Context, name string, nd *mdag.Node) error {
+	if d.shard != nil {
+		added := false
+		if d.entries >= 0 {
+			_, err := d.shard.Find(ctx, name)
+			if err != nil && err != mdag.ErrNotFound {
+				return err
+			}
+			added = err == mdag.ErrNotFound
+		}
+		if err := d.shard.Set(ctx, name, nd); err != nil {
+			return err
+		}
+		if added {
+			d.entries++
+		}
+		return nil
+	}
+
+	err := d.dirnode.RemoveNodeLink(name)
+	if err != nil && err != mdag.ErrNotFound {
+		return err
+	}
+	err = d.dirnode.AddNodeLinkClean(name, nd)
 	if err != nil {
 		return err
 	}
 
+	if d.threshold > 0 && len(d.dirnode.Links) > d.threshold {
+		return d.switchToSharding(ctx)
+	}
 	return nil
 }
 
-// GetNode returns the root of this directoryBuilder
-func (d *directoryBuilder) GetNode() *mdag.Node {
-	return d.dirnode
+func (d *Directory) switchToSharding(ctx context.Context) error {
+	shard, err := hamt.NewShard(d.dserv, hamt.DefaultFanout)
+	if err != nil {
+		return err
+	}
+	shard.SetHashFunc(d.hashFunc)
+	for _, l := range d.dirnode.Links {
+		if err := shard.SetLink(ctx, l.Name, l); err != nil {
+			return err
+		}
+	}
+	d.shard = shard
+	d.entries = len(d.dirnode.Links)
+	d.dirnode = nil
+	return nil
+}
+
+// switchToPlain turns the sharded directory back into a single node, with
+// the entries sorted by name.
+func (d *Directory) switchToPlain(ctx context.Context) error {
+	links, err := d.shard.Links(ctx)
+	if err != nil {
+		return err
+	}
+	sort.Sort(linksByName(links))
+
+	dirnode := NewEmptyDirectory()
+	dirnode.SetHashFunc(d.hashFunc)
+	for _, l := range links {
+		if err := dirnode.AddRawLink(l.Name, l); err != nil {
+			return err
+		}
+	}
+	d.dirnode = dirnode
+	d.shard = nil
+	return nil
+}
+
+type linksByName []*mdag.Link
+
+func (ls linksByName) Len() int           { return len(ls) }
+func (ls linksByName) Swap(i, j int)      { ls[i], ls[j] = ls[j], ls[i] }
+func (ls linksByName) Less(i, j int) bool { return ls[i].Name < ls[j].Name }
+
+// RemoveChild removes the entry name, or returns merkledag.ErrNotFound.
+func (d *Directory) RemoveChild(ctx context.Context, name string) error {
+	if d.shard == nil {
+		return d.dirnode.RemoveNodeLink(name)
+	}
+	if err := d.shard.Remove(ctx, name); err != nil {
+		return err
+	}
+	if d.threshold < 1 {
+		// sharded directories stay so when sharding is off
+		return nil
+	}
+
+	if d.entries < 0 {
+		d.entries = 0
+		err := d.shard.ForEachLink(ctx, func(*mdag.Link) error {
+			d.entries++
+			return nil
+		})
+		if err != nil {
+			d.entries = -1
+			return err
+		}
+	} else {
+		d.entries--
+	}
+	if d.entries <= d.threshold {
+		return d.switchToPlain(ctx)
+	}
+	return nil
+}
+
+// Find returns the link to the entry name, or merkledag.ErrNotFound.
+func (d *Directory) Find(ctx context.Context, name string) (*mdag.Link, error) {
+	if d.shard != nil {
+		return d.shard.Find(ctx, name)
+	}
+	return d.dirnode.GetNodeLink(name)
+}
+
+// Links returns the links to the entries, named by the entries.
+func (d *Directory) Links(ctx context.Context) ([]*mdag.Link, error) {
+	if d.shard != nil {
+		return d.shard.Links(ctx)
+	}
+	return d.dirnode.Links, nil
+}
+
+// GetNode returns the root of this Directory. The nodes of a sharded
+// directory below the root are added to the DAGService.
+func (d *Directory) GetNode() (*mdag.Node, error) {
+	if d.shard != nil {
+		return d.shard.Node()
+	}
+	return d.dirnode, nil
+}
+
+// DirLinks returns the links to the entries of the plain or sharded
+// directory nd, named by the entries.
+func DirLinks(ctx context.Context, dserv mdag.DAGService, nd *mdag.Node) ([]*mdag.Link, error) {
+	if !hamt.IsShard(nd) {
+		return nd.Links, nil
+	}
+	shard, err := hamt.NewShardFromNode(dserv, nd)
+	if err != nil {
+		return nil, err
+	}
+	return shard.Links(ctx)
+}
+
+// FindDirLink returns the link to the entry name of the plain or sharded
+// directory nd, or merkledag.ErrNotFound.
+func FindDirLink(ctx context.Context, dserv mdag.DAGService, nd *mdag.Node, name string) (*mdag.Link, error) {
+	if !hamt.IsShard(nd) {
+		return nd.GetNodeLink(name)
+	}
+	shard, err := hamt.NewShardFromNode(dserv, nd)
+	if err != nil {
+		return nil, err
+	}
+	return shard.Find(ctx, name)
 }
@@ -0,0 +1,74 @@
+package io
+
+import (
+	"testing"
+
+	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+
+	mdag "github.com/ipfs/go-ipfs/merkledag"
+	mdtest "github.com/ipfs/go-ipfs/merkledag/test"
+	hamt "github.com/ipfs/go-ipfs/unixfs/hamt"
+)
+
+func addEntries(t *testing.T, d *Directory, names ...string) {
+	for _, name := range names {
+		if err := d.AddNode(context.Background(), name, &mdag.Node{Data: []byte(name)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func dirKey(t *testing.T, d *Directory) string {
+	nd, err := d.GetNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := nd.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k.B58String()
+}
+
+func TestDirectoryUnshards(t *testing.T) {
+	ctx := context.Background()
+	ds := mdtest.Mock(t)
+
+	d := NewDirectory(ds)
+	d.SetShardThreshold(3)
+	addEntries(t, d, "e0", "e1", "e2", "e3")
+	// replacing an entry does not add one
+	addEntries(t, d, "e3")
+	nd, err := d.GetNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hamt.IsShard(nd) {
+		t.Fatal("expected the directory to be sharded")
+	}
+	if _, err := ds.Add(nd); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, loaded := range []bool{false, true} {
+		d := d
+		if loaded {
+			// the entries of a loaded shard are not known yet
+			d, err = NewDirectoryFromNode(ds, nd)
+			if err != nil {
+				t.Fatal(err)
+			}
+			d.SetShardThreshold(3)
+		}
+		if err := d.RemoveChild(ctx, "e1"); err != nil {
+			t.Fatal(err)
+		}
+
+		plain := NewDirectory(ds)
+		plain.SetShardThreshold(3)
+		addEntries(t, plain, "e0", "e2", "e3")
+		if dirKey(t, d) != dirKey(t, plain) {
+			t.Fatalf("expected the directory to be plain again (loaded: %v)", loaded)
+		}
+	}
+}
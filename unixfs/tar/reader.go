@@ -66,7 +66,7 @@ func (r *Reader) writeToBuf(dagnode *mdag.Node, path string, depth int) {
 		defer r.close()
 	}
 
-	if pb.GetType() == upb.Data_Directory {
+	if pb.GetType() == upb.Data_Directory || pb.GetType() == upb.Data_HAMTShard {
 		err = r.writer.WriteHeader(&tar.Header{
 			Name:     path,
 			Typeflag: tar.TypeDir,
@@ -83,6 +83,23 @@ func (r *Reader) writeToBuf(dagnode *mdag.Node, path string, depth int) {
 		ctx, cancel := context.WithTimeout(context.TODO(), time.Second*60)
 		defer cancel()
 
+		if pb.GetType() == upb.Data_HAMTShard {
+			links, err := uio.DirLinks(ctx, r.dag, dagnode)
+			if err != nil {
+				r.emitError(err)
+				return
+			}
+			for _, l := range links {
+				childNode, err := l.GetNode(ctx, r.dag)
+				if err != nil {
+					r.emitError(err)
+					return
+				}
+				r.writeToBuf(childNode, gopath.Join(path, l.Name), depth+1)
+			}
+			return
+		}
+
 		for i, ng := range r.dag.GetDAG(ctx, dagnode) {
 			childNode, err := ng.Get(ctx)
 			if err != nil {
@@ -0,0 +1,159 @@
+package datastore2
+
+import (
+	"sync"
+
+	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
+)
+
+// Batching is implemented by datastores that can group several writes and
+// apply them together, which is usually much cheaper than applying them one
+// at a time.
+//
+// Batches are NOT transactions: a failed Commit may leave some of the
+// writes applied.
+type Batching interface {
+	datastore.Datastore
+
+	// Batch returns a new, empty batch of writes for this datastore.
+	Batch() (Batch, error)
+}
+
+// Batch accumulates writes until Commit is called. Writes are not visible
+// through the datastore before then.
+type Batch interface {
+	Put(key datastore.Key, val interface{}) error
+
+	Delete(key datastore.Key) error
+
+	Commit() error
+}
+
+// BatchFor returns a batch for d, using its native batching if it has any.
+func BatchFor(d datastore.Datastore) (Batch, error) {
+	if bd, ok := d.(Batching); ok {
+		return bd.Batch()
+	}
+	return NewBasicBatch(d), nil
+}
+
+// basicBatch implements Batch for datastores without native batching, by
+// replaying the writes on Commit.
+type basicBatch struct {
+	puts    map[datastore.Key]interface{}
+	deletes map[datastore.Key]struct{}
+
+	target  datastore.Datastore
+	workers int
+}
+
+// NewBasicBatch returns a Batch that applies its writes to d one by one
+// when committed.
+func NewBasicBatch(d datastore.Datastore) Batch {
+	return NewParallelBatch(d, 1)
+}
+
+// NewParallelBatch returns a Batch that applies its puts to d from up to
+// |workers| goroutines at a time when committed, which hides the latency of
+// datastores that sync every put, like flatfs. d must be safe for
+// concurrent use.
+func NewParallelBatch(d datastore.Datastore, workers int) Batch {
+	if workers < 1 {
+		workers = 1
+	}
+	return &basicBatch{
+		puts:    make(map[datastore.Key]interface{}),
+		deletes: make(map[datastore.Key]struct{}),
+		target:  d,
+		workers: workers,
+	}
+}
+
+func (bt *basicBatch) Put(key datastore.Key, val interface{}) error {
+	delete(bt.deletes, key)
+	bt.puts[key] = val
+	return nil
+}
+
+func (bt *basicBatch) Delete(key datastore.Key) error {
+	delete(bt.puts, key)
+	bt.deletes[key] = struct{}{}
+	return nil
+}
+
+func (bt *basicBatch) Commit() error {
+	if err := bt.commitPuts(); err != nil {
+		return err
+	}
+	for k := range bt.deletes {
+		if err := bt.target.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (bt *basicBatch) commitPuts() error {
+	if bt.workers == 1 {
+		for k, val := range bt.puts {
+			if err := bt.target.Put(k, val); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	type put struct {
+		key datastore.Key
+		val interface{}
+	}
+	puts := make(chan put)
+	errs := make(chan error, bt.workers)
+	var wg sync.WaitGroup
+	for i := 0; i < bt.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range puts {
+				if err := bt.target.Put(p.key, p.val); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+
+	var err error
+Loop:
+	for k, val := range bt.puts {
+		select {
+		case puts <- put{k, val}:
+		case err = <-errs:
+			break Loop
+		}
+	}
+	close(puts)
+	wg.Wait()
+	if err != nil {
+		return err
+	}
+	select {
+	case err = <-errs:
+		return err
+	default:
+		return nil
+	}
+}
+
+// ParallelBatching gives a thread-safe datastore without native batching
+// batches that commit their puts from up to Workers goroutines at a time.
+type ParallelBatching struct {
+	datastore.ThreadSafeDatastore
+	Workers int
+}
+
+var _ Batching = ParallelBatching{}
+
+func (d ParallelBatching) Batch() (Batch, error) {
+	return NewParallelBatch(d.ThreadSafeDatastore, d.Workers), nil
+}
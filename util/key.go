@@ -7,6 +7,7 @@ import (
 	b58 "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-base58"
 	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
 	mh "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-multihash"
+	blake2b "github.com/ipfs/go-ipfs/thirdparty/blake2b"
 )
 
 // Key is a string representation of multihash for use with maps.
@@ -118,6 +119,33 @@ func Hash(data []byte) mh.Multihash {
 	return h
 }
 
+// HashFuncs maps the names of the multihash functions content can be
+// hashed with to their codes.
+var HashFuncs = map[string]int{
+	"sha2-256": mh.SHA2_256,
+	"sha2-512": mh.SHA2_512,
+	"sha3":     mh.SHA3,
+	"blake2b":  mh.BLAKE2B,
+}
+
+// Sum hashes data with the multihash function code, keeping length bytes
+// of the digest (-1 for the default length of the function). Unlike
+// mh.Sum, it supports BLAKE2b.
+func Sum(data []byte, code int, length int) (mh.Multihash, error) {
+	if code != mh.BLAKE2B {
+		return mh.Sum(data, code, length)
+	}
+
+	d := blake2b.Sum512(data)
+	if length < 0 {
+		length = len(d)
+	}
+	if length > len(d) {
+		return nil, fmt.Errorf("blake2b digests are at most %d bytes", len(d))
+	}
+	return mh.Encode(d[:length], code)
+}
+
 // IsValidHash checks whether a given hash is valid (b58 decodable, len > 0)
 func IsValidHash(s string) bool {
 	out := b58.Decode(s)